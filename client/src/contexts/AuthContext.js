@@ -1,5 +1,5 @@
 import React, { createContext, useContext, useState, useEffect } from 'react';
-import api from '../services/api';
+import api, { TOKEN_EVENT, setTokens, clearTokens } from '../services/api';
 
 const AuthContext = createContext();
 
@@ -27,8 +27,7 @@ export const AuthProvider = ({ children }) => {
           setToken(storedToken);
         } catch (error) {
           console.error('Auth initialization failed:', error);
-          localStorage.removeItem('token');
-          delete api.defaults.headers.common['Authorization'];
+          clearTokens();
         }
       }
       setLoading(false);
@@ -37,13 +36,21 @@ export const AuthProvider = ({ children }) => {
     initAuth();
   }, []);
 
+  // Keep the token in state current when the API client refreshes it, so
+  // WebSocket connections reconnect with the new one.
+  useEffect(() => {
+    const handleToken = (event) => setToken(event.detail);
+
+    window.addEventListener(TOKEN_EVENT, handleToken);
+    return () => window.removeEventListener(TOKEN_EVENT, handleToken);
+  }, []);
+
   const login = async (email, password) => {
     try {
       const response = await api.post('/auth/login', { email, password });
-      const { access_token, user: userData } = response.data;
+      const { access_token, refresh_token, user: userData } = response.data;
       
-      localStorage.setItem('token', access_token);
-      api.defaults.headers.common['Authorization'] = `Bearer ${access_token}`;
+      setTokens(access_token, refresh_token);
       
       setUser(userData);
       setToken(access_token);
@@ -62,10 +69,9 @@ export const AuthProvider = ({ children }) => {
         username, 
         password 
       });
-      const { access_token, user: userData } = response.data;
+      const { access_token, refresh_token, user: userData } = response.data;
       
-      localStorage.setItem('token', access_token);
-      api.defaults.headers.common['Authorization'] = `Bearer ${access_token}`;
+      setTokens(access_token, refresh_token);
       
       setUser(userData);
       setToken(access_token);
@@ -78,8 +84,14 @@ export const AuthProvider = ({ children }) => {
   };
 
   const logout = () => {
-    localStorage.removeItem('token');
-    delete api.defaults.headers.common['Authorization'];
+    // Revoke the session server-side; the local tokens go either way.
+    const currentToken = localStorage.getItem('token');
+    if (currentToken) {
+      api
+        .post('/auth/logout', null, { headers: { Authorization: `Bearer ${currentToken}` } })
+        .catch(() => {});
+    }
+    clearTokens();
     setUser(null);
     setToken(null);
   };
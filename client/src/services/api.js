@@ -24,15 +24,100 @@ api.interceptors.request.use(
   }
 );
 
+export const TOKEN_EVENT = 'auth:token';
+
+export const setTokens = (accessToken, refreshToken) => {
+  localStorage.setItem('token', accessToken);
+  if (refreshToken) {
+    localStorage.setItem('refreshToken', refreshToken);
+  }
+  api.defaults.headers.common['Authorization'] = `Bearer ${accessToken}`;
+  window.dispatchEvent(new CustomEvent(TOKEN_EVENT, { detail: accessToken }));
+};
+
+export const clearTokens = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refreshToken');
+  delete api.defaults.headers.common['Authorization'];
+};
+
+// Requests that must not trigger a token refresh when they fail with 401.
+const NO_REFRESH_URLS = ['/auth/login', '/auth/login/2fa', '/auth/register', '/auth/refresh'];
+
+// Access tokens are short-lived, so a 401 usually only means the token has
+// expired. Concurrent failures share a single refresh request.
+let refreshPromise = null;
+
+// Refresh tokens are single use and the server signs the session out when
+// one is presented twice, so tabs take turns refreshing. A tab that waited
+// for another one uses the tokens it stored instead of refreshing again.
+const REFRESH_LOCK = 'auth:refresh';
+
+const refreshAccessToken = () => {
+  if (!refreshPromise) {
+    const staleToken = localStorage.getItem('refreshToken');
+    if (!staleToken) {
+      return Promise.reject(new Error('No refresh token'));
+    }
+
+    const refresh = () => {
+      const refreshToken = localStorage.getItem('refreshToken');
+      if (!refreshToken) {
+        return Promise.reject(new Error('No refresh token'));
+      }
+      if (refreshToken !== staleToken) {
+        const accessToken = localStorage.getItem('token');
+        setTokens(accessToken);
+        return Promise.resolve(accessToken);
+      }
+
+      return axios
+        .post(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken })
+        .then((response) => {
+          const { access_token, refresh_token } = response.data;
+          setTokens(access_token, refresh_token);
+          return access_token;
+        });
+    };
+
+    const locked = navigator.locks
+      ? navigator.locks.request(REFRESH_LOCK, refresh)
+      : refresh();
+
+    refreshPromise = locked.finally(() => {
+      refreshPromise = null;
+    });
+  }
+
+  return refreshPromise;
+};
+
 // Response interceptor
 api.interceptors.response.use(
   (response) => {
     return response;
   },
-  (error) => {
+  async (error) => {
+    const original = error.config;
+
+    if (
+      error.response?.status === 401 &&
+      original &&
+      !original._retried &&
+      !NO_REFRESH_URLS.includes(original.url)
+    ) {
+      original._retried = true;
+      try {
+        const accessToken = await refreshAccessToken();
+        original.headers.Authorization = `Bearer ${accessToken}`;
+        return api(original);
+      } catch (refreshError) {
+        // Fall through to the logout below.
+      }
+    }
+
     if (error.response?.status === 401) {
-      localStorage.removeItem('token');
-      delete api.defaults.headers.common['Authorization'];
+      clearTokens();
       window.location.href = '/login';
     }
     
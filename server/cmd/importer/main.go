@@ -0,0 +1,129 @@
+// Command importer loads chat history exported from another chat tool.
+//
+//	importer [-format native|slack] [-source name] <path>
+//
+// The native format is a JSON file described in package importer. Slack
+// exports are read from the zip file or the folder it was unpacked to. An
+// import can be run again: it resumes after an interruption and skips what
+// is already imported.
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"onlineChat/internal/importer"
+	"onlineChat/pkg/config"
+	"onlineChat/pkg/db"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	format := flag.String("format", "native", "archive format: native or slack")
+	source := flag.String("source", "slack", "name of the Slack workspace, used to recognise records already imported")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-format native|slack] [-source name] <path>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	// The environment may be configured without a .env file.
+	_ = godotenv.Load()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: time.RFC3339,
+	})
+	if level, err := logrus.ParseLevel(cfg.Logging.Level); err == nil {
+		logger.SetLevel(level)
+	}
+
+	var archive *importer.Archive
+	switch *format {
+	case "native":
+		archive, err = loadNative(path)
+	case "slack":
+		archive, err = loadSlack(path, *source)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to read archive")
+	}
+
+	database, err := db.Open(cfg.Database)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to database")
+	}
+	defer database.Close()
+
+	if err := database.Ping(); err != nil {
+		logger.WithError(err).Fatal("Failed to ping database")
+	}
+
+	logger.WithFields(logrus.Fields{
+		"source": archive.Source,
+		"users":  len(archive.Users),
+		"chats":  len(archive.Chats),
+	}).Info("Starting import")
+
+	result, err := importer.NewImporter(database, logger).Import(archive)
+	fields := logrus.Fields{
+		"users_created":     result.UsersCreated,
+		"users_matched":     result.UsersMatched,
+		"chats_created":     result.ChatsCreated,
+		"messages_imported": result.MessagesImported,
+		"messages_skipped":  result.MessagesSkipped,
+	}
+	if err != nil {
+		logger.WithError(err).WithFields(fields).Fatal("Import stopped; run it again to resume")
+	}
+
+	logger.WithFields(fields).Info("Import finished")
+}
+
+func loadNative(path string) (*importer.Archive, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return importer.LoadArchive(file)
+}
+
+func loadSlack(path, source string) (*importer.Archive, error) {
+	var fsys fs.FS
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		fsys = reader
+	} else {
+		fsys = os.DirFS(path)
+	}
+
+	return importer.LoadSlack(fsys, source)
+}
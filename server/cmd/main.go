@@ -9,8 +9,13 @@ import (
 	"onlineChat/internal/ws"
 	"onlineChat/pkg/config"
 	"onlineChat/pkg/db"
+	"onlineChat/pkg/jwtauth"
+	"onlineChat/pkg/mailer"
+	"onlineChat/pkg/middleware"
+	"onlineChat/pkg/redis"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -76,35 +81,119 @@ func main() {
 	}
 	logger.Info("Database connection established")
 
+	redisClient := redis.NewRedisClient(redis.RedisConfig{
+		Address:  cfg.Redis.Address,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	}, logger)
+	defer redisClient.Close()
+
 	userRepo := users.NewUserRepository(database, logger)
 	chatRepo := ws.NewChatRepository(database, logger)
 
+	tokenKeys, err := jwtauth.NewKeySet(cfg.JWT)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load JWT keys")
+	}
+	if tokenKeys.Symmetric() {
+		logger.Warn("JWT_SIGNING_KEY_FILE not set, signing access tokens with the shared HS256 secret")
+	}
+
+	avatarDir := filepath.Join(cfg.Upload.UploadPath, "avatars")
+	exportDir := filepath.Join(cfg.Upload.UploadPath, "exports")
+	chatExportDir := filepath.Join(cfg.Upload.UploadPath, "chat-exports")
+
+	exportLinkSecret := cfg.Upload.ExportLinkSecret
+	if exportLinkSecret == "" {
+		exportLinkSecret = cfg.JWT.Secret
+	}
+
+	loginGuard := users.NewLoginGuard(redisClient, userRepo, cfg.Lockout, logger)
+
 	userService := users.NewUserService(
 		userRepo,
-		cfg.JWT.Secret,
-		time.Duration(cfg.JWT.ExpireHours)*time.Hour,
+		loginGuard,
+		redisClient,
+		mailer.New(cfg.Mail, logger),
+		users.NewOIDCProvider(cfg.OIDC, logger),
+		users.ServiceConfig{
+			TokenKeys:        tokenKeys,
+			AccessTokenTTL:   cfg.JWT.AccessTokenTTL,
+			RefreshTokenTTL:  cfg.JWT.RefreshTokenTTL,
+			AppURL:           cfg.Server.AppURL,
+			PasswordResetTTL: cfg.Auth.PasswordResetTTL,
+			MailThrottle:     cfg.Auth.MailThrottle,
+
+			EmailVerificationTTL: cfg.Auth.EmailVerificationTTL,
+			UnverifiedPolicy:     cfg.Auth.UnverifiedPolicy,
+
+			TwoFactorIssuer:       cfg.Auth.TwoFactorIssuer,
+			TwoFactorChallengeTTL: cfg.Auth.TwoFactorChallengeTTL,
+
+			AccountDeletionGrace: cfg.Auth.AccountDeletionGrace,
+
+			AvatarDir:      avatarDir,
+			AvatarBaseURL:  cfg.Upload.AvatarBaseURL,
+			AvatarSize:     cfg.Upload.AvatarSize,
+			AvatarMaxBytes: cfg.Upload.MaxFileSize,
+
+			ExportDir:        exportDir,
+			ExportTTL:        cfg.Upload.ExportTTL,
+			ExportLinkTTL:    cfg.Upload.ExportLinkTTL,
+			ExportLinkSecret: []byte(exportLinkSecret),
+		},
 		logger,
 	)
 
-	chatService := ws.NewChatService(chatRepo, logger)
+	go userService.RunDataExportWorker(time.Hour)
+	go userService.RunAccountDeletionWorker(time.Minute)
+
+	chatService := ws.NewChatService(
+		chatRepo,
+		redisClient,
+		ws.ServiceConfig{
+			ExportDir:       chatExportDir,
+			ExportTTL:       cfg.Upload.ExportTTL,
+			ExportSyncLimit: cfg.Chat.ExportSyncLimit,
+			MaxPins:         cfg.Chat.MaxPins,
+		},
+		logger,
+	)
+	go chatService.RunChatExportWorker(time.Hour)
 
-	hub := ws.NewHub(cfg.Redis, chatService, logger)
+	hub := ws.NewHub(redisClient, cfg.Chat, chatService, logger)
 	go hub.Run()
 
 	userHandler := users.NewUserHandler(userService, logger)
 	chatHandler := ws.NewChatHandler(hub, chatService, logger)
 
+	var rateLimitStore middleware.RateLimitStore = middleware.NewRedisRateLimitStore(redisClient)
+	if cfg.Security.RateLimitStore == "memory" {
+		rateLimitStore = middleware.NewMemoryRateLimitStore()
+	}
+
 	routeConfig := &routes.Config{
 		JWT: routes.JWTConfig{
-			Secret: cfg.JWT.Secret,
+			Keys:           tokenKeys,
+			PersonalTokens: userService,
 		},
 		Security: routes.SecurityConfig{
-			CORSOrigin:        cfg.Security.CORSOrigin,
-			RateLimitRequests: cfg.Security.RateLimitRequests,
+			CORSOrigin:               cfg.Security.CORSOrigin,
+			RateLimitRequests:        cfg.Security.RateLimitRequests,
+			RateLimitWindow:          cfg.Security.RateLimitWindow,
+			RateLimitStore:           rateLimitStore,
+			AuthRateLimitRequests:    cfg.Security.AuthRateLimitRequests,
+			AuthRateLimitWindow:      cfg.Security.AuthRateLimitWindow,
+			RefreshRateLimitRequests: cfg.Security.RefreshRateLimitRequests,
+			RefreshRateLimitWindow:   cfg.Security.RefreshRateLimitWindow,
+			TrustedProxies:           cfg.Security.TrustedProxies,
+		},
+		Upload: routes.UploadConfig{
+			AvatarDir: avatarDir,
 		},
 	}
 
-	router := routes.SetupRoutes(userHandler, chatHandler, routeConfig, logger)
+	router := routes.SetupRoutes(userHandler, chatHandler, redisClient, routeConfig, logger)
 
 	server := &Server{
 		logger: logger,
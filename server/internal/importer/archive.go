@@ -0,0 +1,162 @@
+// Package importer loads chat history exported from other chat tools.
+//
+// Imports read an Archive, the documented JSON format below. Adapters such
+// as LoadSlack convert other exports to it.
+//
+//	{
+//	  "source": "acme-slack",
+//	  "users": [
+//	    {"id": "U1", "username": "alice", "display_name": "Alice", "email": "alice@example.com"},
+//	    {"id": "U2", "username": "deploybot", "is_bot": true}
+//	  ],
+//	  "chats": [
+//	    {
+//	      "id": "C1",
+//	      "name": "general",
+//	      "description": "Company wide",
+//	      "is_private": false,
+//	      "is_direct": false,
+//	      "created_by": "U1",
+//	      "created_at": "2021-03-01T09:00:00Z",
+//	      "members": ["U1", "U2"],
+//	      "messages": [
+//	        {"id": "1", "user_id": "U1", "content": "Hello", "created_at": "2021-03-01T09:01:00Z"},
+//	        {"id": "2", "user_id": "U2", "content": "Hi!", "reply_to": "1",
+//	         "created_at": "2021-03-01T09:02:00Z", "edited_at": "2021-03-01T09:03:00Z"}
+//	      ]
+//	    }
+//	  ]
+//	}
+//
+// Source names the origin of the archive. IDs only need to be unique within
+// it, and message IDs only within their chat. Users with an email address
+// that already has an account are mapped to that account; the others get a
+// deactivated placeholder account. Message types are those of chat messages
+// (text, image, file or system) and default to text.
+//
+// Every user, chat and message created is recorded against its source and
+// ID, so running the same import again skips what is already there. An
+// interrupted import resumes where it stopped, and a newer export of the same
+// source only adds the new history.
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type Archive struct {
+	Source string        `json:"source"`
+	Users  []ArchiveUser `json:"users"`
+	Chats  []ArchiveChat `json:"chats"`
+}
+
+type ArchiveUser struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	Email       string `json:"email,omitempty"`
+	IsBot       bool   `json:"is_bot,omitempty"`
+}
+
+// ArchiveChat is a chat with its history. Members default to the authors of
+// its messages, and CreatedBy to the first member.
+type ArchiveChat struct {
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	IsPrivate   bool             `json:"is_private,omitempty"`
+	IsDirect    bool             `json:"is_direct,omitempty"`
+	CreatedBy   string           `json:"created_by,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Members     []string         `json:"members,omitempty"`
+	Messages    []ArchiveMessage `json:"messages"`
+}
+
+type ArchiveMessage struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Content   string     `json:"content"`
+	Type      string     `json:"type,omitempty"`
+	ReplyTo   string     `json:"reply_to,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+}
+
+// LoadArchive reads an archive in the documented format.
+func LoadArchive(r io.Reader) (*Archive, error) {
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("failed to parse archive: %w", err)
+	}
+
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+// Validate checks that IDs are set and unique and that every reference to a
+// user points to one in the archive.
+func (a *Archive) Validate() error {
+	if a.Source == "" {
+		return fmt.Errorf("archive has no source")
+	}
+	if len(a.Source) > 50 {
+		return fmt.Errorf("archive source is longer than 50 characters")
+	}
+
+	users := make(map[string]bool, len(a.Users))
+	for _, user := range a.Users {
+		if user.ID == "" {
+			return fmt.Errorf("user without an id")
+		}
+		if users[user.ID] {
+			return fmt.Errorf("duplicate user %q", user.ID)
+		}
+		users[user.ID] = true
+	}
+
+	chats := make(map[string]bool, len(a.Chats))
+	for _, chat := range a.Chats {
+		if chat.ID == "" {
+			return fmt.Errorf("chat without an id")
+		}
+		if chats[chat.ID] {
+			return fmt.Errorf("duplicate chat %q", chat.ID)
+		}
+		chats[chat.ID] = true
+
+		if chat.CreatedBy != "" && !users[chat.CreatedBy] {
+			return fmt.Errorf("chat %q: unknown creator %q", chat.ID, chat.CreatedBy)
+		}
+		for _, member := range chat.Members {
+			if !users[member] {
+				return fmt.Errorf("chat %q: unknown member %q", chat.ID, member)
+			}
+		}
+
+		messages := make(map[string]bool, len(chat.Messages))
+		for _, message := range chat.Messages {
+			if message.ID == "" {
+				return fmt.Errorf("chat %q: message without an id", chat.ID)
+			}
+			if messages[message.ID] {
+				return fmt.Errorf("chat %q: duplicate message %q", chat.ID, message.ID)
+			}
+			messages[message.ID] = true
+
+			if !users[message.UserID] {
+				return fmt.Errorf("chat %q: message %q has unknown user %q", chat.ID, message.ID, message.UserID)
+			}
+			if message.CreatedAt.IsZero() {
+				return fmt.Errorf("chat %q: message %q has no created_at", chat.ID, message.ID)
+			}
+		}
+	}
+
+	return nil
+}
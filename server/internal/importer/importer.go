@@ -0,0 +1,533 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// messageBatchSize is how many messages are inserted per statement and
+// transaction. An interrupted import loses at most one batch of work.
+const messageBatchSize = 500
+
+// Column limits of the tables imported into.
+const (
+	maxUsernameLength = 50
+	maxChatNameLength = 100
+	maxChatMembers    = 1000
+	maxMessageLength  = 4000
+)
+
+// placeholderPassword is stored for placeholder accounts. It is not a bcrypt
+// hash, so no password ever matches it.
+const placeholderPassword = "!"
+
+const (
+	kindUser    = "user"
+	kindChat    = "chat"
+	kindMessage = "message"
+)
+
+// Result counts what an import created. Records already imported by an
+// earlier run are not counted.
+type Result struct {
+	UsersCreated     int
+	UsersMatched     int
+	ChatsCreated     int
+	MessagesImported int
+	MessagesSkipped  int
+}
+
+type Importer struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+func NewImporter(db *sql.DB, logger *logrus.Logger) *Importer {
+	return &Importer{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Import writes the archive to the database: users first, then each chat
+// with its members and messages. It can be run again with the same archive.
+func (im *Importer) Import(archive *Archive) (*Result, error) {
+	result := &Result{}
+
+	users, err := im.importUsers(archive, result)
+	if err != nil {
+		return result, err
+	}
+
+	chats, err := im.getMappings(archive.Source, kindChat, "", "chats")
+	if err != nil {
+		return result, err
+	}
+
+	for _, chat := range archive.Chats {
+		if err := im.importChat(archive.Source, chat, chats, users, result); err != nil {
+			return result, fmt.Errorf("chat %q: %w", chat.ID, err)
+		}
+	}
+
+	return result, nil
+}
+
+// importUsers maps every user of the archive to a local user ID, creating
+// placeholder accounts as needed.
+func (im *Importer) importUsers(archive *Archive, result *Result) (map[string]int, error) {
+	users, err := im.getMappings(archive.Source, kindUser, "", "users")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range archive.Users {
+		if _, ok := users[user.ID]; ok {
+			continue
+		}
+
+		localID, created, err := im.importUser(archive.Source, user)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", user.ID, err)
+		}
+		users[user.ID] = localID
+
+		if created {
+			result.UsersCreated++
+		} else {
+			result.UsersMatched++
+		}
+	}
+
+	return users, nil
+}
+
+func (im *Importer) importUser(source string, user ArchiveUser) (int, bool, error) {
+	tx, err := im.db.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var localID int
+	created := false
+
+	err = sql.ErrNoRows
+	if user.Email != "" {
+		err = tx.QueryRow(`SELECT id FROM users WHERE LOWER(email) = LOWER($1) AND is_active = true`, user.Email).Scan(&localID)
+	}
+	if err == sql.ErrNoRows {
+		localID, err = im.createPlaceholder(tx, source, user)
+		created = true
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err := saveMapping(tx, source, kindUser, "", user.ID, localID); err != nil {
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	im.logger.WithFields(logrus.Fields{
+		"external_id": user.ID,
+		"user_id":     localID,
+		"created":     created,
+	}).Debug("Imported user")
+
+	return localID, created, nil
+}
+
+var (
+	invalidUsernameChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+	invalidEmailChars    = regexp.MustCompile(`[^A-Za-z0-9.+-]+`)
+)
+
+// createPlaceholder creates a deactivated account for an archive user, with
+// a username based on theirs and an address that cannot receive mail.
+func (im *Importer) createPlaceholder(tx *sql.Tx, source string, user ArchiveUser) (int, error) {
+	base := invalidUsernameChars.ReplaceAllString(user.Username, "_")
+	base = strings.Trim(base, "_")
+	if base == "" {
+		base = "imported_" + invalidUsernameChars.ReplaceAllString(user.ID, "_")
+	}
+	if len(base) < 3 {
+		base += "___"[:3-len(base)]
+	}
+	if len(base) > maxUsernameLength-6 {
+		base = base[:maxUsernameLength-6]
+	}
+
+	username := base
+	for n := 2; ; n++ {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`, username).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("failed to check username: %w", err)
+		}
+		if !exists {
+			break
+		}
+		username = fmt.Sprintf("%s_%d", base, n)
+	}
+
+	local := strings.ToLower(invalidEmailChars.ReplaceAllString(source+"."+user.ID, "."))
+	email := fmt.Sprintf("%s@imported.invalid", strings.Trim(local, "."))
+
+	var displayName *string
+	if user.DisplayName != "" {
+		name := truncate(user.DisplayName, 100)
+		displayName = &name
+	}
+
+	now := time.Now()
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO users (email, username, password_hash, display_name, created_at, updated_at, is_active, is_bot)
+		VALUES ($1, $2, $3, $4, $5, $5, false, $6)
+		RETURNING id
+	`, email, username, placeholderPassword, displayName, now, user.IsBot).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create placeholder user: %w", err)
+	}
+
+	return id, nil
+}
+
+// importChat creates the chat unless an earlier run did, makes sure its
+// members belong to it and imports the messages not imported yet.
+func (im *Importer) importChat(source string, chat ArchiveChat, chats, users map[string]int, result *Result) error {
+	members := chat.Members
+	if len(members) == 0 {
+		seen := map[string]bool{}
+		for _, message := range chat.Messages {
+			if !seen[message.UserID] {
+				seen[message.UserID] = true
+				members = append(members, message.UserID)
+			}
+		}
+	}
+
+	creator := chat.CreatedBy
+	if creator == "" && len(members) > 0 {
+		creator = members[0]
+	}
+	if creator == "" {
+		im.logger.WithField("external_id", chat.ID).Warn("Skipping chat without members")
+		return nil
+	}
+
+	chatID, ok := chats[chat.ID]
+	if !ok {
+		var err error
+		if chatID, err = im.createChat(source, chat, users[creator]); err != nil {
+			return err
+		}
+		chats[chat.ID] = chatID
+		result.ChatsCreated++
+	}
+
+	if err := im.addMembers(chatID, chat, members, creator, users); err != nil {
+		return err
+	}
+
+	return im.importMessages(source, chat, chatID, users, result)
+}
+
+func (im *Importer) createChat(source string, chat ArchiveChat, ownerID int) (int, error) {
+	tx, err := im.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	name := truncate(strings.TrimSpace(chat.Name), maxChatNameLength)
+	if chat.IsDirect {
+		name = "Direct message"
+	}
+	if name == "" {
+		name = truncate(chat.ID, maxChatNameLength)
+	}
+
+	var description *string
+	if chat.Description != "" {
+		description = &chat.Description
+	}
+
+	maxMembers := 100
+	if chat.IsDirect {
+		maxMembers = 2
+	} else if len(chat.Members) > maxMembers {
+		maxMembers = min(len(chat.Members), maxChatMembers)
+	}
+
+	createdAt := chat.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	var chatID int
+	err = tx.QueryRow(`
+		INSERT INTO chats (name, description, created_by, created_at, updated_at,
+		                   is_private, is_active, max_members, current_members, is_direct)
+		VALUES ($1, $2, $3, $4, $4, $5, true, $6, 0, $7)
+		RETURNING id
+	`, name, description, ownerID, createdAt, chat.IsPrivate || chat.IsDirect, maxMembers, chat.IsDirect).Scan(&chatID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	if err := saveMapping(tx, source, kindChat, "", chat.ID, chatID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	im.logger.WithFields(logrus.Fields{
+		"external_id": chat.ID,
+		"chat_id":     chatID,
+	}).Info("Imported chat")
+
+	return chatID, nil
+}
+
+// addMembers adds the members that are not in the chat yet, as of the time
+// the chat was created. The creator owns group chats.
+func (im *Importer) addMembers(chatID int, chat ArchiveChat, members []string, creator string, users map[string]int) error {
+	joinedAt := chat.CreatedAt
+	if joinedAt.IsZero() {
+		joinedAt = time.Now()
+	}
+
+	for _, member := range members {
+		role := "member"
+		if member == creator && !chat.IsDirect {
+			role = "owner"
+		}
+
+		_, err := im.db.Exec(`
+			INSERT INTO user_chat (user_id, chat_id, role, joined_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, chat_id) DO NOTHING
+		`, users[member], chatID, role, joinedAt)
+		if err != nil {
+			return fmt.Errorf("failed to add chat member: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// importMessages inserts the messages of chat not imported yet, oldest
+// first, in batches. Replies to messages further on in the history are
+// linked once their parent is inserted.
+func (im *Importer) importMessages(source string, chat ArchiveChat, chatID int, users map[string]int, result *Result) error {
+	imported, err := im.getMappings(source, kindMessage, chat.ID, "messages")
+	if err != nil {
+		return err
+	}
+
+	messages := make([]ArchiveMessage, 0, len(chat.Messages))
+	replies := map[string][]string{}
+	for _, message := range chat.Messages {
+		if message.ReplyTo != "" {
+			replies[message.ReplyTo] = append(replies[message.ReplyTo], message.ID)
+		}
+		if _, ok := imported[message.ID]; !ok {
+			messages = append(messages, message)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	var batch []ArchiveMessage
+	for _, message := range messages {
+		message.Content = strings.TrimSpace(message.Content)
+		if message.Content == "" {
+			result.MessagesSkipped++
+			continue
+		}
+
+		batch = append(batch, message)
+		if len(batch) == messageBatchSize {
+			if err := im.insertMessages(source, chat.ID, chatID, batch, imported, replies, users); err != nil {
+				return err
+			}
+			result.MessagesImported += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := im.insertMessages(source, chat.ID, chatID, batch, imported, replies, users); err != nil {
+			return err
+		}
+		result.MessagesImported += len(batch)
+	}
+
+	return nil
+}
+
+// insertMessages inserts a batch in one transaction with its mappings. IDs
+// are taken from the sequence first so replies within the batch can point to
+// each other. imported gains the new messages once committed.
+func (im *Importer) insertMessages(source, externalChatID string, chatID int, batch []ArchiveMessage,
+	imported map[string]int, replies map[string][]string, users map[string]int) error {
+	tx, err := im.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT nextval('messages_id_seq') FROM generate_series(1, $1)`, len(batch))
+	if err != nil {
+		return fmt.Errorf("failed to allocate message ids: %w", err)
+	}
+	ids := make(map[string]int, len(batch))
+	for i := 0; rows.Next(); i++ {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to allocate message ids: %w", err)
+		}
+		ids[batch[i].ID] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to allocate message ids: %w", err)
+	}
+
+	localID := func(externalID string) (int, bool) {
+		if id, ok := ids[externalID]; ok {
+			return id, true
+		}
+		id, ok := imported[externalID]
+		return id, ok
+	}
+
+	var values []string
+	var args []interface{}
+	for _, message := range batch {
+		var replyToID *int
+		if id, ok := localID(message.ReplyTo); ok && message.ReplyTo != "" {
+			replyToID = &id
+		}
+
+		messageType := message.Type
+		switch messageType {
+		case "text", "image", "file", "system":
+		default:
+			messageType = "text"
+		}
+
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+8))
+		args = append(args, ids[message.ID], chatID, users[message.UserID],
+			truncate(message.Content, maxMessageLength), messageType, replyToID, message.EditedAt, message.CreatedAt)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO messages (id, chat_id, user_id, content, message_type, reply_to_id,
+		                      edited_at, created_at, updated_at)
+		VALUES `+strings.Join(values, ", "), args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert messages: %w", err)
+	}
+
+	for _, message := range batch {
+		if err := saveMapping(tx, source, kindMessage, externalChatID, message.ID, ids[message.ID]); err != nil {
+			return err
+		}
+
+		// Replies imported before their parent, in an earlier batch or run.
+		for _, reply := range replies[message.ID] {
+			replyID, ok := imported[reply]
+			if !ok {
+				continue
+			}
+			if _, err := tx.Exec(`UPDATE messages SET reply_to_id = $1 WHERE id = $2`, ids[message.ID], replyID); err != nil {
+				return fmt.Errorf("failed to link reply: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	for externalID, id := range ids {
+		imported[externalID] = id
+	}
+
+	im.logger.WithFields(logrus.Fields{
+		"chat_id":  chatID,
+		"messages": len(batch),
+	}).Debug("Imported messages")
+
+	return nil
+}
+
+// getMappings returns the local IDs of the records of kind already imported
+// from source, leaving out those whose row in table has since been deleted.
+func (im *Importer) getMappings(source, kind, scope, table string) (map[string]int, error) {
+	rows, err := im.db.Query(`
+		SELECT m.external_id, m.local_id
+		FROM import_mappings m
+		INNER JOIN `+table+` t ON t.id = m.local_id
+		WHERE m.source = $1 AND m.kind = $2 AND m.scope = $3
+	`, source, kind, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s mappings: %w", kind, err)
+	}
+	defer rows.Close()
+
+	mappings := make(map[string]int)
+	for rows.Next() {
+		var externalID string
+		var localID int
+		if err := rows.Scan(&externalID, &localID); err != nil {
+			return nil, fmt.Errorf("failed to scan %s mapping: %w", kind, err)
+		}
+		mappings[externalID] = localID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s mappings: %w", kind, err)
+	}
+
+	return mappings, nil
+}
+
+func saveMapping(tx *sql.Tx, source, kind, scope, externalID string, localID int) error {
+	_, err := tx.Exec(`
+		INSERT INTO import_mappings (source, kind, scope, external_id, local_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (source, kind, scope, external_id) DO UPDATE
+		SET local_id = EXCLUDED.local_id, created_at = EXCLUDED.created_at
+	`, source, kind, scope, externalID, localID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save %s mapping: %w", kind, err)
+	}
+
+	return nil
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
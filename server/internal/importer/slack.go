@@ -0,0 +1,348 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	IsBot   bool   `json:"is_bot"`
+	Profile struct {
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+		Email       string `json:"email"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Created int64    `json:"created"`
+	Creator string   `json:"creator"`
+	Members []string `json:"members"`
+	Purpose struct {
+		Value string `json:"value"`
+	} `json:"purpose"`
+	Topic struct {
+		Value string `json:"value"`
+	} `json:"topic"`
+}
+
+type slackMessage struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Username string `json:"username"`
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	Edited   *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	Files []struct {
+		Name       string `json:"name"`
+		Mimetype   string `json:"mimetype"`
+		URLPrivate string `json:"url_private"`
+	} `json:"files"`
+}
+
+// slackSkippedSubtypes are channel events rather than messages.
+var slackSkippedSubtypes = map[string]bool{
+	"channel_join":      true,
+	"channel_leave":     true,
+	"channel_topic":     true,
+	"channel_purpose":   true,
+	"channel_name":      true,
+	"channel_archive":   true,
+	"channel_unarchive": true,
+	"group_join":        true,
+	"group_leave":       true,
+	"group_topic":       true,
+	"group_purpose":     true,
+	"group_name":        true,
+	"group_archive":     true,
+	"group_unarchive":   true,
+	"pinned_item":       true,
+	"unpinned_item":     true,
+}
+
+// LoadSlack reads a Slack workspace export, unzipped or as a zip.Reader:
+// users.json, channels.json, groups.json, mpims.json and dms.json, each
+// optional but the first, with one folder of daily message files per
+// conversation. Threads become replies to their first message.
+func LoadSlack(fsys fs.FS, source string) (*Archive, error) {
+	archive := &Archive{Source: source}
+
+	var users []slackUser
+	if err := readSlackFile(fsys, "users.json", &users); err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(users))
+	usernames := make(map[string]string, len(users))
+	for _, user := range users {
+		displayName := user.Profile.DisplayName
+		if displayName == "" {
+			displayName = user.Profile.RealName
+		}
+
+		archive.Users = append(archive.Users, ArchiveUser{
+			ID:          user.ID,
+			Username:    user.Name,
+			DisplayName: displayName,
+			Email:       user.Profile.Email,
+			IsBot:       user.IsBot,
+		})
+		known[user.ID] = true
+		usernames[user.ID] = user.Name
+	}
+
+	// Messages can come from users missing in users.json, such as members
+	// of shared channels or integrations posting as a bot.
+	addUser := func(user ArchiveUser) {
+		if !known[user.ID] {
+			known[user.ID] = true
+			usernames[user.ID] = user.Username
+			archive.Users = append(archive.Users, user)
+		}
+	}
+
+	conversations := []struct {
+		file      string
+		private   bool
+		direct    bool
+		folderIDs bool
+	}{
+		{file: "channels.json"},
+		{file: "groups.json", private: true},
+		{file: "mpims.json", private: true},
+		{file: "dms.json", private: true, direct: true, folderIDs: true},
+	}
+
+	for _, conversation := range conversations {
+		var channels []slackChannel
+		if err := readSlackFile(fsys, conversation.file, &channels); err != nil {
+			if errors.Is(err, fs.ErrNotExist) && conversation.file != "channels.json" {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, channel := range channels {
+			for _, member := range channel.Members {
+				addUser(ArchiveUser{ID: member, Username: member})
+			}
+
+			description := channel.Purpose.Value
+			if description == "" {
+				description = channel.Topic.Value
+			}
+
+			chat := ArchiveChat{
+				ID:          channel.ID,
+				Name:        channel.Name,
+				Description: description,
+				IsPrivate:   conversation.private,
+				IsDirect:    conversation.direct && len(channel.Members) == 2,
+				CreatedBy:   channel.Creator,
+				CreatedAt:   time.Unix(channel.Created, 0),
+				Members:     channel.Members,
+			}
+			if chat.CreatedBy != "" {
+				addUser(ArchiveUser{ID: chat.CreatedBy, Username: chat.CreatedBy})
+			}
+
+			folder := channel.Name
+			if conversation.folderIDs || folder == "" {
+				folder = channel.ID
+			}
+
+			messages, err := readSlackMessages(fsys, folder)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, message := range messages {
+				userID := message.User
+				if userID == "" && message.BotID != "" {
+					userID = "bot:" + message.BotID
+					username := message.Username
+					if username == "" {
+						username = message.BotID
+					}
+					addUser(ArchiveUser{ID: userID, Username: username, IsBot: true})
+				}
+				if userID == "" {
+					continue
+				}
+				addUser(ArchiveUser{ID: userID, Username: userID})
+
+				imported, ok := convertSlackMessage(message, userID, usernames)
+				if ok {
+					chat.Messages = append(chat.Messages, imported)
+				}
+			}
+
+			archive.Chats = append(archive.Chats, chat)
+		}
+	}
+
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func readSlackFile(fsys fs.FS, name string, v interface{}) error {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// readSlackMessages reads the daily files of a conversation in date order.
+// A conversation without messages has no folder.
+func readSlackMessages(fsys fs.FS, folder string) ([]slackMessage, error) {
+	files, err := fs.Glob(fsys, path.Join(folder, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages of %s: %w", folder, err)
+	}
+	sort.Strings(files)
+
+	var messages []slackMessage
+	for _, file := range files {
+		var day []slackMessage
+		if err := readSlackFile(fsys, file, &day); err != nil {
+			return nil, err
+		}
+		messages = append(messages, day...)
+	}
+
+	return messages, nil
+}
+
+func convertSlackMessage(message slackMessage, userID string, usernames map[string]string) (ArchiveMessage, bool) {
+	if message.Type != "message" || message.TS == "" || slackSkippedSubtypes[message.Subtype] {
+		return ArchiveMessage{}, false
+	}
+
+	createdAt, err := parseSlackTS(message.TS)
+	if err != nil {
+		return ArchiveMessage{}, false
+	}
+
+	imported := ArchiveMessage{
+		ID:        message.TS,
+		UserID:    userID,
+		Content:   slackText(message.Text, usernames),
+		CreatedAt: createdAt,
+	}
+
+	if message.ThreadTS != "" && message.ThreadTS != message.TS {
+		imported.ReplyTo = message.ThreadTS
+	}
+	if message.Edited != nil {
+		if editedAt, err := parseSlackTS(message.Edited.TS); err == nil {
+			imported.EditedAt = &editedAt
+		}
+	}
+
+	// A single file without text becomes a file message like an upload
+	// here. Otherwise the files are listed under the text.
+	if len(message.Files) == 1 && strings.TrimSpace(imported.Content) == "" && message.Files[0].URLPrivate != "" {
+		imported.Content = message.Files[0].URLPrivate
+		imported.Type = "file"
+		if strings.HasPrefix(message.Files[0].Mimetype, "image/") {
+			imported.Type = "image"
+		}
+		return imported, true
+	}
+
+	for _, file := range message.Files {
+		if file.URLPrivate == "" {
+			continue
+		}
+		imported.Content = strings.TrimSpace(imported.Content + "\n" + file.Name + ": " + file.URLPrivate)
+	}
+
+	return imported, true
+}
+
+// parseSlackTS converts a Slack timestamp such as "1614589200.000200",
+// seconds with microseconds, to a time.
+func parseSlackTS(ts string) (time.Time, error) {
+	seconds, fraction, _ := strings.Cut(ts, ".")
+
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+	}
+
+	var usec int64
+	if fraction != "" {
+		fraction = (fraction + "000000")[:6]
+		if usec, err = strconv.ParseInt(fraction, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", ts)
+		}
+	}
+
+	return time.Unix(sec, usec*int64(time.Microsecond)), nil
+}
+
+var (
+	slackEntity   = regexp.MustCompile(`<([^>]+)>`)
+	slackEscaping = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+)
+
+// slackText turns Slack markup into plain text: mentions become @username,
+// channel links #channel and links their label and address.
+func slackText(text string, usernames map[string]string) string {
+	text = slackEntity.ReplaceAllStringFunc(text, func(entity string) string {
+		target, label, _ := strings.Cut(entity[1:len(entity)-1], "|")
+
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if username, ok := usernames[target[1:]]; ok {
+				return "@" + username
+			}
+			if label != "" {
+				return "@" + strings.TrimPrefix(label, "@")
+			}
+			return target
+		case strings.HasPrefix(target, "#"):
+			if label != "" {
+				return "#" + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimPrefix(target, "!")
+		case label != "" && label != target:
+			return label + " (" + target + ")"
+		default:
+			return target
+		}
+	})
+
+	return slackEscaping.Replace(text)
+}
@@ -1,55 +1,192 @@
 package routes
 
 import (
+	"time"
+
 	"onlineChat/internal/users"
 	"onlineChat/internal/ws"
+	"onlineChat/pkg/jwtauth"
 	"onlineChat/pkg/middleware"
+	"onlineChat/pkg/redis"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-func SetupRoutes(userHandler *users.Handler, wsHandler *ws.Handler, config *Config, logger *logrus.Logger) *gin.Engine {
+func SetupRoutes(userHandler *users.Handler, wsHandler *ws.Handler, redisClient *redis.RedisClient, config *Config, logger *logrus.Logger) *gin.Engine {
 	r := gin.New()
 
+	// Rate limits and lockouts key on the client IP, so only the configured
+	// proxies may set it through X-Forwarded-For.
+	if err := r.SetTrustedProxies(config.Security.TrustedProxies); err != nil {
+		logger.WithError(err).Error("Invalid trusted proxies, trusting none")
+		r.SetTrustedProxies(nil)
+	}
+
 	r.Use(middleware.RequestLogger(logger))
 	r.Use(middleware.ErrorHandler(logger))
 	r.Use(middleware.SecurityHeaders())
 	r.Use(middleware.CORS(config.Security.CORSOrigin))
-	r.Use(middleware.RateLimit(config.Security.RateLimitRequests, 10))
 
-	r.GET("/health", func(c *gin.Context) {
+	limiter := middleware.NewRateLimiter(config.Security.RateLimitStore, logger)
+
+	// Unauthenticated routes are limited per client IP. Authenticated ones
+	// are limited per user by the "api" policy below instead, so users
+	// sharing an address do not throttle each other.
+	public := r.Group("/")
+	public.Use(limiter.Limit(middleware.RateLimitPolicy{
+		Name:   "public",
+		Limit:  config.Security.RateLimitRequests,
+		Window: config.Security.RateLimitWindow,
+	}))
+
+	// Each credential endpoint has its own bucket, so that for example
+	// requesting a password reset does not use up the login attempts.
+	authLimit := func(endpoint string) gin.HandlerFunc {
+		return limiter.Limit(middleware.RateLimitPolicy{
+			Name:   "auth:" + endpoint,
+			Limit:  config.Security.AuthRateLimitRequests,
+			Window: config.Security.AuthRateLimitWindow,
+		})
+	}
+
+	refreshLimit := limiter.Limit(middleware.RateLimitPolicy{
+		Name:    "refresh",
+		Limit:   config.Security.RefreshRateLimitRequests,
+		Window:  config.Security.RefreshRateLimitWindow,
+		Subject: userHandler.RefreshRateLimitSubject,
+	})
+
+	public.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
-	auth := r.Group("/auth")
+	public.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(200, config.JWT.Keys.JWKS())
+	})
+
+	public.Static("/avatars", config.Upload.AvatarDir)
+	public.GET("/exports/:id/download", userHandler.DownloadDataExport)
+
+	auth := public.Group("/auth")
 	{
-		auth.POST("/register", userHandler.Register)
-		auth.POST("/login", userHandler.Login)
+		auth.POST("/register", authLimit("register"), userHandler.Register)
+		auth.POST("/login", authLimit("login"), userHandler.Login)
+		auth.POST("/login/2fa", authLimit("login_2fa"), userHandler.LoginTwoFactor)
+		auth.POST("/refresh", refreshLimit, userHandler.Refresh)
+		auth.POST("/password/forgot", authLimit("password_forgot"), userHandler.ForgotPassword)
+		auth.POST("/password/reset", authLimit("password_reset"), userHandler.ResetPassword)
+		auth.POST("/email/verify", authLimit("email_verify"), userHandler.VerifyEmail)
+		auth.POST("/email/resend", authLimit("email_resend"), userHandler.ResendVerification)
+		auth.GET("/oidc/authorize", authLimit("oidc_authorize"), userHandler.OIDCAuthorize)
+		auth.POST("/oidc/callback", authLimit("oidc_callback"), userHandler.OIDCCallback)
 	}
 
+	authMiddleware := middleware.NewAuthMiddleware(config.JWT.Keys, config.JWT.PersonalTokens, redisClient, logger)
+
 	protected := r.Group("/")
-	protected.Use(middleware.NewAuthMiddleware(config.JWT.Secret, logger).RequireAuth())
+	protected.Use(authMiddleware.RequireAuth())
+	protected.Use(limiter.Limit(middleware.RateLimitPolicy{
+		Name:   "api",
+		Limit:  config.Security.RateLimitRequests,
+		Window: config.Security.RateLimitWindow,
+	}))
 	{
-		protected.GET("/auth/profile", userHandler.GetProfile)
-		protected.PUT("/auth/profile", userHandler.UpdateProfile)
-		protected.DELETE("/auth/account", userHandler.DeleteAccount)
+		protected.GET("/auth/profile", authMiddleware.RequireScope(jwtauth.ScopeProfileRead), userHandler.GetProfile)
+
+		account := protected.Group("/")
+		account.Use(authMiddleware.RequireUserSession())
+		{
+			account.PUT("/auth/profile", userHandler.UpdateProfile)
+			account.POST("/auth/avatar", userHandler.UploadAvatar)
+			account.DELETE("/auth/avatar", userHandler.DeleteAvatar)
+			account.DELETE("/auth/account", authLimit("account_delete"), userHandler.DeleteAccount)
+			account.GET("/auth/account/deletion", userHandler.GetAccountDeletion)
+			account.DELETE("/auth/account/deletion", userHandler.CancelAccountDeletion)
+			account.PUT("/auth/password", authLimit("password_change"), userHandler.ChangePassword)
+			account.GET("/auth/oidc/link", authLimit("oidc_link"), userHandler.OIDCLink)
+			account.GET("/auth/security-log", userHandler.GetSecurityLog)
+			account.GET("/auth/settings", userHandler.GetSettings)
+			account.PUT("/auth/settings", userHandler.UpdateSettings)
+			account.POST("/auth/exports", userHandler.RequestDataExport)
+			account.GET("/auth/exports", userHandler.GetDataExports)
+			account.GET("/auth/exports/:id", userHandler.GetDataExport)
+			account.POST("/auth/logout", userHandler.Logout)
+			account.GET("/auth/sessions", userHandler.GetSessions)
+			account.DELETE("/auth/sessions/:id", userHandler.RevokeSession)
+			account.GET("/auth/2fa", userHandler.GetTwoFactorStatus)
+			account.POST("/auth/2fa/enroll", userHandler.EnrollTwoFactor)
+			account.POST("/auth/2fa/confirm", userHandler.ConfirmTwoFactor)
+			account.POST("/auth/2fa/disable", userHandler.DisableTwoFactor)
+			account.POST("/auth/2fa/recovery-codes", userHandler.RegenerateRecoveryCodes)
+			account.GET("/auth/tokens", userHandler.GetTokens)
+			account.POST("/auth/tokens", userHandler.CreateToken)
+			account.DELETE("/auth/tokens/:tokenID", userHandler.RevokeToken)
+			account.GET("/blocks", userHandler.GetBlockedUsers)
+			account.POST("/blocks/:id", userHandler.BlockUser)
+			account.DELETE("/blocks/:id", userHandler.UnblockUser)
+			account.GET("/contacts", userHandler.GetContacts)
+			account.PUT("/contacts/:id", userHandler.UpdateContact)
+			account.DELETE("/contacts/:id", userHandler.RemoveContact)
+			account.GET("/contacts/requests", userHandler.GetFriendRequests)
+			account.POST("/contacts/requests", userHandler.SendFriendRequest)
+			account.POST("/contacts/requests/:id/accept", userHandler.AcceptFriendRequest)
+			account.POST("/contacts/requests/:id/decline", userHandler.DeclineFriendRequest)
+			account.DELETE("/contacts/requests/:id", userHandler.CancelFriendRequest)
+
+			bots := account.Group("/bots")
+			{
+				bots.GET("/", userHandler.GetBots)
+				bots.POST("/", userHandler.CreateBot)
+				bots.DELETE("/:id", userHandler.DeleteBot)
+				bots.GET("/:id/tokens", userHandler.GetTokens)
+				bots.POST("/:id/tokens", userHandler.CreateToken)
+				bots.DELETE("/:id/tokens/:tokenID", userHandler.RevokeToken)
+			}
+		}
 
 		users := protected.Group("/users")
 		{
+			users.Use(authMiddleware.RequireScope(jwtauth.ScopeProfileRead))
+			users.GET("/search", userHandler.SearchUsers)
+			users.GET("/presence", userHandler.GetPresence)
+			users.GET("/by-username/:username", userHandler.GetUserByUsername)
 			users.GET("/:id", userHandler.GetUserByID)
 		}
 
+		chatsRead := authMiddleware.RequireScope(jwtauth.ScopeChatsRead)
+		chatsWrite := authMiddleware.RequireScope(jwtauth.ScopeChatsWrite)
+		messagesRead := authMiddleware.RequireScope(jwtauth.ScopeMessagesRead)
+
+		protected.GET("/ws", messagesRead, wsHandler.ServeUserWS)
+		protected.GET("/mentions", messagesRead, wsHandler.GetMentions)
+		protected.GET("/mentions/counts", messagesRead, wsHandler.GetMentionCounts)
+		protected.GET("/notifications", messagesRead, wsHandler.GetNotifications)
+		protected.GET("/notifications/count", messagesRead, wsHandler.GetNotificationCount)
+		protected.POST("/notifications/read", chatsWrite, wsHandler.MarkNotificationsRead)
+
 		chats := protected.Group("/chats")
 		{
-			chats.POST("/", wsHandler.CreateChat)
-			chats.GET("/", wsHandler.GetAllChats)
-			chats.GET("/search", wsHandler.SearchPublicChats)
-			chats.POST("/:chatID/join", wsHandler.JoinChat)
-			chats.POST("/:chatID/leave", wsHandler.LeaveChat)
-			chats.GET("/:chatID/clients", wsHandler.GetClientsByChatID)
-			chats.GET("/:chatID/messages", wsHandler.GetChatMessages)
-			chats.GET("/:chatID/ws", wsHandler.ServeWS)
+			chats.POST("/", chatsWrite, wsHandler.CreateChat)
+			chats.POST("/direct", chatsWrite, authMiddleware.RequireVerifiedEmail(), wsHandler.CreateDirectChat)
+			chats.GET("/", chatsRead, wsHandler.GetAllChats)
+			chats.GET("/search", chatsRead, wsHandler.SearchPublicChats)
+			chats.POST("/:chatID/join", chatsWrite, authMiddleware.RequireVerifiedEmail(), wsHandler.JoinChat)
+			chats.POST("/:chatID/leave", chatsWrite, wsHandler.LeaveChat)
+			chats.PUT("/:chatID/slow-mode", chatsWrite, wsHandler.SetSlowMode)
+			chats.GET("/:chatID/clients", chatsRead, wsHandler.GetClientsByChatID)
+			chats.GET("/:chatID/messages", messagesRead, wsHandler.GetChatMessages)
+			chats.GET("/:chatID/pins", messagesRead, wsHandler.GetPinnedMessages)
+			chats.POST("/:chatID/pins/:messageID", chatsWrite, wsHandler.PinMessage)
+			chats.DELETE("/:chatID/pins/:messageID", chatsWrite, wsHandler.UnpinMessage)
+			chats.POST("/:chatID/mentions/read", chatsWrite, wsHandler.MarkMentionsRead)
+			chats.GET("/:chatID/notifications", chatsRead, wsHandler.GetChatNotificationSettings)
+			chats.PUT("/:chatID/notifications", chatsWrite, wsHandler.UpdateChatNotificationSettings)
+			chats.GET("/:chatID/export", messagesRead, wsHandler.ExportChat)
+			chats.GET("/:chatID/exports/:exportID", messagesRead, wsHandler.GetChatExport)
+			chats.GET("/:chatID/exports/:exportID/download", messagesRead, wsHandler.DownloadChatExport)
+			chats.GET("/:chatID/ws", messagesRead, wsHandler.ServeWS)
 		}
 	}
 
@@ -59,13 +196,26 @@ func SetupRoutes(userHandler *users.Handler, wsHandler *ws.Handler, config *Conf
 type Config struct {
 	JWT      JWTConfig
 	Security SecurityConfig
+	Upload   UploadConfig
 }
 
 type JWTConfig struct {
-	Secret string
+	Keys           *jwtauth.KeySet
+	PersonalTokens middleware.PersonalTokenAuthenticator
 }
 
 type SecurityConfig struct {
-	CORSOrigin        string
-	RateLimitRequests int
+	CORSOrigin               string
+	RateLimitRequests        int
+	RateLimitWindow          time.Duration
+	RateLimitStore           middleware.RateLimitStore
+	AuthRateLimitRequests    int
+	AuthRateLimitWindow      time.Duration
+	RefreshRateLimitRequests int
+	RefreshRateLimitWindow   time.Duration
+	TrustedProxies           []string
+}
+
+type UploadConfig struct {
+	AvatarDir string
 }
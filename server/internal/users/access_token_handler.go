@@ -0,0 +1,214 @@
+package users
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) CreateBot(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req CreateBotRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid create bot request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	bot, err := h.service.CreateBot(userID, req)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to create bot")
+		c.JSON(accessTokenStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, bot)
+}
+
+func (h *Handler) GetBots(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	bots, err := h.service.GetBots(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get bots")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get bots",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bots": bots,
+	})
+}
+
+func (h *Handler) DeleteBot(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	botID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid bot ID",
+		})
+		return
+	}
+
+	if err := h.service.DeleteBot(userID, botID); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to delete bot")
+		c.JSON(accessTokenStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Bot deleted successfully",
+	})
+}
+
+// CreateToken creates a personal access token for the caller, or for one of
+// the caller's bots when the route has a bot ID.
+func (h *Handler) CreateToken(c *gin.Context) {
+	userID, subjectID, ok := h.tokenSubject(c)
+	if !ok {
+		return
+	}
+
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid create token request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	token, err := h.service.CreatePersonalToken(userID, subjectID, req)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to create personal access token")
+		c.JSON(accessTokenStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, token)
+}
+
+func (h *Handler) GetTokens(c *gin.Context) {
+	userID, subjectID, ok := h.tokenSubject(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.service.GetPersonalTokens(userID, subjectID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to get personal access tokens")
+		c.JSON(accessTokenStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+	})
+}
+
+func (h *Handler) RevokeToken(c *gin.Context) {
+	userID, subjectID, ok := h.tokenSubject(c)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("tokenID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid token ID",
+		})
+		return
+	}
+
+	if err := h.service.RevokePersonalToken(userID, subjectID, tokenID); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to revoke personal access token")
+		c.JSON(accessTokenStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Token revoked successfully",
+	})
+}
+
+// tokenSubject returns the caller and the user whose tokens the request is
+// about: the bot in the route, or the caller.
+func (h *Handler) tokenSubject(c *gin.Context) (int, int, bool) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return 0, 0, false
+	}
+
+	botIDStr := c.Param("id")
+	if botIDStr == "" {
+		return userID, userID, true
+	}
+
+	botID, err := strconv.Atoi(botIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid bot ID",
+		})
+		return 0, 0, false
+	}
+
+	return userID, botID, true
+}
+
+func accessTokenStatusCode(err error) int {
+	switch {
+	case err.Error() == "bot not found" || err.Error() == "personal access token not found":
+		return http.StatusNotFound
+	case err.Error() == "username already exists":
+		return http.StatusConflict
+	case err.Error() == "bots cannot create bots":
+		return http.StatusForbidden
+	case strings.HasPrefix(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
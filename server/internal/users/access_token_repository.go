@@ -0,0 +1,171 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (r *UserRepository) CreatePersonalToken(token PersonalAccessToken) (*PersonalAccessToken, error) {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, created_by, name, token_prefix, token_hash,
+		                                    scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRow(query,
+		token.UserID, token.CreatedBy, token.Name, token.TokenPrefix, token.TokenHash,
+		strings.Join(token.Scopes, " "), token.ExpiresAt, time.Now(),
+	).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", token.UserID).Error("Failed to create personal access token")
+		return nil, fmt.Errorf("failed to create personal access token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *UserRepository) GetPersonalTokenByHash(tokenHash string) (*PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, created_by, name, token_prefix, token_hash, scopes,
+		       expires_at, last_used_at, created_at, revoked_at
+		FROM personal_access_tokens
+		WHERE token_hash = $1
+	`
+
+	token, err := scanPersonalToken(r.db.QueryRow(query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("personal access token not found")
+		}
+		r.logger.WithError(err).Error("Failed to get personal access token")
+		return nil, fmt.Errorf("failed to get personal access token: %w", err)
+	}
+
+	return token, nil
+}
+
+func (r *UserRepository) GetPersonalTokens(userID int) ([]PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, created_by, name, token_prefix, token_hash, scopes,
+		       expires_at, last_used_at, created_at, revoked_at
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get personal access tokens")
+		return nil, fmt.Errorf("failed to get personal access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalToken(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan personal access token")
+			continue
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, nil
+}
+
+func (r *UserRepository) TouchPersonalToken(id int) error {
+	query := `UPDATE personal_access_tokens SET last_used_at = $1 WHERE id = $2`
+
+	if _, err := r.db.Exec(query, time.Now(), id); err != nil {
+		r.logger.WithError(err).WithField("token_id", id).Error("Failed to update personal access token")
+		return fmt.Errorf("failed to update personal access token: %w", err)
+	}
+
+	return nil
+}
+
+// RevokePersonalToken revokes token id if it belongs to userID.
+func (r *UserRepository) RevokePersonalToken(id, userID int) error {
+	query := `
+		UPDATE personal_access_tokens
+		SET revoked_at = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), id, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("token_id", id).Error("Failed to revoke personal access token")
+		return fmt.Errorf("failed to revoke personal access token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("personal access token not found")
+	}
+
+	return nil
+}
+
+func (r *UserRepository) RevokeUserPersonalTokens(userID int) error {
+	query := `
+		UPDATE personal_access_tokens
+		SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`
+
+	if _, err := r.db.Exec(query, time.Now(), userID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke personal access tokens")
+		return fmt.Errorf("failed to revoke personal access tokens: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeOwnedPersonalTokens revokes the personal access tokens of userID and
+// of the bots they own, and returns how many it revoked.
+func (r *UserRepository) RevokeOwnedPersonalTokens(userID int) (int, error) {
+	query := `
+		UPDATE personal_access_tokens
+		SET revoked_at = $1
+		WHERE revoked_at IS NULL
+		  AND (user_id = $2 OR user_id IN (SELECT id FROM users WHERE bot_owner_id = $2))
+	`
+
+	result, err := r.db.Exec(query, time.Now(), userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke owned personal access tokens")
+		return 0, fmt.Errorf("failed to revoke personal access tokens: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return int(rowsAffected), nil
+}
+
+func scanPersonalToken(row rowScanner) (*PersonalAccessToken, error) {
+	token := &PersonalAccessToken{}
+
+	var scopes string
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.CreatedBy, &token.Name, &token.TokenPrefix,
+		&token.TokenHash, &scopes, &token.ExpiresAt, &token.LastUsedAt,
+		&token.CreatedAt, &token.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scopes = strings.Fields(scopes)
+
+	return token, nil
+}
@@ -0,0 +1,238 @@
+package users
+
+import (
+	"fmt"
+	"time"
+
+	"onlineChat/pkg/jwtauth"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// botEmailDomain uses the reserved .invalid TLD so bot addresses can never
+// receive mail or collide with a real account.
+const botEmailDomain = "bots.invalid"
+
+// CreateBot creates a bot account owned by ownerID. Bots cannot log in and
+// only authenticate with personal access tokens.
+func (us *UserService) CreateBot(ownerID int, req CreateBotRequest) (*UserResponse, error) {
+	owner, err := us.repo.GetByID(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if owner.IsBot {
+		return nil, fmt.Errorf("bots cannot create bots")
+	}
+
+	exists, err := us.repo.UsernameExists(req.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check username existence: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("username already exists")
+	}
+
+	password, _, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	bot, err := us.repo.CreateUser(User{
+		Email:      fmt.Sprintf("%s@%s", req.Username, botEmailDomain),
+		Username:   req.Username,
+		Password:   string(passwordHash),
+		IsBot:      true,
+		BotOwnerID: &ownerID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bot: %w", err)
+	}
+
+	// There is no inbox to verify, and bots must be able to join chats.
+	if err := us.repo.MarkEmailVerified(bot.ID); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	bot.EmailVerifiedAt = &now
+
+	us.logger.WithFields(logrus.Fields{
+		"bot_id":   bot.ID,
+		"owner_id": ownerID,
+	}).Info("Bot created")
+
+	response := bot.ToResponse()
+	return &response, nil
+}
+
+func (us *UserService) GetBots(ownerID int) ([]UserResponse, error) {
+	bots, err := us.repo.GetBotsByOwner(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bots: %w", err)
+	}
+
+	responses := make([]UserResponse, 0, len(bots))
+	for _, bot := range bots {
+		responses = append(responses, bot.ToResponse())
+	}
+
+	return responses, nil
+}
+
+func (us *UserService) DeleteBot(ownerID, botID int) error {
+	if _, err := us.ownedBot(ownerID, botID); err != nil {
+		return err
+	}
+
+	if err := us.repo.RevokeUserPersonalTokens(botID); err != nil {
+		return err
+	}
+
+	if err := us.repo.DeleteUser(botID); err != nil {
+		return fmt.Errorf("failed to delete bot: %w", err)
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"bot_id":   botID,
+		"owner_id": ownerID,
+	}).Info("Bot deleted")
+
+	return nil
+}
+
+// CreatePersonalToken creates a token that authenticates as subjectID, which
+// is either ownerID itself or one of ownerID's bots. The plain token is only
+// returned here.
+func (us *UserService) CreatePersonalToken(ownerID, subjectID int, req CreateTokenRequest) (*CreatedTokenResponse, error) {
+	if err := us.checkTokenSubject(ownerID, subjectID); err != nil {
+		return nil, err
+	}
+
+	for _, scope := range req.Scopes {
+		if !jwtauth.ValidScope(scope) {
+			return nil, fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+
+	secret, tokenHash, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+	plain := jwtauth.PersonalTokenPrefix + secret
+
+	token := PersonalAccessToken{
+		UserID:      subjectID,
+		CreatedBy:   ownerID,
+		Name:        req.Name,
+		TokenPrefix: plain[:len(jwtauth.PersonalTokenPrefix)+4],
+		TokenHash:   tokenHash,
+		Scopes:      req.Scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	created, err := us.repo.CreatePersonalToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"token_id": created.ID,
+		"user_id":  subjectID,
+		"owner_id": ownerID,
+	}).Info("Personal access token created")
+
+	return &CreatedTokenResponse{
+		Token: plain,
+		Info:  *created,
+	}, nil
+}
+
+func (us *UserService) GetPersonalTokens(ownerID, subjectID int) ([]PersonalAccessToken, error) {
+	if err := us.checkTokenSubject(ownerID, subjectID); err != nil {
+		return nil, err
+	}
+
+	return us.repo.GetPersonalTokens(subjectID)
+}
+
+func (us *UserService) RevokePersonalToken(ownerID, subjectID, tokenID int) error {
+	if err := us.checkTokenSubject(ownerID, subjectID); err != nil {
+		return err
+	}
+
+	if err := us.repo.RevokePersonalToken(tokenID, subjectID); err != nil {
+		return err
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"token_id": tokenID,
+		"user_id":  subjectID,
+	}).Info("Personal access token revoked")
+
+	return nil
+}
+
+// AuthenticatePersonalToken resolves a personal access token to the claims
+// of the user it acts for.
+func (us *UserService) AuthenticatePersonalToken(plain string) (*jwtauth.Claims, error) {
+	secret := plain[len(jwtauth.PersonalTokenPrefix):]
+
+	token, err := us.repo.GetPersonalTokenByHash(hashToken(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid personal access token")
+	}
+
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt)) {
+		return nil, fmt.Errorf("invalid personal access token")
+	}
+
+	user, err := us.repo.GetByID(token.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid personal access token")
+	}
+
+	go func() {
+		if err := us.repo.TouchPersonalToken(token.ID); err != nil {
+			us.logger.WithError(err).WithField("token_id", token.ID).Warn("Failed to update token last use")
+		}
+	}()
+
+	return &jwtauth.Claims{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.IsEmailVerified(),
+		IsBot:         user.IsBot,
+		Scopes:        token.Scopes,
+	}, nil
+}
+
+func (us *UserService) checkTokenSubject(ownerID, subjectID int) error {
+	if subjectID == ownerID {
+		return nil
+	}
+
+	_, err := us.ownedBot(ownerID, subjectID)
+	return err
+}
+
+func (us *UserService) ownedBot(ownerID, botID int) (*User, error) {
+	bot, err := us.repo.GetByID(botID)
+	if err != nil {
+		return nil, fmt.Errorf("bot not found")
+	}
+
+	if !bot.IsBot || bot.BotOwnerID == nil || *bot.BotOwnerID != ownerID {
+		return nil, fmt.Errorf("bot not found")
+	}
+
+	return bot, nil
+}
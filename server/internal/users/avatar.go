@@ -0,0 +1,123 @@
+package users
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+// maxAvatarSourcePixels bounds the decoded size of an uploaded image, since a
+// small compressed file can expand to gigabytes of pixels.
+const maxAvatarSourcePixels = 40_000_000
+
+// AvatarCrop selects the square of the uploaded image that becomes the avatar,
+// in source pixels.
+type AvatarCrop struct {
+	X    int
+	Y    int
+	Size int
+}
+
+// processAvatar decodes a GIF, JPEG or PNG image, crops it to a square and
+// scales it to size x size. Without crop the largest centred square is used.
+// The result is PNG encoded.
+func processAvatar(data []byte, crop *AvatarCrop, size int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+	if cfg.Width*cfg.Height > maxAvatarSourcePixels {
+		return nil, fmt.Errorf("image dimensions are too large")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image format")
+	}
+
+	area, err := avatarCropArea(img.Bounds(), crop)
+	if err != nil {
+		return nil, err
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	draw.Draw(src, src.Bounds(), img, area.Min, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleSquare(src, size)); err != nil {
+		return nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func avatarCropArea(bounds image.Rectangle, crop *AvatarCrop) (image.Rectangle, error) {
+	if crop == nil {
+		side := bounds.Dx()
+		if bounds.Dy() < side {
+			side = bounds.Dy()
+		}
+		min := bounds.Min.Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+		return image.Rectangle{Min: min, Max: min.Add(image.Pt(side, side))}, nil
+	}
+
+	min := bounds.Min.Add(image.Pt(crop.X, crop.Y))
+	area := image.Rectangle{Min: min, Max: min.Add(image.Pt(crop.Size, crop.Size))}
+	if crop.X < 0 || crop.Y < 0 || crop.Size <= 0 || !area.In(bounds) {
+		return image.Rectangle{}, fmt.Errorf("crop area is outside the image")
+	}
+
+	return area, nil
+}
+
+// scaleSquare resizes a square image to size x size. Every destination pixel
+// is the average of the source pixels it covers, which keeps downscaled
+// photos free of aliasing. Averaging premultiplied RGBA keeps transparent
+// edges clean.
+func scaleSquare(src *image.RGBA, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	side := src.Bounds().Dx()
+
+	for dy := 0; dy < size; dy++ {
+		y0, y1 := sourceSpan(dy, size, side)
+		for dx := 0; dx < size; dx++ {
+			x0, x1 := sourceSpan(dx, size, side)
+
+			var r, g, b, a, n int
+			for y := y0; y < y1; y++ {
+				offset := src.PixOffset(x0, y)
+				for x := x0; x < x1; x++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					a += int(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			offset := dst.PixOffset(dx, dy)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// sourceSpan returns the source pixels [start, end) covered by destination
+// pixel i. The span is never empty, so upscaling repeats pixels.
+func sourceSpan(i, dstSize, srcSize int) (int, int) {
+	start := i * srcSize / dstSize
+	end := (i + 1) * srcSize / dstSize
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
@@ -0,0 +1,112 @@
+package users
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func (h *Handler) GetBlockedUsers(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	blocked, err := h.service.GetBlockedUsers(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get blocked users")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get blocked users",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocked": blocked,
+	})
+}
+
+func (h *Handler) BlockUser(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	blockedID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	if err := h.service.BlockUser(userID, blockedID); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"blocked_id": blockedID,
+		}).Warn("Failed to block user")
+		c.JSON(blockStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User blocked",
+	})
+}
+
+func (h *Handler) UnblockUser(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	blockedID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	if err := h.service.UnblockUser(userID, blockedID); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"blocked_id": blockedID,
+		}).Warn("Failed to unblock user")
+		c.JSON(blockStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User unblocked",
+	})
+}
+
+func blockStatusCode(err error) int {
+	switch {
+	case err.Error() == "user not found" || err.Error() == "user is not blocked":
+		return http.StatusNotFound
+	case strings.HasPrefix(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,82 @@
+package users
+
+import (
+	"fmt"
+	"time"
+)
+
+func (r *UserRepository) BlockUser(blockerID, blockedID int) error {
+	query := `
+		INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+	`
+
+	_, err := r.db.Exec(query, blockerID, blockedID, time.Now())
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", blockerID).Error("Failed to block user")
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UnblockUser(blockerID, blockedID int) error {
+	query := `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`
+
+	result, err := r.db.Exec(query, blockerID, blockedID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", blockerID).Error("Failed to unblock user")
+		return fmt.Errorf("failed to unblock user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user is not blocked")
+	}
+
+	return nil
+}
+
+// GetBlockedUsers lists the active users blockerID has blocked, most recent
+// first.
+func (r *UserRepository) GetBlockedUsers(blockerID int) ([]BlockedUser, error) {
+	query := `
+		SELECT ` + userColumns + `, b.blocked_at
+		FROM users u
+		INNER JOIN (
+			SELECT blocked_id, created_at AS blocked_at
+			FROM user_blocks
+			WHERE blocker_id = $1
+		) b ON b.blocked_id = u.id
+		WHERE u.is_active = true
+		ORDER BY b.blocked_at DESC
+	`
+
+	rows, err := r.db.Query(query, blockerID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", blockerID).Error("Failed to get blocked users")
+		return nil, fmt.Errorf("failed to get blocked users: %w", err)
+	}
+	defer rows.Close()
+
+	blocked := []BlockedUser{}
+	for rows.Next() {
+		var blockedAt time.Time
+		user, err := scanUser(rows, &blockedAt)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan blocked user")
+			continue
+		}
+		blocked = append(blocked, BlockedUser{
+			User:      user.ToSummary(),
+			BlockedAt: blockedAt,
+		})
+	}
+
+	return blocked, nil
+}
@@ -0,0 +1,60 @@
+package users
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BlockUser stops blockedID from starting direct chats with blockerID and
+// hides the two from each other's user search. blockedID's messages are
+// flagged for blockerID so clients can hide them. Any friendship or pending
+// friend request between the two ends.
+func (us *UserService) BlockUser(blockerID, blockedID int) error {
+	if blockerID == blockedID {
+		return fmt.Errorf("cannot block yourself")
+	}
+
+	if _, err := us.repo.GetByID(blockedID); err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	if err := us.repo.BlockUser(blockerID, blockedID); err != nil {
+		return err
+	}
+
+	us.redis.PublishBlocksChanged(blockerID)
+
+	if err := us.repo.CancelFriendRequestsBetween(blockerID, blockedID); err != nil {
+		us.logger.WithError(err).WithField("user_id", blockerID).Warn("Failed to cancel friend requests of blocked user")
+	}
+	if err := us.repo.RemoveContact(blockerID, blockedID); err != nil && err.Error() != "contact not found" {
+		us.logger.WithError(err).WithField("user_id", blockerID).Warn("Failed to remove blocked contact")
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    blockerID,
+		"blocked_id": blockedID,
+	}).Info("User blocked")
+
+	return nil
+}
+
+func (us *UserService) UnblockUser(blockerID, blockedID int) error {
+	if err := us.repo.UnblockUser(blockerID, blockedID); err != nil {
+		return err
+	}
+
+	us.redis.PublishBlocksChanged(blockerID)
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    blockerID,
+		"blocked_id": blockedID,
+	}).Info("User unblocked")
+
+	return nil
+}
+
+func (us *UserService) GetBlockedUsers(blockerID int) ([]BlockedUser, error) {
+	return us.repo.GetBlockedUsers(blockerID)
+}
@@ -0,0 +1,281 @@
+package users
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func (h *Handler) GetContacts(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	contacts, err := h.service.GetContacts(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get contacts")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get contacts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"contacts": contacts,
+	})
+}
+
+func (h *Handler) UpdateContact(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	contactID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	var req ContactUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	contact, err := h.service.UpdateContact(userID, contactID, req)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"contact_id": contactID,
+		}).Warn("Failed to update contact")
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func (h *Handler) RemoveContact(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	contactID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid user ID",
+		})
+		return
+	}
+
+	if err := h.service.RemoveContact(userID, contactID); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"contact_id": contactID,
+		}).Warn("Failed to remove contact")
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Contact removed",
+	})
+}
+
+func (h *Handler) GetFriendRequests(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	requests, err := h.service.GetFriendRequests(userID, c.Query("direction"))
+	if err != nil {
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"requests": requests,
+	})
+}
+
+func (h *Handler) SendFriendRequest(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req FriendRequestCreate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	request, err := h.service.SendFriendRequest(userID, req.UserID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":      userID,
+			"recipient_id": req.UserID,
+		}).Warn("Failed to send friend request")
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// A crossing request is accepted straight away rather than created.
+	status := http.StatusCreated
+	if request.Status == FriendRequestAccepted {
+		status = http.StatusOK
+	}
+
+	c.JSON(status, request)
+}
+
+func (h *Handler) AcceptFriendRequest(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	requestID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+
+	request, err := h.service.AcceptFriendRequest(userID, requestID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"request_id": requestID,
+		}).Warn("Failed to accept friend request")
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *Handler) DeclineFriendRequest(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	requestID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+
+	if err := h.service.DeclineFriendRequest(userID, requestID); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"request_id": requestID,
+		}).Warn("Failed to decline friend request")
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Friend request declined",
+	})
+}
+
+func (h *Handler) CancelFriendRequest(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	requestID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request ID",
+		})
+		return
+	}
+
+	if err := h.service.CancelFriendRequest(userID, requestID); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"request_id": requestID,
+		}).Warn("Failed to cancel friend request")
+		c.JSON(contactStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Friend request cancelled",
+	})
+}
+
+func contactStatusCode(err error) int {
+	switch {
+	case err.Error() == "user not found" || err.Error() == "contact not found" || err.Error() == "friend request not found":
+		return http.StatusNotFound
+	case err.Error() == "user is already a contact" || err.Error() == "friend request already pending":
+		return http.StatusConflict
+	case strings.HasPrefix(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
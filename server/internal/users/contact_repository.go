@@ -0,0 +1,338 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const friendRequestColumns = `id, sender_id, recipient_id, status, created_at, responded_at`
+
+func scanFriendRequest(row rowScanner) (*FriendRequest, error) {
+	var request FriendRequest
+	err := row.Scan(
+		&request.ID,
+		&request.SenderID,
+		&request.RecipientID,
+		&request.Status,
+		&request.CreatedAt,
+		&request.RespondedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// CreateFriendRequest stores a pending request. Only one request may be
+// pending between two users, whichever of them sent it.
+func (r *UserRepository) CreateFriendRequest(senderID, recipientID int) (*FriendRequest, error) {
+	query := `
+		INSERT INTO friend_requests (sender_id, recipient_id, status, created_at)
+		VALUES ($1, $2, 'pending', $3)
+		ON CONFLICT DO NOTHING
+		RETURNING ` + friendRequestColumns
+
+	request, err := scanFriendRequest(r.db.QueryRow(query, senderID, recipientID, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("friend request already pending")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", senderID).Error("Failed to create friend request")
+		return nil, fmt.Errorf("failed to create friend request: %w", err)
+	}
+
+	return request, nil
+}
+
+func (r *UserRepository) GetFriendRequest(id int) (*FriendRequest, error) {
+	query := `SELECT ` + friendRequestColumns + ` FROM friend_requests WHERE id = $1`
+
+	request, err := scanFriendRequest(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("friend request not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("request_id", id).Error("Failed to get friend request")
+		return nil, fmt.Errorf("failed to get friend request: %w", err)
+	}
+
+	return request, nil
+}
+
+// GetPendingFriendRequest returns the pending request sent by senderID to
+// recipientID.
+func (r *UserRepository) GetPendingFriendRequest(senderID, recipientID int) (*FriendRequest, error) {
+	query := `
+		SELECT ` + friendRequestColumns + `
+		FROM friend_requests
+		WHERE sender_id = $1 AND recipient_id = $2 AND status = 'pending'
+	`
+
+	request, err := scanFriendRequest(r.db.QueryRow(query, senderID, recipientID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("friend request not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", senderID).Error("Failed to get friend request")
+		return nil, fmt.Errorf("failed to get friend request: %w", err)
+	}
+
+	return request, nil
+}
+
+// GetFriendRequests lists the pending requests sent to userID, or sent by
+// userID when incoming is false, newest first. Each request carries the other
+// user.
+func (r *UserRepository) GetFriendRequests(userID int, incoming bool) ([]FriendRequest, error) {
+	ownColumn, otherColumn := "sender_id", "recipient_id"
+	if incoming {
+		ownColumn, otherColumn = "recipient_id", "sender_id"
+	}
+
+	query := `
+		SELECT ` + userColumns + `, fr.request_id, fr.sender_id, fr.recipient_id,
+		       fr.status, fr.requested_at, fr.responded_at
+		FROM users u
+		INNER JOIN (
+			SELECT id AS request_id, sender_id, recipient_id, status,
+			       created_at AS requested_at, responded_at
+			FROM friend_requests
+			WHERE ` + ownColumn + ` = $1 AND status = 'pending'
+		) fr ON fr.` + otherColumn + ` = u.id
+		WHERE u.is_active = true
+		ORDER BY fr.requested_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get friend requests")
+		return nil, fmt.Errorf("failed to get friend requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []FriendRequest{}
+	for rows.Next() {
+		var request FriendRequest
+		user, err := scanUser(rows,
+			&request.ID,
+			&request.SenderID,
+			&request.RecipientID,
+			&request.Status,
+			&request.CreatedAt,
+			&request.RespondedAt,
+		)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan friend request")
+			continue
+		}
+		summary := user.ToSummary()
+		request.User = &summary
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// RespondFriendRequest moves a pending request to status. Accepting it also
+// records the contact on both sides, in the same transaction.
+func (r *UserRepository) RespondFriendRequest(id int, status string) (*FriendRequest, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	query := `
+		UPDATE friend_requests
+		SET status = $1, responded_at = $2
+		WHERE id = $3 AND status = 'pending'
+		RETURNING ` + friendRequestColumns
+
+	request, err := scanFriendRequest(tx.QueryRow(query, status, now, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("friend request not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("request_id", id).Error("Failed to update friend request")
+		return nil, fmt.Errorf("failed to update friend request: %w", err)
+	}
+
+	if status == FriendRequestAccepted {
+		_, err := tx.Exec(`
+			INSERT INTO contacts (user_id, contact_id, created_at)
+			VALUES ($1, $2, $3), ($2, $1, $3)
+			ON CONFLICT (user_id, contact_id) DO NOTHING
+		`, request.SenderID, request.RecipientID, now)
+		if err != nil {
+			r.logger.WithError(err).WithField("request_id", id).Error("Failed to add contact")
+			return nil, fmt.Errorf("failed to add contact: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return request, nil
+}
+
+// CancelFriendRequestsBetween cancels any pending request between two users,
+// in either direction.
+func (r *UserRepository) CancelFriendRequestsBetween(userID, otherID int) error {
+	query := `
+		UPDATE friend_requests
+		SET status = 'cancelled', responded_at = $1
+		WHERE status = 'pending'
+		  AND ((sender_id = $2 AND recipient_id = $3) OR (sender_id = $3 AND recipient_id = $2))
+	`
+
+	if _, err := r.db.Exec(query, time.Now(), userID, otherID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to cancel friend requests")
+		return fmt.Errorf("failed to cancel friend requests: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) AreContacts(userID, otherID int) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM contacts WHERE user_id = $1 AND contact_id = $2)`
+
+	var exists bool
+	if err := r.db.QueryRow(query, userID, otherID).Scan(&exists); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to check contact")
+		return false, fmt.Errorf("failed to check contact: %w", err)
+	}
+
+	return exists, nil
+}
+
+// IsBlockedBetween reports whether either user has blocked the other.
+func (r *UserRepository) IsBlockedBetween(userID, otherID int) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`
+
+	var blocked bool
+	if err := r.db.QueryRow(query, userID, otherID).Scan(&blocked); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to check block")
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+
+	return blocked, nil
+}
+
+// GetContacts lists the active contacts of userID by name.
+func (r *UserRepository) GetContacts(userID int) ([]Contact, error) {
+	query := `
+		SELECT ` + userColumns + `, c.nickname, c.contact_since
+		FROM users u
+		INNER JOIN (
+			SELECT contact_id, nickname, created_at AS contact_since
+			FROM contacts
+			WHERE user_id = $1
+		) c ON c.contact_id = u.id
+		WHERE u.is_active = true
+		ORDER BY COALESCE(c.nickname, u.display_name, u.username)
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get contacts")
+		return nil, fmt.Errorf("failed to get contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		var contact Contact
+		user, err := scanUser(rows, &contact.Nickname, &contact.Since)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan contact")
+			continue
+		}
+		contact.User = user.ToSummary()
+		contacts = append(contacts, contact)
+	}
+
+	return contacts, nil
+}
+
+func (r *UserRepository) GetContact(userID, contactID int) (*Contact, error) {
+	query := `
+		SELECT ` + userColumns + `, c.nickname, c.contact_since
+		FROM users u
+		INNER JOIN (
+			SELECT contact_id, nickname, created_at AS contact_since
+			FROM contacts
+			WHERE user_id = $1 AND contact_id = $2
+		) c ON c.contact_id = u.id
+	`
+
+	var contact Contact
+	user, err := scanUser(r.db.QueryRow(query, userID, contactID), &contact.Nickname, &contact.Since)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("contact not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get contact")
+		return nil, fmt.Errorf("failed to get contact: %w", err)
+	}
+	contact.User = user.ToSummary()
+
+	return &contact, nil
+}
+
+// SetContactNickname changes the nickname userID sees for contactID. A nil
+// nickname clears it.
+func (r *UserRepository) SetContactNickname(userID, contactID int, nickname *string) error {
+	query := `UPDATE contacts SET nickname = $1 WHERE user_id = $2 AND contact_id = $3`
+
+	result, err := r.db.Exec(query, nickname, userID, contactID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to update contact")
+		return fmt.Errorf("failed to update contact: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("contact not found")
+	}
+
+	return nil
+}
+
+// RemoveContact ends the friendship for both users.
+func (r *UserRepository) RemoveContact(userID, contactID int) error {
+	query := `
+		DELETE FROM contacts
+		WHERE (user_id = $1 AND contact_id = $2) OR (user_id = $2 AND contact_id = $1)
+	`
+
+	result, err := r.db.Exec(query, userID, contactID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to remove contact")
+		return fmt.Errorf("failed to remove contact: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("contact not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SendFriendRequest asks recipientID to become a contact of senderID. If
+// recipientID has already asked senderID, that request is accepted instead.
+func (us *UserService) SendFriendRequest(senderID, recipientID int) (*FriendRequest, error) {
+	if senderID == recipientID {
+		return nil, fmt.Errorf("cannot send a friend request to yourself")
+	}
+
+	recipient, err := us.repo.GetByID(recipientID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if recipient.IsBot {
+		return nil, fmt.Errorf("cannot send a friend request to a bot")
+	}
+
+	blocked, err := us.repo.IsBlockedBetween(senderID, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		// Same answer as an unknown user so the sender can't tell they were
+		// blocked.
+		return nil, fmt.Errorf("user not found")
+	}
+
+	isContact, err := us.repo.AreContacts(senderID, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	if isContact {
+		return nil, fmt.Errorf("user is already a contact")
+	}
+
+	if reverse, err := us.repo.GetPendingFriendRequest(recipientID, senderID); err == nil {
+		return us.AcceptFriendRequest(senderID, reverse.ID)
+	}
+
+	request, err := us.repo.CreateFriendRequest(senderID, recipientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if sender, err := us.repo.GetByID(senderID); err == nil {
+		summary := sender.ToSummary()
+		us.publishContactEvent(recipientID, ContactEvent{
+			Type:    EventFriendRequest,
+			Request: withRequestUser(request, &summary),
+		})
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":      senderID,
+		"recipient_id": recipientID,
+	}).Info("Friend request sent")
+
+	summary := recipient.ToSummary()
+	return withRequestUser(request, &summary), nil
+}
+
+func (us *UserService) AcceptFriendRequest(userID, requestID int) (*FriendRequest, error) {
+	request, err := us.getIncomingFriendRequest(userID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err = us.repo.RespondFriendRequest(request.ID, FriendRequestAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipient, err := us.repo.GetByID(userID); err == nil {
+		summary := recipient.ToSummary()
+		us.publishContactEvent(request.SenderID, ContactEvent{
+			Type:    EventFriendRequestAccepted,
+			Request: withRequestUser(request, &summary),
+		})
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"request_id": requestID,
+	}).Info("Friend request accepted")
+
+	if sender, err := us.repo.GetByID(request.SenderID); err == nil {
+		summary := sender.ToSummary()
+		request = withRequestUser(request, &summary)
+	}
+
+	return request, nil
+}
+
+// DeclineFriendRequest rejects an incoming request. The sender is not told;
+// the request simply stops being pending.
+func (us *UserService) DeclineFriendRequest(userID, requestID int) error {
+	request, err := us.getIncomingFriendRequest(userID, requestID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := us.repo.RespondFriendRequest(request.ID, FriendRequestDeclined); err != nil {
+		return err
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"request_id": requestID,
+	}).Info("Friend request declined")
+
+	return nil
+}
+
+func (us *UserService) CancelFriendRequest(userID, requestID int) error {
+	request, err := us.repo.GetFriendRequest(requestID)
+	if err != nil {
+		return err
+	}
+	if request.SenderID != userID || request.Status != FriendRequestPending {
+		return fmt.Errorf("friend request not found")
+	}
+
+	request, err = us.repo.RespondFriendRequest(request.ID, FriendRequestCancelled)
+	if err != nil {
+		return err
+	}
+
+	us.publishContactEvent(request.RecipientID, ContactEvent{
+		Type:    EventFriendRequestCancelled,
+		Request: request,
+	})
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"request_id": requestID,
+	}).Info("Friend request cancelled")
+
+	return nil
+}
+
+// GetFriendRequests lists pending requests for userID. direction is
+// "incoming" (the default) or "outgoing".
+func (us *UserService) GetFriendRequests(userID int, direction string) ([]FriendRequest, error) {
+	switch direction {
+	case "", "incoming":
+		return us.repo.GetFriendRequests(userID, true)
+	case "outgoing":
+		return us.repo.GetFriendRequests(userID, false)
+	default:
+		return nil, fmt.Errorf("direction must be incoming or outgoing")
+	}
+}
+
+func (us *UserService) GetContacts(userID int) ([]Contact, error) {
+	return us.repo.GetContacts(userID)
+}
+
+func (us *UserService) UpdateContact(userID, contactID int, update ContactUpdate) (*Contact, error) {
+	nickname := nullIfEmpty(strings.TrimSpace(update.Nickname))
+	if err := us.repo.SetContactNickname(userID, contactID, nickname); err != nil {
+		return nil, err
+	}
+
+	return us.repo.GetContact(userID, contactID)
+}
+
+// RemoveContact ends the friendship on both sides and tells the other user.
+func (us *UserService) RemoveContact(userID, contactID int) error {
+	if err := us.repo.RemoveContact(userID, contactID); err != nil {
+		return err
+	}
+
+	us.publishContactEvent(contactID, ContactEvent{
+		Type:   EventContactRemoved,
+		UserID: userID,
+	})
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"contact_id": contactID,
+	}).Info("Contact removed")
+
+	return nil
+}
+
+// getIncomingFriendRequest loads a pending request addressed to userID.
+// Requests addressed to someone else are reported as missing.
+func (us *UserService) getIncomingFriendRequest(userID, requestID int) (*FriendRequest, error) {
+	request, err := us.repo.GetFriendRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.RecipientID != userID || request.Status != FriendRequestPending {
+		return nil, fmt.Errorf("friend request not found")
+	}
+
+	return request, nil
+}
+
+// publishContactEvent notifies every open connection of userID. Failures are
+// logged; the change itself has already been stored.
+func (us *UserService) publishContactEvent(userID int, event ContactEvent) {
+	if err := us.redis.PublishUserEvent(userID, event); err != nil {
+		us.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"event":   event.Type,
+		}).Warn("Failed to publish contact event")
+	}
+}
+
+func withRequestUser(request *FriendRequest, user *UserSummary) *FriendRequest {
+	withUser := *request
+	withUser.User = user
+	return &withUser
+}
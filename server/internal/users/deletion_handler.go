@@ -0,0 +1,103 @@
+package users
+
+import (
+	"net/http"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeleteAccount schedules the account for deletion. The body is optional and
+// chooses what happens to the user's messages and chats.
+func (h *Handler) DeleteAccount(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req AccountDeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	deletion, err := h.service.ScheduleAccountDeletion(userID, req, clientInfo(c))
+	if err != nil {
+		switch err.Error() {
+		case "current password is incorrect":
+			h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to schedule account deletion")
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": err.Error(),
+			})
+		case "too many failed attempts, try again later":
+			h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to schedule account deletion")
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			h.logger.WithError(err).WithField("user_id", userID).Error("Failed to schedule account deletion")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to delete account",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, deletion)
+}
+
+func (h *Handler) GetAccountDeletion(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	deletion, err := h.service.GetAccountDeletion(userID)
+	if err != nil {
+		c.JSON(deletionStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, deletion)
+}
+
+func (h *Handler) CancelAccountDeletion(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	if err := h.service.CancelAccountDeletion(userID, clientInfo(c)); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to cancel account deletion")
+		c.JSON(deletionStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account deletion cancelled",
+	})
+}
+
+func deletionStatusCode(err error) int {
+	if err.Error() == "account deletion not scheduled" {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,239 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const accountDeletionColumns = `user_id, messages, chats, requested_at, scheduled_for`
+
+func scanAccountDeletion(row rowScanner) (*AccountDeletion, error) {
+	var deletion AccountDeletion
+	err := row.Scan(
+		&deletion.UserID,
+		&deletion.Messages,
+		&deletion.Chats,
+		&deletion.RequestedAt,
+		&deletion.ScheduledFor,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deletion, nil
+}
+
+// ScheduleAccountDeletion records a pending deletion, replacing the options
+// and date of one already scheduled.
+func (r *UserRepository) ScheduleAccountDeletion(deletion AccountDeletion) (*AccountDeletion, error) {
+	query := `
+		INSERT INTO account_deletions (user_id, messages, chats, requested_at, scheduled_for)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET messages = EXCLUDED.messages,
+		    chats = EXCLUDED.chats,
+		    requested_at = EXCLUDED.requested_at,
+		    scheduled_for = EXCLUDED.scheduled_for
+		RETURNING ` + accountDeletionColumns
+
+	scheduled, err := scanAccountDeletion(r.db.QueryRow(query,
+		deletion.UserID, deletion.Messages, deletion.Chats, deletion.RequestedAt, deletion.ScheduledFor))
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", deletion.UserID).Error("Failed to schedule account deletion")
+		return nil, fmt.Errorf("failed to schedule account deletion: %w", err)
+	}
+
+	return scheduled, nil
+}
+
+func (r *UserRepository) GetAccountDeletion(userID int) (*AccountDeletion, error) {
+	query := `SELECT ` + accountDeletionColumns + ` FROM account_deletions WHERE user_id = $1`
+
+	deletion, err := scanAccountDeletion(r.db.QueryRow(query, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account deletion not scheduled")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get account deletion")
+		return nil, fmt.Errorf("failed to get account deletion: %w", err)
+	}
+
+	return deletion, nil
+}
+
+func (r *UserRepository) CancelAccountDeletion(userID int) error {
+	query := `DELETE FROM account_deletions WHERE user_id = $1`
+
+	result, err := r.db.Exec(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to cancel account deletion")
+		return fmt.Errorf("failed to cancel account deletion: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("account deletion not scheduled")
+	}
+
+	return nil
+}
+
+// GetDueAccountDeletions lists deletions whose grace period has ended,
+// oldest first.
+func (r *UserRepository) GetDueAccountDeletions(now time.Time) ([]AccountDeletion, error) {
+	query := `
+		SELECT ` + accountDeletionColumns + `
+		FROM account_deletions
+		WHERE scheduled_for <= $1
+		ORDER BY scheduled_for
+	`
+
+	rows, err := r.db.Query(query, now)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to get due account deletions")
+		return nil, fmt.Errorf("failed to get account deletions: %w", err)
+	}
+	defer rows.Close()
+
+	deletions := []AccountDeletion{}
+	for rows.Next() {
+		deletion, err := scanAccountDeletion(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan account deletion")
+			continue
+		}
+		deletions = append(deletions, *deletion)
+	}
+
+	return deletions, nil
+}
+
+// GetBotIDsByOwner lists every bot of ownerID, including deactivated ones,
+// since all of them go with the owner's account.
+func (r *UserRepository) GetBotIDsByOwner(ownerID int) ([]int, error) {
+	rows, err := r.db.Query(`SELECT id FROM users WHERE bot_owner_id = $1`, ownerID)
+	if err != nil {
+		r.logger.WithError(err).WithField("owner_id", ownerID).Error("Failed to get bots")
+		return nil, fmt.Errorf("failed to get bots: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan bot: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bots: %w", err)
+	}
+
+	return ids, nil
+}
+
+// PurgeAccount removes the user of deletion for good, with their bots. Chats
+// owned by any of them are handed to the highest ranking remaining member,
+// or deleted when nobody is left or the user asked for them to be closed.
+// The user's messages are deleted or, through the foreign key, kept without
+// a sender. It returns how many chats were transferred and closed.
+func (r *UserRepository) PurgeAccount(deletion AccountDeletion, ownerIDs []int) (int, int, error) {
+	fields := logrus.Fields{"user_id": deletion.UserID}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var chatIDs []int
+	if deletion.Chats == DeletionTransferChats {
+		rows, err := tx.Query(`SELECT id FROM chats WHERE created_by = ANY($1) AND is_active = true`, ownerIDs)
+		if err != nil {
+			r.logger.WithError(err).WithFields(fields).Error("Failed to get owned chats")
+			return 0, 0, fmt.Errorf("failed to get owned chats: %w", err)
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return 0, 0, fmt.Errorf("failed to scan chat: %w", err)
+			}
+			chatIDs = append(chatIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, 0, fmt.Errorf("failed to read owned chats: %w", err)
+		}
+	}
+
+	now := time.Now()
+	transferred := 0
+	for _, chatID := range chatIDs {
+		var successorID int
+		err := tx.QueryRow(`
+			SELECT uc.user_id
+			FROM user_chat uc
+			INNER JOIN users u ON u.id = uc.user_id
+			WHERE uc.chat_id = $1 AND uc.user_id <> ALL($2)
+			  AND uc.is_banned = false AND u.is_active = true
+			ORDER BY CASE uc.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 WHEN 'moderator' THEN 2 ELSE 3 END,
+			         uc.joined_at
+			LIMIT 1
+		`, chatID, ownerIDs).Scan(&successorID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			r.logger.WithError(err).WithFields(fields).WithField("chat_id", chatID).Error("Failed to find new chat owner")
+			return 0, 0, fmt.Errorf("failed to find new chat owner: %w", err)
+		}
+
+		if _, err := tx.Exec(`UPDATE chats SET created_by = $1, updated_at = $2 WHERE id = $3`, successorID, now, chatID); err != nil {
+			r.logger.WithError(err).WithFields(fields).WithField("chat_id", chatID).Error("Failed to transfer chat")
+			return 0, 0, fmt.Errorf("failed to transfer chat: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE user_chat SET role = 'owner' WHERE chat_id = $1 AND user_id = $2`, chatID, successorID); err != nil {
+			r.logger.WithError(err).WithFields(fields).WithField("chat_id", chatID).Error("Failed to transfer chat")
+			return 0, 0, fmt.Errorf("failed to transfer chat: %w", err)
+		}
+		transferred++
+	}
+
+	// Whatever the user and their bots still own has nobody to take it over.
+	result, err := tx.Exec(`DELETE FROM chats WHERE created_by = ANY($1)`, ownerIDs)
+	if err != nil {
+		r.logger.WithError(err).WithFields(fields).Error("Failed to close owned chats")
+		return 0, 0, fmt.Errorf("failed to close owned chats: %w", err)
+	}
+	closed, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if deletion.Messages == DeletionDeleteMessages {
+		if _, err := tx.Exec(`DELETE FROM messages WHERE user_id = $1`, deletion.UserID); err != nil {
+			r.logger.WithError(err).WithFields(fields).Error("Failed to delete messages")
+			return 0, 0, fmt.Errorf("failed to delete messages: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = $1`, deletion.UserID); err != nil {
+		r.logger.WithError(err).WithFields(fields).Error("Failed to delete user")
+		return 0, 0, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return transferred, int(closed), nil
+}
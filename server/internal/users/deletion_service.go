@@ -0,0 +1,155 @@
+package users
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ScheduleAccountDeletion deletes the account of userID once the grace
+// period has passed. Until then the account keeps working, so the user can
+// change their mind. Wrong passwords count towards the login lockout.
+func (us *UserService) ScheduleAccountDeletion(userID int, req AccountDeletionRequest, client ClientInfo) (*AccountDeletion, error) {
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := us.verifyCurrentPassword(user, req.Password, client); err != nil {
+		return nil, err
+	}
+
+	if req.Messages == "" {
+		req.Messages = DeletionAnonymizeMessages
+	}
+	if req.Chats == "" {
+		req.Chats = DeletionTransferChats
+	}
+
+	now := time.Now()
+	deletion, err := us.repo.ScheduleAccountDeletion(AccountDeletion{
+		UserID:       userID,
+		Messages:     req.Messages,
+		Chats:        req.Chats,
+		RequestedAt:  now,
+		ScheduledFor: now.Add(us.cfg.AccountDeletionGrace),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	us.recordSecurityEvent(userID, EventDeletionScheduled, client, "")
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":       userID,
+		"messages":      deletion.Messages,
+		"chats":         deletion.Chats,
+		"scheduled_for": deletion.ScheduledFor,
+	}).Info("Account deletion scheduled")
+
+	return deletion, nil
+}
+
+func (us *UserService) GetAccountDeletion(userID int) (*AccountDeletion, error) {
+	return us.repo.GetAccountDeletion(userID)
+}
+
+func (us *UserService) CancelAccountDeletion(userID int, client ClientInfo) error {
+	if err := us.repo.CancelAccountDeletion(userID); err != nil {
+		return err
+	}
+
+	us.recordSecurityEvent(userID, EventDeletionCancelled, client, "")
+
+	us.logger.WithField("user_id", userID).Info("Account deletion cancelled")
+
+	return nil
+}
+
+// RunAccountDeletionWorker deletes the accounts whose grace period has ended
+// every interval. It does not return.
+func (us *UserService) RunAccountDeletionWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deletions, err := us.repo.GetDueAccountDeletions(time.Now())
+		if err != nil {
+			us.logger.WithError(err).Warn("Failed to get due account deletions")
+			continue
+		}
+
+		for _, deletion := range deletions {
+			us.purgeAccount(deletion)
+		}
+	}
+}
+
+// purgeAccount deletes the user of deletion with their bots, then cleans up
+// what lives outside the database: files, access tokens still in flight and
+// open connections.
+func (us *UserService) purgeAccount(deletion AccountDeletion) {
+	fields := logrus.Fields{"user_id": deletion.UserID}
+
+	botIDs, err := us.repo.GetBotIDsByOwner(deletion.UserID)
+	if err != nil {
+		return
+	}
+	ownerIDs := append([]int{deletion.UserID}, botIDs...)
+
+	sessions, err := us.repo.GetUserSessions(deletion.UserID)
+	if err != nil {
+		return
+	}
+	exports, err := us.repo.GetDataExports(deletion.UserID)
+	if err != nil {
+		return
+	}
+
+	var avatars []*string
+	for _, id := range ownerIDs {
+		if user, err := us.repo.GetByID(id); err == nil {
+			avatars = append(avatars, user.AvatarURL)
+		}
+	}
+
+	transferred, closed, err := us.repo.PurgeAccount(deletion, ownerIDs)
+	if err != nil {
+		us.logger.WithError(err).WithFields(fields).Error("Failed to delete account")
+		return
+	}
+
+	for _, session := range sessions {
+		if err := us.redis.RevokeSession(session.ID, us.cfg.AccessTokenTTL); err != nil {
+			us.logger.WithError(err).WithFields(fields).WithField("session_id", session.ID).Error("Failed to add session to denylist")
+		}
+	}
+	for _, id := range ownerIDs {
+		if err := us.redis.DisconnectUser(id); err != nil {
+			us.logger.WithError(err).WithFields(fields).Warn("Failed to disconnect deleted user")
+		}
+	}
+
+	for _, avatarURL := range avatars {
+		us.removeAvatarFile(avatarURL)
+	}
+	for _, export := range exports {
+		if export.FileName == nil {
+			continue
+		}
+		path := filepath.Join(us.cfg.ExportDir, *export.FileName)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			us.logger.WithError(err).WithFields(fields).WithField("export_id", export.ID).Warn("Failed to remove data export of deleted user")
+		}
+	}
+
+	us.logger.WithFields(fields).WithFields(logrus.Fields{
+		"messages":          deletion.Messages,
+		"bots":              len(botIDs),
+		"chats_transferred": transferred,
+		"chats_closed":      closed,
+	}).Info("Account deleted")
+}
@@ -0,0 +1,347 @@
+package users
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// exportBatchSize is how many messages are read from the database at once
+// while writing an export.
+const exportBatchSize = 1000
+
+// ExportChat is a chat the user belongs to, as written to chats.json.
+type ExportChat struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description *string    `json:"description,omitempty"`
+	IsPrivate   bool       `json:"is_private"`
+	IsDirect    bool       `json:"is_direct"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Role        string     `json:"role"`
+	JoinedAt    time.Time  `json:"joined_at"`
+	IsMuted     bool       `json:"is_muted"`
+	IsBanned    bool       `json:"is_banned"`
+	LastReadAt  *time.Time `json:"last_read_at,omitempty"`
+}
+
+// ExportMessage is a message the user sent, as written to messages.json.
+type ExportMessage struct {
+	ID          int        `json:"id"`
+	ChatID      int        `json:"chat_id"`
+	ChatName    string     `json:"chat_name"`
+	Content     string     `json:"content"`
+	MessageType string     `json:"message_type"`
+	ReplyToID   *int       `json:"reply_to_id,omitempty"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	IsDeleted   bool       `json:"is_deleted"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ExportAttachment lists a file shared by the user. File is the path inside
+// the archive when a copy is included.
+type ExportAttachment struct {
+	Kind      string `json:"kind"`
+	MessageID int    `json:"message_id,omitempty"`
+	ChatID    int    `json:"chat_id,omitempty"`
+	URL       string `json:"url"`
+	File      string `json:"file,omitempty"`
+}
+
+type exportProfile struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	User       UserResponse  `json:"user"`
+	Settings   *UserSettings `json:"settings"`
+	Contacts   []Contact     `json:"contacts"`
+	Blocked    []BlockedUser `json:"blocked_users"`
+}
+
+// writeDataExport writes the archive for userID to w: profile.json,
+// chats.json, messages.json, attachments.json with copies of locally stored
+// files, and index.html to browse it all without other tools.
+func (us *UserService) writeDataExport(userID int, w io.Writer) error {
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	profile := exportProfile{
+		ExportedAt: time.Now(),
+		User:       user.ToResponse(),
+	}
+	if profile.Settings, err = us.repo.GetSettings(userID); err != nil {
+		return err
+	}
+	if profile.Contacts, err = us.repo.GetContacts(userID); err != nil {
+		return err
+	}
+	if profile.Blocked, err = us.repo.GetBlockedUsers(userID); err != nil {
+		return err
+	}
+
+	chats, err := us.repo.GetExportChats(userID)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+
+	if err := writeExportJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+	if err := writeExportJSON(archive, "chats.json", chats); err != nil {
+		return err
+	}
+
+	attachments, err := us.writeExportMessages(archive, userID)
+	if err != nil {
+		return err
+	}
+
+	if file, ok := us.localAvatarPath(user.AvatarURL); ok && fileExists(file) {
+		name := "attachments/avatar" + path.Ext(file)
+		if err := copyExportFile(archive, name, file); err != nil {
+			return err
+		}
+		attachments = append([]ExportAttachment{{Kind: "avatar", URL: *user.AvatarURL, File: name}}, attachments...)
+	}
+
+	if err := writeExportJSON(archive, "attachments.json", attachments); err != nil {
+		return err
+	}
+
+	if err := us.writeExportViewer(archive, profile, chats, attachments); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish export archive: %w", err)
+	}
+
+	return nil
+}
+
+// writeExportMessages streams the user's messages into messages.json and
+// returns the files they shared.
+func (us *UserService) writeExportMessages(archive *zip.Writer, userID int) ([]ExportAttachment, error) {
+	out, err := archive.Create("messages.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write messages.json: %w", err)
+	}
+
+	attachments := []ExportAttachment{}
+	if _, err := io.WriteString(out, "["); err != nil {
+		return nil, fmt.Errorf("failed to write messages.json: %w", err)
+	}
+
+	first := true
+	err = us.eachExportMessage(userID, func(message ExportMessage) error {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+
+		separator := ",\n"
+		if first {
+			separator = "\n"
+			first = false
+		}
+		if _, err := io.WriteString(out, separator); err != nil {
+			return fmt.Errorf("failed to write messages.json: %w", err)
+		}
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("failed to write messages.json: %w", err)
+		}
+
+		if (message.MessageType == "image" || message.MessageType == "file") && !message.IsDeleted {
+			attachments = append(attachments, ExportAttachment{
+				Kind:      message.MessageType,
+				MessageID: message.ID,
+				ChatID:    message.ChatID,
+				URL:       message.Content,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(out, "\n]\n"); err != nil {
+		return nil, fmt.Errorf("failed to write messages.json: %w", err)
+	}
+
+	return attachments, nil
+}
+
+func (us *UserService) eachExportMessage(userID int, fn func(ExportMessage) error) error {
+	afterID := 0
+	for {
+		messages, err := us.repo.GetExportMessages(userID, afterID, exportBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			if err := fn(message); err != nil {
+				return err
+			}
+		}
+
+		if len(messages) < exportBatchSize {
+			return nil
+		}
+		afterID = messages[len(messages)-1].ID
+	}
+}
+
+// writeExportViewer renders index.html. Messages are read again in batches
+// rather than kept in memory from the JSON pass.
+func (us *UserService) writeExportViewer(archive *zip.Writer, profile exportProfile, chats []ExportChat, attachments []ExportAttachment) error {
+	out, err := archive.Create("index.html")
+	if err != nil {
+		return fmt.Errorf("failed to write index.html: %w", err)
+	}
+
+	data := map[string]interface{}{
+		"Profile":     profile,
+		"Chats":       chats,
+		"Attachments": attachments,
+	}
+	if err := exportViewer.ExecuteTemplate(out, "header", data); err != nil {
+		return fmt.Errorf("failed to render index.html: %w", err)
+	}
+
+	err = us.eachExportMessage(profile.User.ID, func(message ExportMessage) error {
+		return exportViewer.ExecuteTemplate(out, "message", message)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render index.html: %w", err)
+	}
+
+	if err := exportViewer.ExecuteTemplate(out, "footer", data); err != nil {
+		return fmt.Errorf("failed to render index.html: %w", err)
+	}
+
+	return nil
+}
+
+func writeExportJSON(archive *zip.Writer, name string, value interface{}) error {
+	out, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
+
+func copyExportFile(archive *zip.Writer, name, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer in.Close()
+
+	out, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	return nil
+}
+
+var exportViewer = template.Must(template.New("viewer").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Data export for {{.Profile.User.Username}}</title>
+<style>
+body { font-family: sans-serif; margin: 2rem auto; max-width: 60rem; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2rem; }
+th, td { border-bottom: 1px solid #ddd; padding: .4rem; text-align: left; vertical-align: top; }
+td.content { white-space: pre-wrap; word-break: break-word; }
+.deleted { color: #999; }
+</style>
+</head>
+<body>
+<h1>Data export for {{.Profile.User.Username}}</h1>
+<p>Exported {{time .Profile.ExportedAt}}. The same data is in the JSON files next to this page.</p>
+
+<h2>Profile</h2>
+<table>
+<tr><th>Username</th><td>{{.Profile.User.Username}}</td></tr>
+<tr><th>Email</th><td>{{.Profile.User.Email}}</td></tr>
+{{with .Profile.User.DisplayName}}<tr><th>Display name</th><td>{{.}}</td></tr>{{end}}
+{{with .Profile.User.Bio}}<tr><th>Bio</th><td>{{.}}</td></tr>{{end}}
+<tr><th>Member since</th><td>{{time .Profile.User.CreatedAt}}</td></tr>
+<tr><th>Direct messages from</th><td>{{.Profile.Settings.DirectMessages}}</td></tr>
+<tr><th>Presence visible to</th><td>{{.Profile.Settings.PresenceVisibility}}</td></tr>
+<tr><th>Appears in search</th><td>{{.Profile.Settings.Searchable}}</td></tr>
+</table>
+
+<h2>Contacts</h2>
+<table>
+<tr><th>User</th><th>Nickname</th><th>Since</th></tr>
+{{range .Profile.Contacts}}<tr><td>{{.User.Username}}</td><td>{{with .Nickname}}{{.}}{{end}}</td><td>{{time .Since}}</td></tr>
+{{else}}<tr><td colspan="3">None</td></tr>
+{{end}}</table>
+
+<h2>Blocked users</h2>
+<table>
+<tr><th>User</th><th>Blocked</th></tr>
+{{range .Profile.Blocked}}<tr><td>{{.User.Username}}</td><td>{{time .BlockedAt}}</td></tr>
+{{else}}<tr><td colspan="2">None</td></tr>
+{{end}}</table>
+
+<h2>Chats</h2>
+<table>
+<tr><th>Chat</th><th>Role</th><th>Joined</th></tr>
+{{range .Chats}}<tr><td>{{.Name}}{{if .IsDirect}} (direct){{end}}</td><td>{{.Role}}</td><td>{{time .JoinedAt}}</td></tr>
+{{else}}<tr><td colspan="3">None</td></tr>
+{{end}}</table>
+
+<h2>Attachments</h2>
+<table>
+<tr><th>Kind</th><th>File</th></tr>
+{{range .Attachments}}<tr><td>{{.Kind}}</td><td>{{if .File}}<a href="{{.File}}">{{.File}}</a>{{else}}{{.URL}}{{end}}</td></tr>
+{{else}}<tr><td colspan="2">None</td></tr>
+{{end}}</table>
+
+<h2>Messages</h2>
+<table>
+<tr><th>Sent</th><th>Chat</th><th>Message</th></tr>
+{{end}}
+
+{{define "message"}}<tr{{if .IsDeleted}} class="deleted"{{end}}><td>{{time .CreatedAt}}</td><td>{{.ChatName}}</td><td class="content">{{.Content}}{{if .IsDeleted}} (deleted){{else if .EditedAt}} (edited){{end}}</td></tr>
+{{end}}
+
+{{define "footer"}}</table>
+</body>
+</html>
+{{end}}
+`))
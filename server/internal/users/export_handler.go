@@ -0,0 +1,124 @@
+package users
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) RequestDataExport(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	export, err := h.service.RequestDataExport(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to request data export")
+		c.JSON(exportStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, export)
+}
+
+func (h *Handler) GetDataExports(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	exports, err := h.service.GetDataExports(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get data exports")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get data exports",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exports": exports,
+	})
+}
+
+func (h *Handler) GetDataExport(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid export ID",
+		})
+		return
+	}
+
+	export, err := h.service.GetDataExport(userID, exportID)
+	if err != nil {
+		c.JSON(exportStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
+
+// DownloadDataExport serves an export archive. It is authorised by the signed
+// link alone so it can be opened directly in a browser.
+func (h *Handler) DownloadDataExport(c *gin.Context) {
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid export ID",
+		})
+		return
+	}
+
+	path, err := h.service.OpenDataExport(exportID, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		h.logger.WithError(err).WithField("export_id", exportID).Warn("Rejected data export download")
+		c.JSON(exportStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.FileAttachment(path, "data-export-"+strconv.Itoa(exportID)+".zip")
+}
+
+func exportStatusCode(err error) int {
+	switch {
+	case err.Error() == "export not found":
+		return http.StatusNotFound
+	case err.Error() == "an export is already in progress":
+		return http.StatusConflict
+	case err.Error() == "invalid download link" || err.Error() == "download link has expired":
+		return http.StatusForbidden
+	case err.Error() == "export has expired":
+		return http.StatusGone
+	case strings.HasPrefix(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,253 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const dataExportColumns = `id, user_id, status, file_name, size_bytes, error, created_at, completed_at, expires_at`
+
+func scanDataExport(row rowScanner) (*DataExport, error) {
+	var export DataExport
+	err := row.Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.FileName,
+		&export.SizeBytes,
+		&export.Error,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// CreateDataExport queues an export. A user can only have one unfinished
+// export at a time.
+func (r *UserRepository) CreateDataExport(userID int) (*DataExport, error) {
+	query := `
+		INSERT INTO data_exports (user_id, status, created_at)
+		VALUES ($1, 'pending', $2)
+		ON CONFLICT DO NOTHING
+		RETURNING ` + dataExportColumns
+
+	export, err := scanDataExport(r.db.QueryRow(query, userID, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("an export is already in progress")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to create data export")
+		return nil, fmt.Errorf("failed to create data export: %w", err)
+	}
+
+	return export, nil
+}
+
+func (r *UserRepository) GetDataExport(id int) (*DataExport, error) {
+	query := `SELECT ` + dataExportColumns + ` FROM data_exports WHERE id = $1`
+
+	export, err := scanDataExport(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("export not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to get data export")
+		return nil, fmt.Errorf("failed to get data export: %w", err)
+	}
+
+	return export, nil
+}
+
+// GetDataExports lists the exports of userID, newest first.
+func (r *UserRepository) GetDataExports(userID int) ([]DataExport, error) {
+	query := `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	return r.queryDataExports(query, userID)
+}
+
+// GetUnfinishedDataExports lists exports that were pending or being built,
+// oldest first. After a restart these are started again.
+func (r *UserRepository) GetUnfinishedDataExports() ([]DataExport, error) {
+	query := `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports
+		WHERE status IN ('pending', 'processing')
+		ORDER BY created_at
+	`
+
+	return r.queryDataExports(query)
+}
+
+// GetExpiredDataExports lists ready exports whose download window has passed.
+func (r *UserRepository) GetExpiredDataExports(now time.Time) ([]DataExport, error) {
+	query := `
+		SELECT ` + dataExportColumns + `
+		FROM data_exports
+		WHERE status = 'ready' AND expires_at <= $1
+	`
+
+	return r.queryDataExports(query, now)
+}
+
+func (r *UserRepository) queryDataExports(query string, args ...interface{}) ([]DataExport, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to get data exports")
+		return nil, fmt.Errorf("failed to get data exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []DataExport{}
+	for rows.Next() {
+		export, err := scanDataExport(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan data export")
+			continue
+		}
+		exports = append(exports, *export)
+	}
+
+	return exports, nil
+}
+
+func (r *UserRepository) StartDataExport(id int) error {
+	query := `UPDATE data_exports SET status = 'processing' WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id); err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to start data export")
+		return fmt.Errorf("failed to start data export: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) CompleteDataExport(id int, fileName string, size int64, expiresAt time.Time) (*DataExport, error) {
+	query := `
+		UPDATE data_exports
+		SET status = 'ready', file_name = $1, size_bytes = $2, completed_at = $3, expires_at = $4, error = NULL
+		WHERE id = $5
+		RETURNING ` + dataExportColumns
+
+	export, err := scanDataExport(r.db.QueryRow(query, fileName, size, time.Now(), expiresAt, id))
+	if err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to complete data export")
+		return nil, fmt.Errorf("failed to complete data export: %w", err)
+	}
+
+	return export, nil
+}
+
+func (r *UserRepository) FailDataExport(id int, reason string) (*DataExport, error) {
+	query := `
+		UPDATE data_exports
+		SET status = 'failed', error = $1, completed_at = $2
+		WHERE id = $3
+		RETURNING ` + dataExportColumns
+
+	export, err := scanDataExport(r.db.QueryRow(query, reason, time.Now(), id))
+	if err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to mark data export as failed")
+		return nil, fmt.Errorf("failed to update data export: %w", err)
+	}
+
+	return export, nil
+}
+
+// ExpireDataExport marks an export expired once its file has been removed.
+func (r *UserRepository) ExpireDataExport(id int) error {
+	query := `UPDATE data_exports SET status = 'expired', file_name = NULL WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id); err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to expire data export")
+		return fmt.Errorf("failed to expire data export: %w", err)
+	}
+
+	return nil
+}
+
+// GetExportChats lists every chat userID belongs to, with their membership.
+func (r *UserRepository) GetExportChats(userID int) ([]ExportChat, error) {
+	query := `
+		SELECT c.id, c.name, c.description, c.is_private, c.is_direct, c.created_at,
+		       uc.role, uc.joined_at, uc.is_muted, uc.is_banned, uc.last_read_at
+		FROM user_chat uc
+		INNER JOIN chats c ON c.id = uc.chat_id
+		WHERE uc.user_id = $1
+		ORDER BY uc.joined_at
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get chats for export")
+		return nil, fmt.Errorf("failed to get chats: %w", err)
+	}
+	defer rows.Close()
+
+	chats := []ExportChat{}
+	for rows.Next() {
+		var chat ExportChat
+		err := rows.Scan(
+			&chat.ID, &chat.Name, &chat.Description, &chat.IsPrivate, &chat.IsDirect, &chat.CreatedAt,
+			&chat.Role, &chat.JoinedAt, &chat.IsMuted, &chat.IsBanned, &chat.LastReadAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan chat: %w", err)
+		}
+		chats = append(chats, chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chats: %w", err)
+	}
+
+	return chats, nil
+}
+
+// GetExportMessages returns up to limit messages sent by userID with an ID
+// above afterID, in ID order, so large histories can be read in batches.
+func (r *UserRepository) GetExportMessages(userID, afterID, limit int) ([]ExportMessage, error) {
+	query := `
+		SELECT m.id, m.chat_id, COALESCE(c.name, ''), m.content, COALESCE(m.message_type, 'text'),
+		       m.reply_to_id, m.edited_at, COALESCE(m.is_deleted, false), m.deleted_at, m.created_at
+		FROM messages m
+		LEFT JOIN chats c ON c.id = m.chat_id
+		WHERE m.user_id = $1 AND m.id > $2
+		ORDER BY m.id
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(query, userID, afterID, limit)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get messages for export")
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []ExportMessage{}
+	for rows.Next() {
+		var message ExportMessage
+		err := rows.Scan(
+			&message.ID, &message.ChatID, &message.ChatName, &message.Content, &message.MessageType,
+			&message.ReplyToID, &message.EditedAt, &message.IsDeleted, &message.DeletedAt, &message.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,228 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequestDataExport queues an archive of everything stored about userID. It
+// is built in the background; poll GetDataExport for the download link.
+func (us *UserService) RequestDataExport(userID int) (*DataExport, error) {
+	export, err := us.repo.CreateDataExport(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	go us.runDataExport(export.ID, userID)
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"export_id": export.ID,
+	}).Info("Data export requested")
+
+	return export, nil
+}
+
+// GetDataExport returns an export of userID, with a fresh download link if
+// it is ready.
+func (us *UserService) GetDataExport(userID, exportID int) (*DataExport, error) {
+	export, err := us.repo.GetDataExport(exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export.UserID != userID {
+		return nil, fmt.Errorf("export not found")
+	}
+
+	us.attachDownloadURL(export)
+	return export, nil
+}
+
+func (us *UserService) GetDataExports(userID int) ([]DataExport, error) {
+	exports, err := us.repo.GetDataExports(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range exports {
+		us.attachDownloadURL(&exports[i])
+	}
+
+	return exports, nil
+}
+
+// OpenDataExport checks a signed download link and returns the archive's
+// path on disk.
+func (us *UserService) OpenDataExport(exportID int, expires, signature string) (string, error) {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !hmac.Equal([]byte(signature), []byte(us.signDataExport(exportID, expiresAt))) {
+		return "", fmt.Errorf("invalid download link")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", fmt.Errorf("download link has expired")
+	}
+
+	export, err := us.repo.GetDataExport(exportID)
+	if err != nil {
+		return "", err
+	}
+	if export.Status != DataExportReady || export.FileName == nil ||
+		(export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt)) {
+		return "", fmt.Errorf("export has expired")
+	}
+
+	return filepath.Join(us.cfg.ExportDir, *export.FileName), nil
+}
+
+// RunDataExportWorker restarts exports interrupted by a shutdown, then
+// removes expired archives every interval. It does not return.
+func (us *UserService) RunDataExportWorker(interval time.Duration) {
+	unfinished, err := us.repo.GetUnfinishedDataExports()
+	if err != nil {
+		us.logger.WithError(err).Warn("Failed to resume data exports")
+	}
+	for _, export := range unfinished {
+		go us.runDataExport(export.ID, export.UserID)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		us.expireDataExports()
+	}
+}
+
+func (us *UserService) expireDataExports() {
+	expired, err := us.repo.GetExpiredDataExports(time.Now())
+	if err != nil {
+		us.logger.WithError(err).Warn("Failed to get expired data exports")
+		return
+	}
+
+	for _, export := range expired {
+		if export.FileName != nil {
+			path := filepath.Join(us.cfg.ExportDir, *export.FileName)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				us.logger.WithError(err).WithField("export_id", export.ID).Warn("Failed to remove expired data export")
+				continue
+			}
+		}
+
+		if err := us.repo.ExpireDataExport(export.ID); err != nil {
+			continue
+		}
+
+		us.logger.WithField("export_id", export.ID).Info("Data export expired")
+	}
+}
+
+func (us *UserService) runDataExport(exportID, userID int) {
+	fields := logrus.Fields{
+		"user_id":   userID,
+		"export_id": exportID,
+	}
+
+	if err := us.repo.StartDataExport(exportID); err != nil {
+		return
+	}
+
+	fileName, size, err := us.buildDataExport(exportID, userID)
+	var export *DataExport
+	if err != nil {
+		us.logger.WithError(err).WithFields(fields).Error("Failed to build data export")
+		export, err = us.repo.FailDataExport(exportID, "The export could not be created. Please try again.")
+	} else {
+		export, err = us.repo.CompleteDataExport(exportID, fileName, size, time.Now().Add(us.cfg.ExportTTL))
+		us.logger.WithFields(fields).WithField("size_bytes", size).Info("Data export ready")
+	}
+	if err != nil {
+		return
+	}
+
+	us.attachDownloadURL(export)
+	if err := us.redis.PublishUserEvent(userID, DataExportEvent{Type: EventDataExportReady, Export: export}); err != nil {
+		us.logger.WithError(err).WithFields(fields).Warn("Failed to publish data export event")
+	}
+}
+
+// buildDataExport writes the archive under a temporary name and renames it
+// once complete, so a partial file is never offered for download.
+func (us *UserService) buildDataExport(exportID, userID int) (string, int64, error) {
+	if err := os.MkdirAll(us.cfg.ExportDir, 0o700); err != nil {
+		return "", 0, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	name, err := exportFileName(userID, exportID)
+	if err != nil {
+		return "", 0, err
+	}
+	path := filepath.Join(us.cfg.ExportDir, name)
+
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	if err := us.writeDataExport(userID, file); err != nil {
+		file.Close()
+		os.Remove(path + ".tmp")
+		return "", 0, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return "", 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	info, err := os.Stat(path + ".tmp")
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return "", 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return "", 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	return name, info.Size(), nil
+}
+
+func exportFileName(userID, exportID int) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate export name: %w", err)
+	}
+
+	return fmt.Sprintf("%d-%d-%s.zip", userID, exportID, hex.EncodeToString(b)), nil
+}
+
+// attachDownloadURL sets a signed link on a ready export. The link expires
+// after ExportLinkTTL, or with the export if that is sooner.
+func (us *UserService) attachDownloadURL(export *DataExport) {
+	if export.Status != DataExportReady || export.ExpiresAt == nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(us.cfg.ExportLinkTTL)
+	if export.ExpiresAt.Before(expiresAt) {
+		expiresAt = *export.ExpiresAt
+	}
+
+	export.DownloadURL = fmt.Sprintf("/exports/%d/download?expires=%d&signature=%s",
+		export.ID, expiresAt.Unix(), us.signDataExport(export.ID, expiresAt.Unix()))
+}
+
+func (us *UserService) signDataExport(exportID int, expiresAt int64) string {
+	mac := hmac.New(sha256.New, us.cfg.ExportLinkSecret)
+	fmt.Fprintf(mac, "data-export:%d:%d", exportID, expiresAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
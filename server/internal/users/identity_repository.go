@@ -0,0 +1,59 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (r *UserRepository) GetIdentity(provider, subject string) (*UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	identity := &UserIdentity{}
+	err := r.db.QueryRow(query, provider, subject).Scan(
+		&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject,
+		&identity.Email, &identity.CreatedAt, &identity.LastLoginAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("identity not found")
+		}
+		r.logger.WithError(err).Error("Failed to get user identity")
+		return nil, fmt.Errorf("failed to get user identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+func (r *UserRepository) CreateIdentity(identity UserIdentity) (*UserIdentity, error) {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		RETURNING id, created_at, last_login_at
+	`
+
+	err := r.db.QueryRow(query,
+		identity.UserID, identity.Provider, identity.Subject, identity.Email, time.Now(),
+	).Scan(&identity.ID, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", identity.UserID).Error("Failed to create user identity")
+		return nil, fmt.Errorf("failed to create user identity: %w", err)
+	}
+
+	return &identity, nil
+}
+
+func (r *UserRepository) TouchIdentity(id int) error {
+	query := `UPDATE user_identities SET last_login_at = $1 WHERE id = $2`
+
+	if _, err := r.db.Exec(query, time.Now(), id); err != nil {
+		r.logger.WithError(err).Error("Failed to update user identity")
+		return fmt.Errorf("failed to update user identity: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package users
+
+import (
+	"time"
+
+	"onlineChat/pkg/config"
+	"onlineChat/pkg/redis"
+
+	"github.com/sirupsen/logrus"
+)
+
+type LoginAttemptStore interface {
+	IncrLoginFailures(key string, window time.Duration) (int, error)
+	GetLoginFailures(key string) (int, error)
+	SetLoginLock(key string, until time.Time, window time.Duration) error
+	GetLoginLock(key string) (*time.Time, error)
+	ClearLoginFailures(key string) error
+}
+
+// postgresAttemptStore adapts UserRepository to LoginAttemptStore so the
+// counters survive a Redis outage.
+type postgresAttemptStore struct {
+	repo   *UserRepository
+	window time.Duration
+}
+
+func (s *postgresAttemptStore) IncrLoginFailures(key string, window time.Duration) (int, error) {
+	return s.repo.IncrLoginFailures(key, window)
+}
+
+func (s *postgresAttemptStore) GetLoginFailures(key string) (int, error) {
+	return s.repo.GetLoginFailures(key, time.Now().Add(-s.window))
+}
+
+// SetLoginLock needs no window, since the counter in the database is kept
+// for the window after locked_until by the failure queries.
+func (s *postgresAttemptStore) SetLoginLock(key string, until time.Time, _ time.Duration) error {
+	return s.repo.SetLoginLock(key, until)
+}
+
+func (s *postgresAttemptStore) GetLoginLock(key string) (*time.Time, error) {
+	return s.repo.GetLoginLock(key)
+}
+
+func (s *postgresAttemptStore) ClearLoginFailures(key string) error {
+	return s.repo.ClearLoginFailures(key)
+}
+
+// LoginGuard tracks failed logins per account and per client IP and locks
+// them out with exponential backoff once a threshold is crossed.
+type LoginGuard struct {
+	primary  LoginAttemptStore
+	fallback LoginAttemptStore
+	cfg      config.LockoutConfig
+	logger   *logrus.Logger
+}
+
+func NewLoginGuard(redisClient *redis.RedisClient, repo *UserRepository, cfg config.LockoutConfig, logger *logrus.Logger) *LoginGuard {
+	return &LoginGuard{
+		primary: redisClient,
+		fallback: &postgresAttemptStore{
+			repo:   repo,
+			window: cfg.FailureWindow,
+		},
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// LockedUntil returns the latest lock expiry of the account and the IP, or
+// nil if neither is locked.
+func (g *LoginGuard) LockedUntil(email, ip string) *time.Time {
+	var lockedUntil *time.Time
+
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		var until *time.Time
+		err := g.do("get_lock", func(store LoginAttemptStore) error {
+			var err error
+			until, err = store.GetLoginLock(key)
+			return err
+		})
+		if err != nil {
+			g.logger.WithError(err).Error("Failed to check login lock")
+			continue
+		}
+
+		if until != nil && (lockedUntil == nil || until.After(*lockedUntil)) {
+			lockedUntil = until
+		}
+	}
+
+	return lockedUntil
+}
+
+// RecordFailure counts a failed login and returns the new lock expiry of the
+// account if this failure locked it.
+func (g *LoginGuard) RecordFailure(email, ip string) *time.Time {
+	accountLock := g.recordFailure(accountKey(email), g.cfg.AccountThreshold)
+	g.recordFailure(ipKey(ip), g.cfg.IPThreshold)
+
+	return accountLock
+}
+
+// RecordSuccess resets the account's failure counter and reports whether the
+// account had been locked out before. The IP counter is left alone so an
+// attacker cannot reset it by logging into an account of their own.
+func (g *LoginGuard) RecordSuccess(email string) bool {
+	key := accountKey(email)
+
+	var failures int
+	err := g.do("get_failures", func(store LoginAttemptStore) error {
+		var err error
+		failures, err = store.GetLoginFailures(key)
+		return err
+	})
+	if err != nil {
+		g.logger.WithError(err).Error("Failed to get login failures")
+	}
+
+	if failures == 0 {
+		return false
+	}
+
+	if err := g.do("clear", func(store LoginAttemptStore) error {
+		return store.ClearLoginFailures(key)
+	}); err != nil {
+		g.logger.WithError(err).Error("Failed to clear login failures")
+	}
+
+	return failures >= g.cfg.AccountThreshold
+}
+
+func (g *LoginGuard) recordFailure(key string, threshold int) *time.Time {
+	var count int
+	err := g.do("incr_failures", func(store LoginAttemptStore) error {
+		var err error
+		count, err = store.IncrLoginFailures(key, g.cfg.FailureWindow)
+		return err
+	})
+	if err != nil {
+		g.logger.WithError(err).Error("Failed to record login failure")
+		return nil
+	}
+
+	if threshold <= 0 || count < threshold {
+		return nil
+	}
+
+	until := time.Now().Add(g.backoff(count - threshold))
+	if err := g.do("set_lock", func(store LoginAttemptStore) error {
+		return store.SetLoginLock(key, until, g.cfg.FailureWindow)
+	}); err != nil {
+		g.logger.WithError(err).Error("Failed to set login lock")
+		return nil
+	}
+
+	return &until
+}
+
+// backoff doubles the lock duration for every failure past the threshold.
+func (g *LoginGuard) backoff(excess int) time.Duration {
+	duration := g.cfg.BaseDuration
+	for i := 0; i < excess && duration < g.cfg.MaxDuration; i++ {
+		duration *= 2
+	}
+
+	if duration > g.cfg.MaxDuration {
+		duration = g.cfg.MaxDuration
+	}
+
+	return duration
+}
+
+func (g *LoginGuard) do(op string, fn func(LoginAttemptStore) error) error {
+	if err := fn(g.primary); err != nil {
+		g.logger.WithError(err).WithField("operation", op).Warn("Redis unavailable for login attempts, using database")
+		return fn(g.fallback)
+	}
+
+	return nil
+}
+
+func accountKey(email string) string {
+	return "account:" + normalizeEmail(email)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,120 @@
+package users
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie holds a hash of the state of the OIDC login started in the
+// browser. OIDCCallback only accepts that state, so an attacker cannot
+// finish their own login in a victim's browser.
+const oidcStateCookie = "oidc_state"
+
+// OIDCAuthorize returns the provider URL that starts an OIDC login. The
+// provider redirects back to the frontend, which posts the code and state to
+// OIDCCallback.
+func (h *Handler) OIDCAuthorize(c *gin.Context) {
+	h.startOIDCLogin(c, 0)
+}
+
+// OIDCLink starts an OIDC login that links the provider identity to the
+// signed-in user. It is how users with two-factor authentication, whose
+// accounts are never linked by email, add a provider.
+func (h *Handler) OIDCLink(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	h.startOIDCLogin(c, userID)
+}
+
+func (h *Handler) startOIDCLogin(c *gin.Context, linkUserID int) {
+	authURL, state, err := h.service.StartOIDCLogin(linkUserID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to start oidc login")
+		c.JSON(oidcStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	setOIDCStateCookie(c, hashToken(state), 0)
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorization_url": authURL,
+	})
+}
+
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid oidc callback request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	stateHash, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+
+	if subtle.ConstantTimeCompare([]byte(stateHash), []byte(hashToken(req.State))) != 1 {
+		h.logger.WithField("client_ip", c.ClientIP()).Warn("Oidc callback without matching state cookie")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid or expired oidc state",
+		})
+		return
+	}
+
+	response, err := h.service.FinishOIDCLogin(req, clientInfo(c))
+	if err != nil {
+		h.logger.WithError(err).Warn("Failed oidc login")
+		c.JSON(oidcStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// setOIDCStateCookie sets the state cookie for the callback route only. A
+// negative maxAge deletes it.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, "/auth/oidc", "", secure, true)
+}
+
+func oidcStatusCode(err error) int {
+	switch {
+	case err.Error() == "oidc login not configured":
+		return http.StatusNotFound
+	case err.Error() == "invalid or expired oidc state":
+		return http.StatusBadRequest
+	case err.Error() == "sign in to link this provider to your account":
+		return http.StatusForbidden
+	case err.Error() == "oidc identity is linked to another account":
+		return http.StatusConflict
+	case strings.HasPrefix(err.Error(), "invalid id token") ||
+		err.Error() == "oidc provider did not return a verified email":
+		return http.StatusUnauthorized
+	case strings.HasPrefix(err.Error(), "failed to exchange") ||
+		strings.HasPrefix(err.Error(), "failed to discover") ||
+		strings.HasPrefix(err.Error(), "failed to fetch signing keys"):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
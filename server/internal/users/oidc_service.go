@@ -0,0 +1,309 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxUsernameAttempts bounds how many suffixed usernames are tried before
+// giving up on a first OIDC login.
+const maxUsernameAttempts = 20
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+type oidcLoginState struct {
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserID int    `json:"link_user_id,omitempty"`
+}
+
+// StartOIDCLogin prepares an authorization request and returns the provider
+// URL the client should navigate to, along with its state. A linkUserID other
+// than zero links the provider identity to that signed-in user instead of
+// looking it up.
+func (us *UserService) StartOIDCLogin(linkUserID int) (string, string, error) {
+	if us.oidc == nil {
+		return "", "", fmt.Errorf("oidc login not configured")
+	}
+
+	state, _, err := generateOpaqueToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, _, err := generateOpaqueToken(24)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, _, err := generateOpaqueToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := us.oidc.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := json.Marshal(oidcLoginState{
+		Verifier:   verifier,
+		Nonce:      nonce,
+		LinkUserID: linkUserID,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode oidc state: %w", err)
+	}
+
+	if err := us.redis.SetOIDCState(state, string(data), us.oidc.cfg.StateTTL); err != nil {
+		return "", "", err
+	}
+
+	return authURL, state, nil
+}
+
+// FinishOIDCLogin redeems the authorization response, signs the matching
+// local user in and creates the user on their first login. Users with
+// two-factor authentication still have to pass it.
+func (us *UserService) FinishOIDCLogin(req OIDCCallbackRequest, client ClientInfo) (*UserLoginResponse, error) {
+	if us.oidc == nil {
+		return nil, fmt.Errorf("oidc login not configured")
+	}
+
+	data, err := us.redis.TakeOIDCState(req.State)
+	if err != nil {
+		return nil, err
+	}
+	if data == "" {
+		return nil, fmt.Errorf("invalid or expired oidc state")
+	}
+
+	var state oidcLoginState
+	if err := json.Unmarshal([]byte(data), &state); err != nil {
+		return nil, fmt.Errorf("invalid or expired oidc state")
+	}
+
+	claims, err := us.oidc.Exchange(req.Code, state.Verifier, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *User
+	if state.LinkUserID != 0 {
+		user, err = us.linkSignedInUser(state.LinkUserID, claims, client)
+	} else {
+		user, err = us.userForIdentity(claims, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return us.loginOrChallenge(user, client)
+}
+
+// userForIdentity resolves the local user of a provider identity. Unknown
+// identities are linked to the account with the same verified email address,
+// or get a new account. Accounts with an unverified email address or with
+// two-factor authentication are not linked by email; their owner has to sign
+// in and link the provider.
+func (us *UserService) userForIdentity(claims *OIDCClaims, client ClientInfo) (*User, error) {
+	provider := us.oidc.Name()
+
+	identity, err := us.repo.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		if err := us.repo.TouchIdentity(identity.ID); err != nil {
+			us.logger.WithError(err).WithField("identity_id", identity.ID).Warn("Failed to update identity")
+		}
+		return us.repo.GetByID(identity.UserID)
+	}
+	if err.Error() != "identity not found" {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, fmt.Errorf("oidc provider did not return a verified email")
+	}
+
+	user, err := us.repo.GetByEmail(claims.Email)
+	if err != nil {
+		if err.Error() != "user not found" {
+			return nil, err
+		}
+
+		user, err = us.createOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Whoever registered an unverified address may not own it, and
+		// linking would leave them their password and sessions.
+		if !user.IsEmailVerified() {
+			us.logger.WithFields(logrus.Fields{
+				"user_id":  user.ID,
+				"provider": provider,
+			}).Warn("Refused to link oidc identity to account with unverified email")
+			return nil, fmt.Errorf("sign in to link this provider to your account")
+		}
+
+		enabled, err := us.twoFactorEnabled(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			us.logger.WithFields(logrus.Fields{
+				"user_id":  user.ID,
+				"provider": provider,
+			}).Warn("Refused to link oidc identity to account with two-factor authentication")
+			return nil, fmt.Errorf("sign in to link this provider to your account")
+		}
+	}
+
+	if err := us.linkIdentity(user, claims, client); err != nil {
+		return nil, err
+	}
+
+	if !user.IsEmailVerified() {
+		if err := us.repo.MarkEmailVerified(user.ID); err != nil {
+			us.logger.WithError(err).WithField("user_id", user.ID).Warn("Failed to mark email verified")
+		}
+		user, err = us.repo.GetByID(user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// linkSignedInUser links the provider identity to userID, who started the
+// login while signed in and so proved they own the account.
+func (us *UserService) linkSignedInUser(userID int, claims *OIDCClaims, client ClientInfo) (*User, error) {
+	identity, err := us.repo.GetIdentity(us.oidc.Name(), claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			return nil, fmt.Errorf("oidc identity is linked to another account")
+		}
+		return us.repo.GetByID(userID)
+	}
+	if err.Error() != "identity not found" {
+		return nil, err
+	}
+
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.linkIdentity(user, claims, client); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (us *UserService) linkIdentity(user *User, claims *OIDCClaims, client ClientInfo) error {
+	provider := us.oidc.Name()
+
+	identity := UserIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+	}
+	if claims.Email != "" {
+		email := claims.Email
+		identity.Email = &email
+	}
+
+	if _, err := us.repo.CreateIdentity(identity); err != nil {
+		return err
+	}
+
+	us.recordSecurityEvent(user.ID, EventIdentityLinked, client, provider)
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":  user.ID,
+		"provider": provider,
+	}).Info("Linked oidc identity")
+
+	return nil
+}
+
+// createOIDCUser creates the account for a first OIDC login. It has no usable
+// password; the user can set one through the password reset flow.
+func (us *UserService) createOIDCUser(claims *OIDCClaims) (*User, error) {
+	password, _, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	base := oidcUsername(claims)
+
+	for attempt := 1; attempt <= maxUsernameAttempts; attempt++ {
+		username := base
+		if attempt > 1 {
+			suffix := fmt.Sprintf("%d", attempt)
+			if len(username)+len(suffix) > 50 {
+				username = username[:50-len(suffix)]
+			}
+			username += suffix
+		}
+
+		exists, err := us.repo.UsernameExists(username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check username existence: %w", err)
+		}
+		if exists {
+			continue
+		}
+
+		user, err := us.repo.CreateUser(User{
+			Email:    claims.Email,
+			Username: username,
+			Password: string(passwordHash),
+		})
+		if err != nil {
+			// Another registration may have taken the name in the meantime;
+			// anything else, such as a taken email, is reported as is.
+			if taken, checkErr := us.repo.UsernameExists(username); checkErr == nil && taken {
+				continue
+			}
+			return nil, err
+		}
+
+		us.logger.WithFields(logrus.Fields{
+			"user_id":  user.ID,
+			"username": username,
+		}).Info("User created from oidc login")
+
+		return user, nil
+	}
+
+	return nil, fmt.Errorf("failed to find a free username for %q", base)
+}
+
+// oidcUsername derives a valid username from the ID token claims.
+func oidcUsername(claims *OIDCClaims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate = claims.Email
+	}
+
+	candidate = strings.SplitN(candidate, "@", 2)[0]
+	candidate = usernameInvalidChars.ReplaceAllString(candidate, "")
+	if len(candidate) > 50 {
+		candidate = candidate[:50]
+	}
+	for len(candidate) < 3 {
+		candidate += "_"
+	}
+
+	return candidate
+}
@@ -0,0 +1,269 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeQuery answers a query the fake database received. It returns the
+// columns and rows of the result.
+type fakeQuery func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error)
+
+// fakeConnector is a database/sql driver that hands every query to a
+// fakeQuery, for testing repository callers without a database.
+type fakeConnector struct {
+	query fakeQuery
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct {
+	query fakeQuery
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("prepare not supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("transactions not supported") }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.query(strings.Join(strings.Fields(query), " "), args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeUserRow returns the userColumns of a new active user.
+func fakeUserRow(id int, email, username string) []driver.Value {
+	now := time.Now()
+	return []driver.Value{
+		int64(id), email, username, "hash", now, now, nil, true, nil,
+		false, nil, nil, nil, nil, nil, nil, nil,
+	}
+}
+
+func newFakeUserService(query fakeQuery) *UserService {
+	logger := testLogger()
+	db := sql.OpenDB(fakeConnector{query: query})
+
+	return &UserService{
+		repo:   NewUserRepository(db, logger),
+		oidc:   newTestProvider("https://issuer.example.com"),
+		logger: logger,
+	}
+}
+
+func TestUserForIdentityRequiresVerifiedEmail(t *testing.T) {
+	us := newFakeUserService(func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "FROM user_identities") {
+			return []string{"id"}, nil, nil
+		}
+		return nil, nil, fmt.Errorf("unexpected query %q", query)
+	})
+
+	for _, claims := range []*OIDCClaims{
+		{Email: "alice@example.com"},
+		{EmailVerified: true},
+	} {
+		claims.Subject = "subject-1"
+
+		_, err := us.userForIdentity(claims, ClientInfo{})
+		if err == nil || err.Error() != "oidc provider did not return a verified email" {
+			t.Errorf("claims %+v: err = %v, want unverified email error", claims, err)
+		}
+	}
+}
+
+func TestUserForIdentityRefusesTwoFactorAccount(t *testing.T) {
+	us := newFakeUserService(func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "FROM user_identities"):
+			return []string{"id"}, nil, nil
+		case strings.Contains(query, "FROM users WHERE LOWER(email)"):
+			row := fakeUserRow(1, "alice@example.com", "alice")
+			row[8] = time.Now()
+			return strings.Split(userColumns, ","), [][]driver.Value{row}, nil
+		case strings.Contains(query, "FROM user_totp"):
+			now := time.Now()
+			return []string{"user_id", "secret", "enabled_at", "last_used_step", "created_at"},
+				[][]driver.Value{{int64(1), "secret", now, nil, now}}, nil
+		}
+		return nil, nil, fmt.Errorf("unexpected query %q", query)
+	})
+
+	claims := &OIDCClaims{
+		Email:            "alice@example.com",
+		EmailVerified:    true,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "subject-1"},
+	}
+
+	_, err := us.userForIdentity(claims, ClientInfo{})
+	if err == nil || err.Error() != "sign in to link this provider to your account" {
+		t.Fatalf("err = %v, want link refused", err)
+	}
+}
+
+func TestUserForIdentityRefusesUnverifiedAccount(t *testing.T) {
+	us := newFakeUserService(func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "FROM user_identities"):
+			return []string{"id"}, nil, nil
+		case strings.Contains(query, "FROM users WHERE LOWER(email)"):
+			return strings.Split(userColumns, ","), [][]driver.Value{fakeUserRow(1, "alice@example.com", "alice")}, nil
+		}
+		return nil, nil, fmt.Errorf("unexpected query %q", query)
+	})
+
+	claims := &OIDCClaims{
+		Email:            "alice@example.com",
+		EmailVerified:    true,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "subject-1"},
+	}
+
+	_, err := us.userForIdentity(claims, ClientInfo{})
+	if err == nil || err.Error() != "sign in to link this provider to your account" {
+		t.Fatalf("err = %v, want link refused", err)
+	}
+}
+
+func suffixedNames(base string, n int) []string {
+	names := make([]string, 0, n-1)
+	for i := 2; i <= n; i++ {
+		names = append(names, fmt.Sprintf("%s%d", base, i))
+	}
+	return names
+}
+
+func TestCreateOIDCUserUsernameCollision(t *testing.T) {
+	tests := []struct {
+		name       string
+		claims     OIDCClaims
+		taken      []string
+		raced      []string
+		emailTaken bool
+		wantName   string
+		wantError  string
+	}{
+		{
+			name:     "free preferred username",
+			claims:   OIDCClaims{PreferredUsername: "alice"},
+			wantName: "alice",
+		},
+		{
+			name:     "email local part",
+			claims:   OIDCClaims{Email: "Bob.Smith@example.com"},
+			wantName: "BobSmith",
+		},
+		{
+			name:     "taken username gets a suffix",
+			claims:   OIDCClaims{PreferredUsername: "alice"},
+			taken:    []string{"alice", "alice2"},
+			wantName: "alice3",
+		},
+		{
+			name:     "username taken while creating",
+			claims:   OIDCClaims{PreferredUsername: "alice"},
+			taken:    []string{"alice"},
+			raced:    []string{"alice2"},
+			wantName: "alice3",
+		},
+		{
+			name:     "suffix keeps the length limit",
+			claims:   OIDCClaims{PreferredUsername: strings.Repeat("a", 60)},
+			taken:    []string{strings.Repeat("a", 50)},
+			wantName: strings.Repeat("a", 49) + "2",
+		},
+		{
+			name:      "every candidate taken",
+			claims:    OIDCClaims{PreferredUsername: "alice"},
+			taken:     append([]string{"alice"}, suffixedNames("alice", maxUsernameAttempts)...),
+			wantError: "failed to find a free username",
+		},
+		{
+			name:       "email taken",
+			claims:     OIDCClaims{PreferredUsername: "alice", Email: "alice@example.com"},
+			emailTaken: true,
+			wantError:  "failed to create user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken := make(map[string]bool)
+			for _, name := range tt.taken {
+				taken[name] = true
+			}
+			raced := make(map[string]bool)
+			for _, name := range tt.raced {
+				raced[name] = true
+			}
+
+			us := newFakeUserService(func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.HasPrefix(query, "SELECT COUNT(*) FROM users WHERE username"):
+					count := int64(0)
+					if taken[args[0].Value.(string)] {
+						count = 1
+					}
+					return []string{"count"}, [][]driver.Value{{count}}, nil
+				case strings.HasPrefix(query, "INSERT INTO users"):
+					username := args[1].Value.(string)
+					if raced[username] {
+						taken[username] = true
+						return nil, nil, fmt.Errorf("duplicate key value violates unique constraint \"users_username_key\"")
+					}
+					if tt.emailTaken {
+						return nil, nil, fmt.Errorf("duplicate key value violates unique constraint \"users_email_key\"")
+					}
+					return strings.Split(userColumns, ","), [][]driver.Value{fakeUserRow(1, args[0].Value.(string), username)}, nil
+				}
+				return nil, nil, fmt.Errorf("unexpected query %q", query)
+			})
+
+			claims := tt.claims
+			claims.RegisteredClaims = jwt.RegisteredClaims{Subject: "subject-1"}
+
+			user, err := us.createOIDCUser(&claims)
+			if tt.wantError != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantError) {
+					t.Fatalf("err = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createOIDCUser: %v", err)
+			}
+			if user.Username != tt.wantName {
+				t.Errorf("username = %q, want %q", user.Username, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"onlineChat/pkg/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const testClientID = "online-chat"
+
+// mockIssuer is an OIDC provider serving discovery, keys and a token
+// endpoint that checks the PKCE verifier of the authorization request.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	issuer string
+
+	mu        sync.Mutex
+	challenge string
+	claims    OIDCClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	m := &mockIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                m.issuer,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{
+			"keys": {{
+				Kid: "test",
+				Kty: "RSA",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", m.handleToken)
+
+	m.server = httptest.NewServer(mux)
+	m.issuer = m.server.URL
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	challenge, claims := m.challenge, m.claims
+	m.mu.Unlock()
+
+	if r.PostForm.Get("code") != "code" || r.PostForm.Get("client_id") != testClientID {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	if pkceChallenge(r.PostForm.Get("code_verifier")) != challenge {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(oidcTokenResponse{
+		AccessToken: "access",
+		IDToken:     idToken,
+		TokenType:   "Bearer",
+	})
+}
+
+// authorize records the PKCE challenge of authURL and the claims the next
+// ID token is issued with, as the provider would when the user signs in.
+func (m *mockIssuer) authorize(t *testing.T, authURL string, claims OIDCClaims) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("invalid authorization url: %v", err)
+	}
+
+	claims.Issuer = m.issuer
+	claims.Audience = jwt.ClaimStrings{testClientID}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+	if claims.Nonce == "" {
+		claims.Nonce = parsed.Query().Get("nonce")
+	}
+
+	m.mu.Lock()
+	m.challenge = parsed.Query().Get("code_challenge")
+	m.claims = claims
+	m.mu.Unlock()
+}
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func newTestProvider(issuer string) *OIDCProvider {
+	return NewOIDCProvider(config.OIDCConfig{
+		ProviderName: "test",
+		Issuer:       issuer,
+		ClientID:     testClientID,
+		RedirectURL:  "http://localhost:3000/auth/oidc/callback",
+		Scopes:       "openid email profile",
+	}, testLogger())
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := newTestProvider(issuer.issuer)
+
+	authURL, err := provider.AuthCodeURL("state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	if !strings.HasPrefix(authURL, issuer.server.URL+"/authorize?") {
+		t.Fatalf("authorization url %q does not use the discovered endpoint", authURL)
+	}
+
+	parsed, _ := url.Parse(authURL)
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        pkceChallenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+	for param, value := range want {
+		if got := parsed.Query().Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.issuer = "https://other.example.com"
+
+	_, err := newTestProvider(issuer.server.URL).AuthCodeURL("state", "nonce", "verifier")
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to discover oidc provider") {
+		t.Fatalf("err = %v, want issuer mismatch", err)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	claims := OIDCClaims{
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "subject-1",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		claims   OIDCClaims
+		wantErr  string
+	}{
+		{
+			name:     "valid",
+			verifier: "verifier",
+			claims:   claims,
+		},
+		{
+			name:     "wrong pkce verifier",
+			verifier: "other-verifier",
+			claims:   claims,
+			wantErr:  "failed to exchange authorization code",
+		},
+		{
+			name:     "nonce mismatch",
+			verifier: "verifier",
+			nonce:    "other-nonce",
+			claims:   claims,
+			wantErr:  "invalid id token: nonce mismatch",
+		},
+		{
+			name:     "missing subject",
+			verifier: "verifier",
+			claims:   OIDCClaims{Email: "alice@example.com"},
+			wantErr:  "invalid id token: missing subject",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			provider := newTestProvider(issuer.issuer)
+
+			authURL, err := provider.AuthCodeURL("state", "nonce", "verifier")
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+			issuer.authorize(t, authURL, tt.claims)
+
+			nonce := "nonce"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			got, err := provider.Exchange("code", tt.verifier, nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if got.Subject != claims.Subject || got.Email != claims.Email || !got.EmailVerified {
+				t.Errorf("claims = %+v, want %+v", got, claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package users
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"onlineChat/pkg/mailer"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForgotPassword mails a password reset link to email if it belongs to an
+// account. It behaves the same whether or not the account exists, and sends
+// at most one mail per address per throttle interval.
+func (us *UserService) ForgotPassword(email string, client ClientInfo) {
+	address := normalizeEmail(email)
+
+	allowed, err := us.redis.Throttle("password_reset:"+address, us.cfg.MailThrottle)
+	if err != nil {
+		us.logger.WithError(err).Error("Failed to check password reset throttle")
+		return
+	}
+	if !allowed {
+		us.logger.WithField("email", address).Info("Password reset request throttled")
+		return
+	}
+
+	go us.sendPasswordReset(address, client)
+}
+
+func (us *UserService) sendPasswordReset(email string, client ClientInfo) {
+	user, err := us.repo.GetByEmail(email)
+	if err != nil {
+		us.logger.WithField("email", email).Debug("Password reset requested for unknown email")
+		return
+	}
+
+	token, tokenHash, err := generateOpaqueToken(32)
+	if err != nil {
+		us.logger.WithError(err).Error("Failed to generate password reset token")
+		return
+	}
+
+	if err := us.repo.CreatePasswordResetToken(user.ID, tokenHash, time.Now().Add(us.cfg.PasswordResetTTL), client.IP); err != nil {
+		return
+	}
+
+	msg, err := mailer.NewMessage(user.Email, "password_reset", map[string]interface{}{
+		"Username":  user.Username,
+		"Link":      us.appLink("/reset-password", token),
+		"ExpiresIn": humanDuration(us.cfg.PasswordResetTTL),
+	})
+	if err != nil {
+		us.logger.WithError(err).Error("Failed to render password reset mail")
+		return
+	}
+
+	if err := us.mailer.Send(msg); err != nil {
+		us.logger.WithError(err).WithField("user_id", user.ID).Error("Failed to send password reset mail")
+		return
+	}
+
+	us.logger.WithField("user_id", user.ID).Info("Password reset mail sent")
+}
+
+// ResetPassword sets a new password using a reset token. The token can only
+// be used once and every existing session of the user is revoked.
+func (us *UserService) ResetPassword(req ResetPasswordRequest, client ClientInfo) error {
+	tokenHash := hashToken(req.Token)
+
+	userID, err := us.repo.GetPasswordResetUserID(tokenHash)
+	if err != nil {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+
+	if err := validatePassword(req.NewPassword, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	if _, err := us.repo.ConsumePasswordResetToken(tokenHash); err != nil {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := us.repo.UpdatePassword(user.ID, string(passwordHash)); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	revoked := us.revokeOtherSessions(user.ID, "")
+	tokens := us.revokeOwnedPersonalTokens(user.ID)
+	us.guard.RecordSuccess(user.Email)
+
+	us.recordSecurityEvent(user.ID, EventPasswordReset, client,
+		fmt.Sprintf("%d sessions signed out, %d access tokens revoked", revoked, tokens))
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":   user.ID,
+		"client_ip": client.IP,
+	}).Info("Password reset successfully")
+
+	return nil
+}
+
+func (us *UserService) appLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimRight(us.cfg.AppURL, "/"), path, url.QueryEscape(token))
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		hours := int(d / time.Hour)
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	case d >= time.Minute:
+		minutes := int(d / time.Minute)
+		if minutes == 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", minutes)
+	default:
+		return d.String()
+	}
+}
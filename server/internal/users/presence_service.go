@@ -0,0 +1,63 @@
+package users
+
+import (
+	"fmt"
+
+	"onlineChat/pkg/redis"
+)
+
+// maxPresenceLookup caps the number of users in one presence lookup.
+const maxPresenceLookup = 100
+
+// GetPresence returns the presence of each active user in ids, in the order
+// given, as seen by viewerID. Users who hide their presence from the viewer
+// are reported offline without a last seen time.
+func (us *UserService) GetPresence(viewerID int, ids []int) ([]UserPresence, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("at least one user ID is required")
+	}
+	if len(ids) > maxPresenceLookup {
+		return nil, fmt.Errorf("at most %d user IDs can be looked up at once", maxPresenceLookup)
+	}
+
+	lastSeen, err := us.repo.GetLastSeen(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]int, 0, len(lastSeen))
+	for _, id := range ids {
+		if _, ok := lastSeen[id]; ok {
+			found = append(found, id)
+		}
+	}
+
+	statuses, err := us.redis.GetPresence(found)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence: %w", err)
+	}
+
+	visible, err := us.presenceVisibleTo(viewerID, found)
+	if err != nil {
+		return nil, err
+	}
+
+	presence := make([]UserPresence, 0, len(found))
+	for _, id := range found {
+		if !visible[id] {
+			presence = append(presence, UserPresence{
+				UserID: id,
+				Status: redis.PresenceOffline,
+			})
+			continue
+		}
+
+		presence = append(presence, UserPresence{
+			UserID:   id,
+			Status:   statuses[id],
+			LastSeen: lastSeen[id],
+		})
+	}
+
+	return presence, nil
+}
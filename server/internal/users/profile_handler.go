@@ -0,0 +1,101 @@
+package users
+
+import (
+	"net/http"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) UploadAvatar(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req AvatarUploadRequest
+	if err := c.ShouldBind(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid avatar upload request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Avatar file is required",
+		})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to open uploaded avatar")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to read avatar",
+		})
+		return
+	}
+	defer src.Close()
+
+	var crop *AvatarCrop
+	if req.CropSize != nil {
+		crop = &AvatarCrop{X: req.CropX, Y: req.CropY, Size: *req.CropSize}
+	}
+
+	user, err := h.service.UploadAvatar(userID, src, crop)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to upload avatar")
+		c.JSON(profileStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *Handler) DeleteAvatar(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	if err := h.service.DeleteAvatar(userID); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to delete avatar")
+		c.JSON(profileStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Avatar removed",
+	})
+}
+
+func profileStatusCode(err error) int {
+	switch {
+	case err.Error() == "username already exists":
+		return http.StatusConflict
+	case err.Error() == "user not found":
+		return http.StatusNotFound
+	case err.Error() == "image file is too large":
+		return http.StatusRequestEntityTooLarge
+	case strings.HasPrefix(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
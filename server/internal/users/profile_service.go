@@ -0,0 +1,132 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UploadAvatar crops and scales an uploaded image, stores it under
+// cfg.AvatarDir and makes it the avatar of userID.
+func (us *UserService) UploadAvatar(userID int, file io.Reader, crop *AvatarCrop) (*UserResponse, error) {
+	data, err := io.ReadAll(io.LimitReader(file, us.cfg.AvatarMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avatar: %w", err)
+	}
+	if int64(len(data)) > us.cfg.AvatarMaxBytes {
+		return nil, fmt.Errorf("image file is too large")
+	}
+
+	avatar, err := processAvatar(data, crop, us.cfg.AvatarSize)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := avatarFileName(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(us.cfg.AvatarDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create avatar directory: %w", err)
+	}
+
+	// Write under a temporary name first so a half-written file is never
+	// served.
+	path := filepath.Join(us.cfg.AvatarDir, name)
+	if err := os.WriteFile(path+".tmp", avatar, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to store avatar: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return nil, fmt.Errorf("failed to store avatar: %w", err)
+	}
+
+	avatarURL := strings.TrimSuffix(us.cfg.AvatarBaseURL, "/") + "/" + name
+	previous, err := us.repo.SetAvatarURL(userID, &avatarURL)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	us.removeAvatarFile(previous)
+
+	us.logger.WithField("user_id", userID).Info("Avatar uploaded")
+
+	return us.GetUserByID(userID)
+}
+
+func (us *UserService) DeleteAvatar(userID int) error {
+	previous, err := us.repo.SetAvatarURL(userID, nil)
+	if err != nil {
+		return err
+	}
+	us.removeAvatarFile(previous)
+
+	return nil
+}
+
+// removeAvatarFile deletes a replaced avatar if it was uploaded here. External
+// avatar URLs are left alone.
+func (us *UserService) removeAvatarFile(avatarURL *string) {
+	path, ok := us.localAvatarPath(avatarURL)
+	if !ok {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		us.logger.WithError(err).WithField("avatar_url", *avatarURL).Warn("Failed to remove old avatar")
+	}
+}
+
+// localAvatarPath returns the file behind an avatar URL if it was uploaded
+// here rather than linked from elsewhere.
+func (us *UserService) localAvatarPath(avatarURL *string) (string, bool) {
+	if avatarURL == nil {
+		return "", false
+	}
+
+	prefix := strings.TrimSuffix(us.cfg.AvatarBaseURL, "/") + "/"
+	if !strings.HasPrefix(*avatarURL, prefix) {
+		return "", false
+	}
+
+	name := filepath.Base(strings.TrimPrefix(*avatarURL, prefix))
+	return filepath.Join(us.cfg.AvatarDir, name), true
+}
+
+func avatarFileName(userID int) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate avatar name: %w", err)
+	}
+
+	return fmt.Sprintf("%d-%s.png", userID, hex.EncodeToString(b)), nil
+}
+
+func validateProfileUpdate(update *UserUpdate) error {
+	if update.AvatarURL != nil && *update.AvatarURL != "" {
+		parsed, err := url.Parse(*update.AvatarURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("avatar_url must be an http or https URL")
+		}
+	}
+
+	if update.Status != nil {
+		update.Status.Text = strings.TrimSpace(update.Status.Text)
+		update.Status.Emoji = strings.TrimSpace(update.Status.Emoji)
+
+		if update.Status.Text == "" && update.Status.Emoji == "" {
+			update.Status.ExpiresAt = nil
+		} else if update.Status.ExpiresAt != nil && !update.Status.ExpiresAt.After(time.Now()) {
+			return fmt.Errorf("status expiry must be in the future")
+		}
+	}
+
+	return nil
+}
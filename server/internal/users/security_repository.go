@@ -0,0 +1,167 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (r *UserRepository) IncrLoginFailures(key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (attempt_key, failed_count, first_failed_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+		failed_count = CASE WHEN login_attempts.first_failed_at < $3
+		                         AND COALESCE(login_attempts.locked_until < $3, true)
+		                    THEN 1 ELSE login_attempts.failed_count + 1 END,
+		first_failed_at = CASE WHEN login_attempts.first_failed_at < $3
+		                         AND COALESCE(login_attempts.locked_until < $3, true)
+		                       THEN $2 ELSE login_attempts.first_failed_at END
+		RETURNING failed_count
+	`
+
+	now := time.Now()
+
+	var count int
+	err := r.db.QueryRow(query, key, now, now.Add(-window)).Scan(&count)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to record login failure")
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *UserRepository) GetLoginFailures(key string, since time.Time) (int, error) {
+	query := `
+		SELECT failed_count
+		FROM login_attempts
+		WHERE attempt_key = $1 AND (first_failed_at >= $2 OR locked_until >= $2)
+	`
+
+	var count int
+	err := r.db.QueryRow(query, key, since).Scan(&count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		r.logger.WithError(err).Error("Failed to get login failures")
+		return 0, fmt.Errorf("failed to get login failures: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *UserRepository) SetLoginLock(key string, until time.Time) error {
+	query := `
+		INSERT INTO login_attempts (attempt_key, failed_count, first_failed_at, locked_until)
+		VALUES ($1, 0, $2, $3)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+		locked_until = EXCLUDED.locked_until
+	`
+
+	_, err := r.db.Exec(query, key, time.Now(), until)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to set login lock")
+		return fmt.Errorf("failed to set login lock: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) GetLoginLock(key string) (*time.Time, error) {
+	query := `
+		SELECT locked_until
+		FROM login_attempts
+		WHERE attempt_key = $1 AND locked_until > $2
+	`
+
+	var until time.Time
+	err := r.db.QueryRow(query, key, time.Now()).Scan(&until)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		r.logger.WithError(err).Error("Failed to get login lock")
+		return nil, fmt.Errorf("failed to get login lock: %w", err)
+	}
+
+	return &until, nil
+}
+
+func (r *UserRepository) ClearLoginFailures(key string) error {
+	query := `DELETE FROM login_attempts WHERE attempt_key = $1`
+
+	_, err := r.db.Exec(query, key)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to clear login failures")
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) RecordSecurityEvent(event SecurityEvent) error {
+	query := `
+		INSERT INTO security_events (user_id, event_type, ip_address, user_agent, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := r.db.Exec(query,
+		event.UserID, event.EventType, event.IPAddress, event.UserAgent,
+		event.Details, time.Now(),
+	)
+	if err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    event.UserID,
+			"event_type": event.EventType,
+		}).Error("Failed to record security event")
+		return fmt.Errorf("failed to record security event: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) GetSecurityEvents(userID int, limit, offset int) ([]SecurityEvent, int, error) {
+	countQuery := `SELECT COUNT(*) FROM security_events WHERE user_id = $1`
+
+	var total int
+	err := r.db.QueryRow(countQuery, userID).Scan(&total)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to count security events")
+		return nil, 0, fmt.Errorf("failed to count security events: %w", err)
+	}
+
+	query := `
+		SELECT id, user_id, event_type, ip_address, user_agent, details, created_at
+		FROM security_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(query, userID, limit, offset)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get security events")
+		return nil, 0, fmt.Errorf("failed to get security events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []SecurityEvent
+	for rows.Next() {
+		var event SecurityEvent
+		err := rows.Scan(
+			&event.ID, &event.UserID, &event.EventType, &event.IPAddress,
+			&event.UserAgent, &event.Details, &event.CreatedAt,
+		)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan security event")
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, total, nil
+}
@@ -0,0 +1,164 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (r *UserRepository) CreateSession(session Session) (*Session, error) {
+	query := `
+		INSERT INTO sessions (id, user_id, device, user_agent, ip_address,
+		                      created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at, last_used_at
+	`
+
+	now := time.Now()
+	row := r.db.QueryRow(query,
+		session.ID, session.UserID, session.Device, session.UserAgent,
+		session.IPAddress, now, now, session.ExpiresAt,
+	)
+
+	err := row.Scan(&session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", session.UserID).Error("Failed to create session")
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (r *UserRepository) GetSession(id string) (*Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip_address, created_at,
+		       last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE id = $1
+	`
+
+	session := &Session{}
+	row := r.db.QueryRow(query, id)
+
+	err := row.Scan(
+		&session.ID, &session.UserID, &session.Device, &session.UserAgent,
+		&session.IPAddress, &session.CreatedAt, &session.LastUsedAt,
+		&session.ExpiresAt, &session.RevokedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("session not found")
+		}
+		r.logger.WithError(err).WithField("session_id", id).Error("Failed to get session")
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
+
+func (r *UserRepository) GetUserSessions(userID int) ([]Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip_address, created_at,
+		       last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID, time.Now())
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get user sessions")
+		return nil, fmt.Errorf("failed to get user sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID, &session.UserID, &session.Device, &session.UserAgent,
+			&session.IPAddress, &session.CreatedAt, &session.LastUsedAt,
+			&session.ExpiresAt, &session.RevokedAt,
+		)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan session")
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (r *UserRepository) TouchSession(id string, expiresAt time.Time) error {
+	query := `UPDATE sessions SET last_used_at = $1, expires_at = $2 WHERE id = $3`
+
+	_, err := r.db.Exec(query, time.Now(), expiresAt, id)
+	if err != nil {
+		r.logger.WithError(err).WithField("session_id", id).Error("Failed to touch session")
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) RevokeSession(id string, userID int) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), id, userID)
+	if err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"session_id": id,
+		}).Error("Failed to revoke session")
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions revokes every active session of userID except
+// keepSessionID and returns the IDs of the revoked sessions.
+func (r *UserRepository) RevokeOtherSessions(userID int, keepSessionID string) ([]string, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL
+		RETURNING id
+	`
+
+	rows, err := r.db.Query(query, time.Now(), userID, keepSessionID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke sessions")
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			r.logger.WithError(err).Error("Failed to scan session ID")
+			continue
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	return sessionIDs, nil
+}
@@ -0,0 +1,131 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (us *UserService) createSession(user *User, client ClientInfo) (*Session, error) {
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	session := Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(us.cfg.RefreshTokenTTL),
+	}
+
+	if client.UserAgent != "" {
+		device := deviceFromUserAgent(client.UserAgent)
+		session.UserAgent = &client.UserAgent
+		session.Device = &device
+	}
+	if client.IP != "" {
+		session.IPAddress = &client.IP
+	}
+
+	created, err := us.repo.CreateSession(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return created, nil
+}
+
+func (us *UserService) GetSessions(userID int, currentSessionID string) ([]SessionResponse, error) {
+	sessions, err := us.repo.GetUserSessions(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	responses := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, session.ToResponse(currentSessionID))
+	}
+
+	return responses, nil
+}
+
+func (us *UserService) Logout(userID int, sessionID string) error {
+	if sessionID == "" {
+		return fmt.Errorf("session not found")
+	}
+
+	return us.RevokeSession(userID, sessionID)
+}
+
+func (us *UserService) RevokeSession(userID int, sessionID string) error {
+	if err := us.repo.RevokeSession(sessionID, userID); err != nil {
+		return err
+	}
+
+	us.terminateSession(userID, sessionID)
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"session_id": sessionID,
+	}).Info("Session revoked")
+
+	return nil
+}
+
+// terminateSession invalidates everything issued for an already revoked
+// session: its refresh tokens, its access tokens via the Redis denylist and
+// its open WebSocket connections.
+func (us *UserService) terminateSession(userID int, sessionID string) {
+	fields := logrus.Fields{
+		"user_id":    userID,
+		"session_id": sessionID,
+	}
+
+	if err := us.repo.RevokeRefreshTokenFamily(sessionID); err != nil {
+		us.logger.WithError(err).WithFields(fields).Error("Failed to revoke session refresh tokens")
+	}
+
+	if err := us.redis.RevokeSession(sessionID, us.cfg.AccessTokenTTL); err != nil {
+		us.logger.WithError(err).WithFields(fields).Error("Failed to add session to denylist")
+	}
+}
+
+// deviceFromUserAgent gives a short, human readable description of the
+// browser and platform in a User-Agent header.
+func deviceFromUserAgent(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	platform := "unknown platform"
+	switch {
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	return browser + " on " + platform
+}
@@ -0,0 +1,60 @@
+package users
+
+import (
+	"net/http"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) GetSettings(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	settings, err := h.service.GetSettings(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get settings")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get settings",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+func (h *Handler) UpdateSettings(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req UserSettingsUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	settings, err := h.service.UpdateSettings(userID, req)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to update settings")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update settings",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
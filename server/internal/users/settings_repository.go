@@ -0,0 +1,111 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetSettings returns the privacy settings of userID, or the defaults if the
+// user never changed them.
+func (r *UserRepository) GetSettings(userID int) (*UserSettings, error) {
+	query := `
+		SELECT direct_messages, presence_visibility, searchable, updated_at
+		FROM user_settings
+		WHERE user_id = $1
+	`
+
+	settings := &UserSettings{}
+	err := r.db.QueryRow(query, userID).Scan(
+		&settings.DirectMessages,
+		&settings.PresenceVisibility,
+		&settings.Searchable,
+		&settings.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		defaults := DefaultUserSettings()
+		return &defaults, nil
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get user settings")
+		return nil, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+func (r *UserRepository) SaveSettings(userID int, settings UserSettings) (*UserSettings, error) {
+	query := `
+		INSERT INTO user_settings (user_id, direct_messages, presence_visibility, searchable, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE
+		SET direct_messages = EXCLUDED.direct_messages,
+		    presence_visibility = EXCLUDED.presence_visibility,
+		    searchable = EXCLUDED.searchable,
+		    updated_at = EXCLUDED.updated_at
+	`
+
+	now := time.Now()
+	_, err := r.db.Exec(query, userID, settings.DirectMessages, settings.PresenceVisibility, settings.Searchable, now)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to save user settings")
+		return nil, fmt.Errorf("failed to save settings: %w", err)
+	}
+
+	settings.UpdatedAt = &now
+	return &settings, nil
+}
+
+// GetPresenceVisibility returns the presence visibility of each of ids that
+// changed it from the default. Users left out are visible to everyone.
+func (r *UserRepository) GetPresenceVisibility(ids []int) (map[int]string, error) {
+	query := `
+		SELECT user_id, presence_visibility
+		FROM user_settings
+		WHERE user_id = ANY($1) AND presence_visibility <> 'everyone'
+	`
+
+	rows, err := r.db.Query(query, ids)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to get presence visibility")
+		return nil, fmt.Errorf("failed to get presence visibility: %w", err)
+	}
+	defer rows.Close()
+
+	visibility := make(map[int]string)
+	for rows.Next() {
+		var userID int
+		var value string
+		if err := rows.Scan(&userID, &value); err != nil {
+			r.logger.WithError(err).Error("Failed to scan presence visibility")
+			continue
+		}
+		visibility[userID] = value
+	}
+
+	return visibility, nil
+}
+
+// GetContactsOf returns which of ids have contactID in their contacts.
+func (r *UserRepository) GetContactsOf(contactID int, ids []int) (map[int]bool, error) {
+	query := `SELECT user_id FROM contacts WHERE contact_id = $1 AND user_id = ANY($2)`
+
+	rows, err := r.db.Query(query, contactID, ids)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", contactID).Error("Failed to get contacts")
+		return nil, fmt.Errorf("failed to get contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := make(map[int]bool)
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			r.logger.WithError(err).Error("Failed to scan contact")
+			continue
+		}
+		contacts[userID] = true
+	}
+
+	return contacts, nil
+}
@@ -0,0 +1,91 @@
+package users
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+func (us *UserService) GetSettings(userID int) (*UserSettings, error) {
+	return us.repo.GetSettings(userID)
+}
+
+// UpdateSettings changes the fields set in update and keeps the rest.
+func (us *UserService) UpdateSettings(userID int, update UserSettingsUpdate) (*UserSettings, error) {
+	settings, err := us.repo.GetSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.DirectMessages != nil {
+		settings.DirectMessages = *update.DirectMessages
+	}
+	if update.PresenceVisibility != nil {
+		settings.PresenceVisibility = *update.PresenceVisibility
+	}
+	if update.Searchable != nil {
+		settings.Searchable = *update.Searchable
+	}
+
+	saved, err := us.repo.SaveSettings(userID, *settings)
+	if err != nil {
+		return nil, err
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":             userID,
+		"direct_messages":     saved.DirectMessages,
+		"presence_visibility": saved.PresenceVisibility,
+		"searchable":          saved.Searchable,
+	}).Info("Privacy settings updated")
+
+	return saved, nil
+}
+
+// presenceVisibleTo returns which of ids let viewerID see their presence and
+// last seen time. Users can always see their own.
+func (us *UserService) presenceVisibleTo(viewerID int, ids []int) (map[int]bool, error) {
+	visibility, err := us.repo.GetPresenceVisibility(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var contactsOnly []int
+	for userID, value := range visibility {
+		if value == PrivacyContacts && userID != viewerID {
+			contactsOnly = append(contactsOnly, userID)
+		}
+	}
+
+	contactOf := map[int]bool{}
+	if len(contactsOnly) > 0 {
+		if contactOf, err = us.repo.GetContactsOf(viewerID, contactsOnly); err != nil {
+			return nil, err
+		}
+	}
+
+	visible := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		switch visibility[id] {
+		case PrivacyContacts:
+			visible[id] = id == viewerID || contactOf[id]
+		case PrivacyNobody:
+			visible[id] = id == viewerID
+		default:
+			visible[id] = true
+		}
+	}
+
+	return visible, nil
+}
+
+// hidePresence clears the last seen time of user unless viewerID may see it.
+func (us *UserService) hidePresence(viewerID int, user *UserResponse) error {
+	visible, err := us.presenceVisibleTo(viewerID, []int{user.ID})
+	if err != nil {
+		return err
+	}
+	if !visible[user.ID] {
+		user.LastSeen = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (r *UserRepository) CreateRefreshToken(token RefreshToken) (*RefreshToken, error) {
+	query := `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	row := r.db.QueryRow(query, token.UserID, token.TokenHash, token.FamilyID, token.ExpiresAt, time.Now())
+
+	err := row.Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", token.UserID).Error("Failed to create refresh token")
+		return nil, fmt.Errorf("failed to create refresh token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func (r *UserRepository) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	query := `
+		SELECT id, user_id, token_hash, family_id, expires_at, created_at,
+		       rotated_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	token := &RefreshToken{}
+	row := r.db.QueryRow(query, tokenHash)
+
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.TokenHash, &token.FamilyID,
+		&token.ExpiresAt, &token.CreatedAt, &token.RotatedAt, &token.RevokedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("refresh token not found")
+		}
+		r.logger.WithError(err).Error("Failed to get refresh token")
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// MarkRefreshTokenRotated flags a token as used. It returns false if the
+// token had already been rotated or revoked, which means it is being reused.
+func (r *UserRepository) MarkRefreshTokenRotated(id int) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET rotated_at = $1
+		WHERE id = $2 AND rotated_at IS NULL AND revoked_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), id)
+	if err != nil {
+		r.logger.WithError(err).WithField("token_id", id).Error("Failed to rotate refresh token")
+		return false, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (r *UserRepository) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = $1
+		WHERE family_id = $2 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, time.Now(), familyID)
+	if err != nil {
+		r.logger.WithError(err).WithField("family_id", familyID).Error("Failed to revoke refresh token family")
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) RevokeUserRefreshTokens(userID int) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, time.Now(), userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke user refresh tokens")
+		return fmt.Errorf("failed to revoke user refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) CreatePasswordResetToken(userID int, tokenHash string, expiresAt time.Time, ipAddress string) error {
+	query := `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, ip_address, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := r.db.Exec(query, userID, tokenHash, expiresAt, ipAddress, time.Now())
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to create password reset token")
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumePasswordResetToken marks an unused, unexpired reset token as used
+// and returns the user it belongs to. Every other outstanding reset token of
+// that user is invalidated as well.
+func (r *UserRepository) ConsumePasswordResetToken(tokenHash string) (int, error) {
+	query := `
+		UPDATE password_reset_tokens
+		SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`
+
+	now := time.Now()
+
+	var userID int
+	err := r.db.QueryRow(query, now, tokenHash).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("reset token not found")
+		}
+		r.logger.WithError(err).Error("Failed to consume password reset token")
+		return 0, fmt.Errorf("failed to consume password reset token: %w", err)
+	}
+
+	invalidateQuery := `
+		UPDATE password_reset_tokens
+		SET used_at = $1
+		WHERE user_id = $2 AND used_at IS NULL
+	`
+
+	if _, err := r.db.Exec(invalidateQuery, now, userID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Warn("Failed to invalidate other reset tokens")
+	}
+
+	return userID, nil
+}
+
+func (r *UserRepository) GetPasswordResetUserID(tokenHash string) (int, error) {
+	query := `
+		SELECT user_id
+		FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+	`
+
+	var userID int
+	err := r.db.QueryRow(query, tokenHash, time.Now()).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("reset token not found")
+		}
+		r.logger.WithError(err).Error("Failed to get password reset token")
+		return 0, fmt.Errorf("failed to get password reset token: %w", err)
+	}
+
+	return userID, nil
+}
+
+func (r *UserRepository) CreateEmailVerificationToken(userID int, tokenHash string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO email_verification_tokens (user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := r.db.Exec(query, userID, tokenHash, expiresAt, time.Now())
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to create email verification token")
+		return fmt.Errorf("failed to create email verification token: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeEmailVerificationToken marks an unused, unexpired verification token
+// as used and returns the user it belongs to.
+func (r *UserRepository) ConsumeEmailVerificationToken(tokenHash string) (int, error) {
+	query := `
+		UPDATE email_verification_tokens
+		SET used_at = $1
+		WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`
+
+	var userID int
+	err := r.db.QueryRow(query, time.Now(), tokenHash).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("verification token not found")
+		}
+		r.logger.WithError(err).Error("Failed to consume email verification token")
+		return 0, fmt.Errorf("failed to consume email verification token: %w", err)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,150 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// generateOpaqueToken returns a random URL-safe token together with the
+// SHA-256 hash that is stored in its place.
+func generateOpaqueToken(size int) (string, string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// issueTokens creates an access token and a refresh token for user within
+// session. The session ID doubles as the refresh token family.
+func (us *UserService) issueTokens(user *User, sessionID string) (*UserLoginResponse, error) {
+	accessToken, err := us.generateToken(user, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	refreshToken, refreshHash, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	stored, err := us.repo.CreateRefreshToken(RefreshToken{
+		UserID:    user.ID,
+		TokenHash: refreshHash,
+		FamilyID:  sessionID,
+		ExpiresAt: now.Add(us.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	expiresAt := now.Add(us.cfg.AccessTokenTTL)
+
+	return &UserLoginResponse{
+		AccessToken:          accessToken,
+		RefreshToken:         refreshToken,
+		User:                 user.ToResponse(),
+		ExpiresAt:            &expiresAt,
+		RefreshExpiresAt:     &stored.ExpiresAt,
+		VerificationRequired: !user.IsEmailVerified(),
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. Presenting a
+// token that was already rotated revokes every token in its family, since
+// either the client or an attacker is holding a stolen copy.
+func (us *UserService) RefreshTokens(refreshToken string, client ClientInfo) (*UserLoginResponse, error) {
+	stored, err := us.repo.GetRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	if stored.RotatedAt != nil {
+		us.revokeReusedFamily(stored, client)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	session, err := us.repo.GetSession(stored.FamilyID)
+	if err != nil || session.RevokedAt != nil {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	rotated, err := us.repo.MarkRefreshTokenRotated(stored.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		us.revokeReusedFamily(stored, client)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	user, err := us.repo.GetByID(stored.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	response, err := us.issueTokens(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.repo.TouchSession(session.ID, *response.RefreshExpiresAt); err != nil {
+		us.logger.WithError(err).WithField("session_id", session.ID).Warn("Failed to update session")
+	}
+
+	us.logger.WithField("user_id", user.ID).Debug("Refresh token rotated")
+
+	return response, nil
+}
+
+// RefreshTokenSession returns the session a refresh token belongs to.
+func (us *UserService) RefreshTokenSession(refreshToken string) (string, error) {
+	stored, err := us.repo.GetRefreshToken(hashToken(refreshToken))
+	if err != nil {
+		return "", fmt.Errorf("invalid refresh token")
+	}
+
+	return stored.FamilyID, nil
+}
+
+func (us *UserService) revokeReusedFamily(token *RefreshToken, client ClientInfo) {
+	us.logger.WithFields(logrus.Fields{
+		"user_id":   token.UserID,
+		"token_id":  token.ID,
+		"client_ip": client.IP,
+	}).Warn("Refresh token reuse detected, revoking token family")
+
+	if err := us.repo.RevokeSession(token.FamilyID, token.UserID); err != nil {
+		us.logger.WithError(err).WithField("user_id", token.UserID).Warn("Failed to revoke session of reused token")
+	}
+	us.terminateSession(token.UserID, token.FamilyID)
+
+	us.recordSecurityEvent(token.UserID, EventRefreshReuse, client, "")
+}
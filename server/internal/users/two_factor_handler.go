@@ -0,0 +1,190 @@
+package users
+
+import (
+	"net/http"
+	"strings"
+
+	"onlineChat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLogin
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid two-factor login request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	response, err := h.service.LoginTwoFactor(req, clientInfo(c))
+	if err != nil {
+		h.logger.WithError(err).Warn("Failed two-factor login attempt")
+
+		if strings.HasPrefix(err.Error(), "failed to") {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to complete login",
+			})
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) GetTwoFactorStatus(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	status, err := h.service.GetTwoFactorStatus(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get two-factor status")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get two-factor status",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *Handler) EnrollTwoFactor(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	enrollment, err := h.service.EnrollTwoFactor(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to start two-factor enrollment")
+		c.JSON(twoFactorStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *Handler) ConfirmTwoFactor(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req TwoFactorCode
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid two-factor confirm request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	codes, err := h.service.ConfirmTwoFactor(userID, req.Code, clientInfo(c))
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to confirm two-factor enrollment")
+		c.JSON(twoFactorStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req DisableTwoFactor
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid two-factor disable request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.DisableTwoFactor(userID, req, clientInfo(c)); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to disable two-factor authentication")
+		c.JSON(twoFactorStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req TwoFactorCode
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid recovery codes request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to regenerate recovery codes")
+		c.JSON(twoFactorStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+func twoFactorStatusCode(err error) int {
+	switch {
+	case err.Error() == "current password is incorrect" || err.Error() == "invalid two-factor code":
+		return http.StatusForbidden
+	case err.Error() == "two-factor authentication already enabled":
+		return http.StatusConflict
+	case strings.HasPrefix(err.Error(), "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,206 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (r *UserRepository) GetTwoFactor(userID int) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_used_step, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	tf := &TwoFactor{}
+	err := r.db.QueryRow(query, userID).Scan(
+		&tf.UserID, &tf.Secret, &tf.EnabledAt, &tf.LastUsedStep, &tf.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("two-factor authentication not configured")
+		}
+		r.logger.WithError(err).Error("Failed to get two-factor settings")
+		return nil, fmt.Errorf("failed to get two-factor settings: %w", err)
+	}
+
+	return tf, nil
+}
+
+// SaveTwoFactorSecret stores a new pending secret for userID. It refuses to
+// replace the secret of an enabled configuration.
+func (r *UserRepository) SaveTwoFactorSecret(userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = EXCLUDED.created_at
+		WHERE user_totp.enabled_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, userID, secret, time.Now())
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to save two-factor secret")
+		return fmt.Errorf("failed to save two-factor secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("two-factor authentication already enabled")
+	}
+
+	return nil
+}
+
+// UseTwoFactorStep records that the code of step was accepted. It returns
+// false if that step or a later one was used already, so a code cannot be
+// replayed within its validity window.
+func (r *UserRepository) UseTwoFactorStep(userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = $1
+		WHERE user_id = $2 AND (last_used_step IS NULL OR last_used_step < $1)
+	`
+
+	result, err := r.db.Exec(query, step, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to record two-factor step")
+		return false, fmt.Errorf("failed to record two-factor step: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// EnableTwoFactor turns on two-factor authentication and replaces the user's
+// recovery codes with codeHashes.
+func (r *UserRepository) EnableTwoFactor(userID int, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	if _, err := tx.Exec(`UPDATE user_totp SET enabled_at = $1 WHERE user_id = $2`, now, userID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to enable two-factor authentication")
+		return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes, now); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to store recovery codes")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes, time.Now()); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to store recovery codes")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string, now time.Time) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err := tx.Exec(
+			`INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`,
+			userID, codeHash, now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert recovery code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *UserRepository) DisableTwoFactor(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to delete recovery codes")
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to disable two-factor authentication")
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether
+// one matched.
+func (r *UserRepository) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = $1
+		WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), userID, codeHash)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to use recovery code")
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (r *UserRepository) CountRecoveryCodes(userID int) (int, error) {
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	if err := r.db.QueryRow(query, userID).Scan(&count); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to count recovery codes")
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,325 @@
+package users
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxChallengeAttempts is the number of wrong codes after which a login
+// challenge is thrown away and the user has to enter their password again.
+const maxChallengeAttempts = 5
+
+func (us *UserService) GetTwoFactorStatus(userID int) (*TwoFactorStatus, error) {
+	twoFactor, err := us.repo.GetTwoFactor(userID)
+	if err != nil {
+		if err.Error() == "two-factor authentication not configured" {
+			return &TwoFactorStatus{}, nil
+		}
+		return nil, err
+	}
+
+	if twoFactor.EnabledAt == nil {
+		return &TwoFactorStatus{}, nil
+	}
+
+	remaining, err := us.repo.CountRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorStatus{
+		Enabled:                true,
+		EnabledAt:              twoFactor.EnabledAt,
+		RecoveryCodesRemaining: remaining,
+	}, nil
+}
+
+// EnrollTwoFactor creates a new TOTP secret for userID. Two-factor
+// authentication stays off until the secret is confirmed with a valid code.
+func (us *UserService) EnrollTwoFactor(userID int) (*TwoFactorEnrollment, error) {
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.repo.SaveTwoFactorSecret(userID, secret); err != nil {
+		return nil, err
+	}
+
+	us.logger.WithField("user_id", userID).Info("Two-factor enrollment started")
+
+	return &TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totpURI(us.cfg.TwoFactorIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user proves
+// their authenticator works, and returns the recovery codes. They are only
+// ever shown this once.
+func (us *UserService) ConfirmTwoFactor(userID int, code string, client ClientInfo) (*RecoveryCodesResponse, error) {
+	twoFactor, err := us.repo.GetTwoFactor(userID)
+	if err != nil {
+		if err.Error() == "two-factor authentication not configured" {
+			return nil, fmt.Errorf("two-factor enrollment not started")
+		}
+		return nil, err
+	}
+
+	if twoFactor.EnabledAt != nil {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+
+	if !us.checkTOTP(twoFactor, code) {
+		return nil, fmt.Errorf("invalid two-factor code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.repo.EnableTwoFactor(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	us.recordSecurityEvent(userID, EventTwoFactorOn, client, "")
+
+	us.logger.WithField("user_id", userID).Info("Two-factor authentication enabled")
+
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (us *UserService) DisableTwoFactor(userID int, req DisableTwoFactor, client ClientInfo) error {
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		return fmt.Errorf("current password is incorrect")
+	}
+
+	twoFactor, err := us.enabledTwoFactor(userID)
+	if err != nil {
+		return err
+	}
+
+	if !us.checkSecondFactor(twoFactor, req.Code, client) {
+		return fmt.Errorf("invalid two-factor code")
+	}
+
+	if err := us.repo.DisableTwoFactor(userID); err != nil {
+		return err
+	}
+
+	us.recordSecurityEvent(userID, EventTwoFactorOff, client, "")
+
+	us.logger.WithField("user_id", userID).Info("Two-factor authentication disabled")
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of userID after
+// checking a current TOTP code.
+func (us *UserService) RegenerateRecoveryCodes(userID int, code string) (*RecoveryCodesResponse, error) {
+	twoFactor, err := us.enabledTwoFactor(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !us.checkTOTP(twoFactor, code) {
+		return nil, fmt.Errorf("invalid two-factor code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	us.logger.WithField("user_id", userID).Info("Recovery codes regenerated")
+
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// twoFactorEnabled reports whether userID confirmed two-factor
+// authentication.
+func (us *UserService) twoFactorEnabled(userID int) (bool, error) {
+	twoFactor, err := us.repo.GetTwoFactor(userID)
+	if err != nil {
+		if err.Error() == "two-factor authentication not configured" {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return twoFactor.EnabledAt != nil, nil
+}
+
+// loginOrChallenge finishes a login whose first factor passed. Users with
+// two-factor authentication get a login challenge instead of a session,
+// whichever way they signed in.
+func (us *UserService) loginOrChallenge(user *User, client ClientInfo) (*UserLoginResponse, error) {
+	enabled, err := us.twoFactorEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return us.createLoginChallenge(user)
+	}
+
+	return us.completeLogin(user, client)
+}
+
+// createLoginChallenge finishes the first step of a login for a user with
+// two-factor authentication. The returned challenge token stands in for the
+// password or OIDC login in LoginTwoFactor.
+func (us *UserService) createLoginChallenge(user *User) (*UserLoginResponse, error) {
+	challenge, challengeHash, err := generateOpaqueToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.redis.SetLoginChallenge(challengeHash, user.ID, us.cfg.TwoFactorChallengeTTL); err != nil {
+		return nil, fmt.Errorf("failed to create login challenge: %w", err)
+	}
+
+	expiresAt := time.Now().Add(us.cfg.TwoFactorChallengeTTL)
+
+	us.logger.WithField("user_id", user.ID).Info("First factor accepted, awaiting two-factor code")
+
+	return &UserLoginResponse{
+		User:               user.ToResponse(),
+		TwoFactorRequired:  true,
+		ChallengeToken:     challenge,
+		ChallengeExpiresAt: &expiresAt,
+	}, nil
+}
+
+// LoginTwoFactor exchanges a login challenge and a TOTP or recovery code for
+// a session.
+func (us *UserService) LoginTwoFactor(req TwoFactorLogin, client ClientInfo) (*UserLoginResponse, error) {
+	challengeHash := hashToken(req.ChallengeToken)
+
+	userID, err := us.redis.GetLoginChallenge(challengeHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check login challenge: %w", err)
+	}
+	if userID == 0 {
+		return nil, fmt.Errorf("invalid or expired challenge")
+	}
+
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired challenge")
+	}
+
+	if until := us.guard.LockedUntil(user.Email, client.IP); until != nil {
+		return nil, fmt.Errorf("invalid two-factor code")
+	}
+
+	twoFactor, err := us.enabledTwoFactor(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired challenge")
+	}
+
+	if !us.checkSecondFactor(twoFactor, req.Code, client) {
+		us.recordChallengeFailure(user, challengeHash, client)
+		return nil, fmt.Errorf("invalid two-factor code")
+	}
+
+	redeemed, err := us.redis.DeleteLoginChallenge(challengeHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem login challenge: %w", err)
+	}
+	if !redeemed {
+		return nil, fmt.Errorf("invalid or expired challenge")
+	}
+
+	return us.completeLogin(user, client)
+}
+
+func (us *UserService) recordChallengeFailure(user *User, challengeHash string, client ClientInfo) {
+	if until := us.guard.RecordFailure(user.Email, client.IP); until != nil {
+		us.recordSecurityEvent(user.ID, EventAccountLocked, client,
+			fmt.Sprintf("locked until %s", until.Format(time.RFC3339)))
+	}
+	us.recordSecurityEvent(user.ID, EventTwoFactorFail, client, "")
+
+	failures, err := us.redis.IncrLoginChallengeFailures(challengeHash, us.cfg.TwoFactorChallengeTTL)
+	if err != nil {
+		us.logger.WithError(err).WithField("user_id", user.ID).Warn("Failed to count login challenge failure")
+		return
+	}
+
+	if failures >= maxChallengeAttempts {
+		if _, err := us.redis.DeleteLoginChallenge(challengeHash); err != nil {
+			us.logger.WithError(err).WithField("user_id", user.ID).Warn("Failed to delete login challenge")
+		}
+	}
+
+	us.logger.WithFields(logrus.Fields{
+		"user_id":   user.ID,
+		"client_ip": client.IP,
+		"failures":  failures,
+	}).Warn("Invalid two-factor code")
+}
+
+func (us *UserService) enabledTwoFactor(userID int) (*TwoFactor, error) {
+	twoFactor, err := us.repo.GetTwoFactor(userID)
+	if err != nil {
+		if err.Error() == "two-factor authentication not configured" {
+			return nil, fmt.Errorf("two-factor authentication not enabled")
+		}
+		return nil, err
+	}
+
+	if twoFactor.EnabledAt == nil {
+		return nil, fmt.Errorf("two-factor authentication not enabled")
+	}
+
+	return twoFactor, nil
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+func (us *UserService) checkSecondFactor(twoFactor *TwoFactor, code string, client ClientInfo) bool {
+	if len(code) == totpDigits {
+		return us.checkTOTP(twoFactor, code)
+	}
+
+	used, err := us.repo.UseRecoveryCode(twoFactor.UserID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil || !used {
+		return false
+	}
+
+	us.recordSecurityEvent(twoFactor.UserID, EventRecoveryUsed, client, "")
+
+	return true
+}
+
+// checkTOTP validates code and makes sure its time step was not used before.
+func (us *UserService) checkTOTP(twoFactor *TwoFactor, code string) bool {
+	step, ok := validateTOTP(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	fresh, err := us.repo.UseTwoFactorStep(twoFactor.UserID, step)
+	if err != nil {
+		return false
+	}
+
+	return fresh
+}
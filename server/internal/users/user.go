@@ -11,6 +11,167 @@ type User struct {
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 	LastSeen  *time.Time `json:"last_seen,omitempty" db:"last_seen"`
 	IsActive  bool       `json:"is_active" db:"is_active"`
+
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" db:"email_verified_at"`
+	IsBot           bool       `json:"is_bot" db:"is_bot"`
+	BotOwnerID      *int       `json:"bot_owner_id,omitempty" db:"bot_owner_id"`
+
+	DisplayName     *string    `json:"display_name,omitempty" db:"display_name"`
+	Bio             *string    `json:"bio,omitempty" db:"bio"`
+	AvatarURL       *string    `json:"avatar_url,omitempty" db:"avatar_url"`
+	StatusText      *string    `json:"status_text,omitempty" db:"status_text"`
+	StatusEmoji     *string    `json:"status_emoji,omitempty" db:"status_emoji"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty" db:"status_expires_at"`
+}
+
+const (
+	EventAccountLocked  = "account_locked"
+	EventLockoutCleared = "lockout_cleared"
+	EventRefreshReuse   = "refresh_token_reuse"
+	EventPasswordChange = "password_changed"
+	EventPasswordReset  = "password_reset"
+	EventEmailVerified  = "email_verified"
+	EventTwoFactorOn    = "two_factor_enabled"
+	EventTwoFactorOff   = "two_factor_disabled"
+	EventRecoveryUsed   = "recovery_code_used"
+	EventTwoFactorFail  = "two_factor_failed"
+	EventIdentityLinked = "identity_linked"
+
+	EventDeletionScheduled = "account_deletion_scheduled"
+	EventDeletionCancelled = "account_deletion_cancelled"
+)
+
+// Policies for accounts whose email address has not been verified yet.
+const (
+	// UnverifiedRestricted lets unverified users log in but not join chats.
+	UnverifiedRestricted = "restricted"
+	// UnverifiedBlocked refuses to log unverified users in at all.
+	UnverifiedBlocked = "blocked"
+)
+
+type SecurityEvent struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"-" db:"user_id"`
+	EventType string    `json:"event_type" db:"event_type"`
+	IPAddress *string   `json:"ip_address,omitempty" db:"ip_address"`
+	UserAgent *string   `json:"user_agent,omitempty" db:"user_agent"`
+	Details   *string   `json:"details,omitempty" db:"details"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type UserIdentity struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Provider    string     `json:"provider" db:"provider"`
+	Subject     string     `json:"-" db:"subject"`
+	Email       *string    `json:"email,omitempty" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+}
+
+type PersonalAccessToken struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	CreatedBy   int        `json:"created_by" db:"created_by"`
+	Name        string     `json:"name" db:"name"`
+	TokenPrefix string     `json:"token_prefix" db:"token_prefix"`
+	TokenHash   string     `json:"-" db:"token_hash"`
+	Scopes      []string   `json:"scopes" db:"scopes"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required,min=1,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=365"`
+}
+
+type CreatedTokenResponse struct {
+	Token string              `json:"token"`
+	Info  PersonalAccessToken `json:"info"`
+}
+
+type CreateBotRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=50"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+type TwoFactor struct {
+	UserID       int        `json:"-" db:"user_id"`
+	Secret       string     `json:"-" db:"secret"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty" db:"enabled_at"`
+	LastUsedStep *int64     `json:"-" db:"last_used_step"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorCode struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactor struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+type Session struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Device     *string    `json:"device,omitempty" db:"device"`
+	UserAgent  *string    `json:"user_agent,omitempty" db:"user_agent"`
+	IPAddress  *string    `json:"ip_address,omitempty" db:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     *string   `json:"device,omitempty"`
+	UserAgent  *string   `json:"user_agent,omitempty"`
+	IPAddress  *string   `json:"ip_address,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// ClientInfo describes the client a request came from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type SecurityEventListResponse struct {
+	Events  []SecurityEvent `json:"events"`
+	Total   int             `json:"total"`
+	HasMore bool            `json:"has_more"`
 }
 
 type UserRegister struct {
@@ -25,25 +186,238 @@ type UserLogin struct {
 }
 
 type UserLoginResponse struct {
-	AccessToken string       `json:"access_token"`
-	User        UserResponse `json:"user"`
-	ExpiresAt   time.Time    `json:"expires_at"`
+	AccessToken          string       `json:"access_token,omitempty"`
+	RefreshToken         string       `json:"refresh_token,omitempty"`
+	User                 UserResponse `json:"user"`
+	ExpiresAt            *time.Time   `json:"expires_at,omitempty"`
+	RefreshExpiresAt     *time.Time   `json:"refresh_expires_at,omitempty"`
+	VerificationRequired bool         `json:"verification_required,omitempty"`
+
+	TwoFactorRequired  bool       `json:"two_factor_required,omitempty"`
+	ChallengeToken     string     `json:"challenge_token,omitempty"`
+	ChallengeExpiresAt *time.Time `json:"challenge_expires_at,omitempty"`
+}
+
+type RefreshToken struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	FamilyID  string     `json:"-" db:"family_id"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 type UserResponse struct {
-	ID        int        `json:"id"`
-	Email     string     `json:"email"`
-	Username  string     `json:"username"`
-	CreatedAt time.Time  `json:"created_at"`
-	LastSeen  *time.Time `json:"last_seen,omitempty"`
-	AvatarURL *string    `json:"avatar_url,omitempty"`
-	Bio       *string    `json:"bio,omitempty"`
+	ID          int         `json:"id"`
+	Email       string      `json:"email"`
+	Username    string      `json:"username"`
+	DisplayName *string     `json:"display_name,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastSeen    *time.Time  `json:"last_seen,omitempty"`
+	Verified    bool        `json:"email_verified"`
+	IsBot       bool        `json:"is_bot"`
+	AvatarURL   *string     `json:"avatar_url,omitempty"`
+	Bio         *string     `json:"bio,omitempty"`
+	Status      *UserStatus `json:"status,omitempty"`
+}
+
+// UserSummary is the public view of a user used in directory listings. It
+// leaves out the email address.
+type UserSummary struct {
+	ID          int         `json:"id"`
+	Username    string      `json:"username"`
+	DisplayName *string     `json:"display_name,omitempty"`
+	AvatarURL   *string     `json:"avatar_url,omitempty"`
+	IsBot       bool        `json:"is_bot"`
+	Status      *UserStatus `json:"status,omitempty"`
+}
+
+// UserPresence is a user's status across all of their connections.
+type UserPresence struct {
+	UserID   int        `json:"user_id"`
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+// Contact events are pushed to the user's open WebSocket connections.
+const (
+	EventFriendRequest          = "friend_request"
+	EventFriendRequestAccepted  = "friend_request_accepted"
+	EventFriendRequestCancelled = "friend_request_cancelled"
+	EventContactRemoved         = "contact_removed"
+)
+
+type FriendRequest struct {
+	ID          int          `json:"id" db:"id"`
+	SenderID    int          `json:"sender_id" db:"sender_id"`
+	RecipientID int          `json:"recipient_id" db:"recipient_id"`
+	Status      string       `json:"status" db:"status"`
+	CreatedAt   time.Time    `json:"created_at" db:"created_at"`
+	RespondedAt *time.Time   `json:"responded_at,omitempty" db:"responded_at"`
+	User        *UserSummary `json:"user,omitempty" db:"-"`
+}
+
+type FriendRequestCreate struct {
+	UserID int `json:"user_id" binding:"required"`
+}
+
+type Contact struct {
+	User     UserSummary `json:"user"`
+	Nickname *string     `json:"nickname,omitempty"`
+	Since    time.Time   `json:"since"`
+}
+
+// ContactUpdate sets the private nickname of a contact. An empty nickname
+// clears it.
+type ContactUpdate struct {
+	Nickname string `json:"nickname" binding:"max=100"`
+}
+
+// ContactEvent is the WebSocket message for a change in the user's contacts.
+type ContactEvent struct {
+	Type    string         `json:"type"`
+	Request *FriendRequest `json:"request,omitempty"`
+	UserID  int            `json:"user_id,omitempty"`
+}
+
+// Audiences for privacy settings.
+const (
+	PrivacyEveryone = "everyone"
+	PrivacyContacts = "contacts"
+	PrivacyNobody   = "nobody"
+)
+
+// UserSettings are a user's privacy controls. DirectMessages decides who may
+// start a direct chat with the user, PresenceVisibility who sees their online
+// status and last seen, and Searchable whether they appear in user search.
+type UserSettings struct {
+	DirectMessages     string     `json:"direct_messages" db:"direct_messages"`
+	PresenceVisibility string     `json:"presence_visibility" db:"presence_visibility"`
+	Searchable         bool       `json:"searchable" db:"searchable"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+type UserSettingsUpdate struct {
+	DirectMessages     *string `json:"direct_messages,omitempty" binding:"omitempty,oneof=everyone contacts nobody"`
+	PresenceVisibility *string `json:"presence_visibility,omitempty" binding:"omitempty,oneof=everyone contacts nobody"`
+	Searchable         *bool   `json:"searchable,omitempty"`
+}
+
+func DefaultUserSettings() UserSettings {
+	return UserSettings{
+		DirectMessages:     PrivacyEveryone,
+		PresenceVisibility: PrivacyEveryone,
+		Searchable:         true,
+	}
 }
 
+const (
+	DataExportPending    = "pending"
+	DataExportProcessing = "processing"
+	DataExportReady      = "ready"
+	DataExportFailed     = "failed"
+	DataExportExpired    = "expired"
+)
+
+// EventDataExportReady is pushed to the user's connections when an export
+// finishes, successfully or not.
+const EventDataExportReady = "data_export_ready"
+
+// DataExport is a ZIP archive of everything stored about a user, built in
+// the background. DownloadURL is a signed link filled in once it is ready.
+type DataExport struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Status      string     `json:"status" db:"status"`
+	FileName    *string    `json:"-" db:"file_name"`
+	SizeBytes   *int64     `json:"size_bytes,omitempty" db:"size_bytes"`
+	Error       *string    `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	DownloadURL string     `json:"download_url,omitempty" db:"-"`
+}
+
+type DataExportEvent struct {
+	Type   string      `json:"type"`
+	Export *DataExport `json:"export"`
+}
+
+// What happens to a deleted user's messages and the chats they own.
+const (
+	DeletionAnonymizeMessages = "anonymize"
+	DeletionDeleteMessages    = "delete"
+	DeletionTransferChats     = "transfer"
+	DeletionCloseChats        = "close"
+)
+
+// AccountDeletionRequest schedules the account for deletion. Messages are
+// anonymised and owned chats handed to another member unless asked otherwise.
+// The current password confirms the request, as for a password change.
+type AccountDeletionRequest struct {
+	Password string `json:"password" binding:"required"`
+	Messages string `json:"messages" binding:"omitempty,oneof=anonymize delete"`
+	Chats    string `json:"chats" binding:"omitempty,oneof=transfer close"`
+}
+
+// AccountDeletion is a pending deletion. Until ScheduledFor the account works
+// as usual and the deletion can be cancelled.
+type AccountDeletion struct {
+	UserID       int       `json:"user_id" db:"user_id"`
+	Messages     string    `json:"messages" db:"messages"`
+	Chats        string    `json:"chats" db:"chats"`
+	RequestedAt  time.Time `json:"requested_at" db:"requested_at"`
+	ScheduledFor time.Time `json:"scheduled_for" db:"scheduled_for"`
+}
+
+type BlockedUser struct {
+	User      UserSummary `json:"user"`
+	BlockedAt time.Time   `json:"blocked_at"`
+}
+
+type UserSearchResponse struct {
+	Users   []UserSummary `json:"users"`
+	Total   int           `json:"total"`
+	HasMore bool          `json:"has_more"`
+}
+
+// UserStatus is a short custom status such as "In a meeting" shown next to
+// the user's name.
+type UserStatus struct {
+	Text      string     `json:"text,omitempty" binding:"max=100"`
+	Emoji     string     `json:"emoji,omitempty" binding:"max=32"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// UserUpdate changes the fields that are set. An empty string clears
+// display_name, bio or avatar_url, and an empty status clears the status.
 type UserUpdate struct {
-	Username  *string `json:"username,omitempty" binding:"omitempty,min=3,max=50"`
-	AvatarURL *string `json:"avatar_url,omitempty"`
-	Bio       *string `json:"bio,omitempty" binding:"omitempty,max=500"`
+	Username    *string     `json:"username,omitempty" binding:"omitempty,min=3,max=50"`
+	DisplayName *string     `json:"display_name,omitempty" binding:"omitempty,max=100"`
+	AvatarURL   *string     `json:"avatar_url,omitempty" binding:"omitempty,max=2048"`
+	Bio         *string     `json:"bio,omitempty" binding:"omitempty,max=500"`
+	Status      *UserStatus `json:"status,omitempty"`
+}
+
+// AvatarUploadRequest holds the optional crop square sent alongside the
+// avatar file. Without crop_size the centred square is used.
+type AvatarUploadRequest struct {
+	CropX    int  `form:"crop_x" binding:"omitempty,min=0"`
+	CropY    int  `form:"crop_y" binding:"omitempty,min=0"`
+	CropSize *int `form:"crop_size" binding:"omitempty,min=1"`
 }
 
 type ChangePassword struct {
@@ -51,12 +425,83 @@ type ChangePassword struct {
 	NewPassword     string `json:"new_password" binding:"required,min=8"`
 }
 
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
 func (u *User) ToResponse() UserResponse {
 	return UserResponse{
-		ID:        u.ID,
-		Email:     u.Email,
-		Username:  u.Username,
-		CreatedAt: u.CreatedAt,
-		LastSeen:  u.LastSeen,
+		ID:          u.ID,
+		Email:       u.Email,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		CreatedAt:   u.CreatedAt,
+		LastSeen:    u.LastSeen,
+		Verified:    u.IsEmailVerified(),
+		IsBot:       u.IsBot,
+		AvatarURL:   u.AvatarURL,
+		Bio:         u.Bio,
+		Status:      u.CurrentStatus(),
+	}
+}
+
+func (u *User) ToSummary() UserSummary {
+	return UserSummary{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		AvatarURL:   u.AvatarURL,
+		IsBot:       u.IsBot,
+		Status:      u.CurrentStatus(),
+	}
+}
+
+// CurrentStatus returns the custom status, or nil if none is set or it has
+// expired. Expired statuses are left in the table and ignored here.
+func (u *User) CurrentStatus() *UserStatus {
+	if u.StatusText == nil && u.StatusEmoji == nil {
+		return nil
+	}
+	if u.StatusExpiresAt != nil && !u.StatusExpiresAt.After(time.Now()) {
+		return nil
+	}
+
+	status := &UserStatus{ExpiresAt: u.StatusExpiresAt}
+	if u.StatusText != nil {
+		status.Text = *u.StatusText
+	}
+	if u.StatusEmoji != nil {
+		status.Emoji = *u.StatusEmoji
+	}
+
+	return status
+}
+
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (s *Session) ToResponse(currentSessionID string) SessionResponse {
+	return SessionResponse{
+		ID:         s.ID,
+		Device:     s.Device,
+		UserAgent:  s.UserAgent,
+		IPAddress:  s.IPAddress,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		Current:    s.ID == currentSessionID,
 	}
 }
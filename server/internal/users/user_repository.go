@@ -3,6 +3,7 @@ package users
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -22,43 +23,37 @@ func NewUserRepository(db *sql.DB, logger *logrus.Logger) *UserRepository {
 
 func (r *UserRepository) CreateUser(user User) (*User, error) {
 	query := `
-		INSERT INTO users (email, username, password_hash, created_at, updated_at, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at, updated_at, last_seen, is_active
+		INSERT INTO users (email, username, password_hash, created_at, updated_at, is_active,
+		                   is_bot, bot_owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + userColumns + `
 	`
 
 	now := time.Now()
-	row := r.db.QueryRow(query, user.Email, user.Username, user.Password, now, now, true)
-
-	err := row.Scan(
-		&user.ID, &user.CreatedAt, &user.UpdatedAt,
-		&user.LastSeen, &user.IsActive,
-	)
+	created, err := scanUser(r.db.QueryRow(query, user.Email, user.Username, user.Password, now, now, true,
+		user.IsBot, user.BotOwnerID))
 	if err != nil {
 		r.logger.WithError(err).Error("Failed to create user")
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	return &user, nil
+	return created, nil
+}
+
+// normalizeEmail returns the form of email that GetByEmail matches it in,
+// for keying throttles and lockouts on the same address.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 func (r *UserRepository) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, email, username, password_hash, created_at, updated_at, 
-		       last_seen, is_active
-		FROM users 
-		WHERE email = $1 AND is_active = true
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE LOWER(email) = LOWER($1) AND is_active = true
 	`
 
-	user := &User{}
-	row := r.db.QueryRow(query, email)
-
-	err := row.Scan(
-		&user.ID, &user.Email, &user.Username, &user.Password,
-		&user.CreatedAt, &user.UpdatedAt, &user.LastSeen,
-		&user.IsActive,
-	)
-
+	user, err := scanUser(r.db.QueryRow(query, email))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -72,21 +67,12 @@ func (r *UserRepository) GetByEmail(email string) (*User, error) {
 
 func (r *UserRepository) GetByID(id int) (*User, error) {
 	query := `
-		SELECT id, email, username, password_hash, created_at, updated_at, 
-		       last_seen, is_active
-		FROM users 
+		SELECT ` + userColumns + `
+		FROM users
 		WHERE id = $1 AND is_active = true
 	`
 
-	user := &User{}
-	row := r.db.QueryRow(query, id)
-
-	err := row.Scan(
-		&user.ID, &user.Email, &user.Username, &user.Password,
-		&user.CreatedAt, &user.UpdatedAt, &user.LastSeen,
-		&user.IsActive,
-	)
-
+	user, err := scanUser(r.db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -100,21 +86,12 @@ func (r *UserRepository) GetByID(id int) (*User, error) {
 
 func (r *UserRepository) GetByUsername(username string) (*User, error) {
 	query := `
-		SELECT id, email, username, password_hash, created_at, updated_at, 
-		       last_seen, is_active
-		FROM users 
+		SELECT ` + userColumns + `
+		FROM users
 		WHERE username = $1 AND is_active = true
 	`
 
-	user := &User{}
-	row := r.db.QueryRow(query, username)
-
-	err := row.Scan(
-		&user.ID, &user.Email, &user.Username, &user.Password,
-		&user.CreatedAt, &user.UpdatedAt, &user.LastSeen,
-		&user.IsActive,
-	)
-
+	user, err := scanUser(r.db.QueryRow(query, username))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -137,6 +114,38 @@ func (r *UserRepository) UpdateUser(id int, update UserUpdate) (*User, error) {
 		argIndex++
 	}
 
+	if update.DisplayName != nil {
+		setParts = append(setParts, fmt.Sprintf("display_name = $%d", argIndex))
+		args = append(args, nullIfEmpty(*update.DisplayName))
+		argIndex++
+	}
+
+	if update.Bio != nil {
+		setParts = append(setParts, fmt.Sprintf("bio = $%d", argIndex))
+		args = append(args, nullIfEmpty(*update.Bio))
+		argIndex++
+	}
+
+	if update.AvatarURL != nil {
+		setParts = append(setParts, fmt.Sprintf("avatar_url = $%d", argIndex))
+		args = append(args, nullIfEmpty(*update.AvatarURL))
+		argIndex++
+	}
+
+	if update.Status != nil {
+		setParts = append(setParts,
+			fmt.Sprintf("status_text = $%d", argIndex),
+			fmt.Sprintf("status_emoji = $%d", argIndex+1),
+			fmt.Sprintf("status_expires_at = $%d", argIndex+2),
+		)
+		args = append(args,
+			nullIfEmpty(update.Status.Text),
+			nullIfEmpty(update.Status.Emoji),
+			update.Status.ExpiresAt,
+		)
+		argIndex += 3
+	}
+
 	if len(setParts) == 0 {
 		return r.GetByID(id)
 	}
@@ -159,19 +168,10 @@ func (r *UserRepository) UpdateUser(id int, update UserUpdate) (*User, error) {
 		UPDATE users 
 		SET %s 
 		WHERE id = $%d AND is_active = true
-		RETURNING id, email, username, password_hash, created_at, updated_at, 
-		          last_seen, is_active
-	`, setClause, argIndex)
-
-	user := &User{}
-	row := r.db.QueryRow(query, args...)
-
-	err := row.Scan(
-		&user.ID, &user.Email, &user.Username, &user.Password,
-		&user.CreatedAt, &user.UpdatedAt, &user.LastSeen,
-		&user.IsActive,
-	)
+		RETURNING %s
+	`, setClause, argIndex, userColumns)
 
+	user, err := scanUser(r.db.QueryRow(query, args...))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -183,6 +183,182 @@ func (r *UserRepository) UpdateUser(id int, update UserUpdate) (*User, error) {
 	return user, nil
 }
 
+// SetAvatarURL replaces the avatar of id and returns the previous one, so the
+// caller can clean up files it no longer references.
+func (r *UserRepository) SetAvatarURL(id int, avatarURL *string) (*string, error) {
+	query := `
+		UPDATE users u SET avatar_url = $1, updated_at = $2
+		FROM (SELECT avatar_url FROM users WHERE id = $3 AND is_active = true FOR UPDATE) old
+		WHERE u.id = $3
+		RETURNING old.avatar_url
+	`
+
+	var previous *string
+	err := r.db.QueryRow(query, avatarURL, time.Now(), id).Scan(&previous)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		r.logger.WithError(err).Error("Failed to set avatar")
+		return nil, fmt.Errorf("failed to set avatar: %w", err)
+	}
+
+	return previous, nil
+}
+
+func (r *UserRepository) UpdatePassword(id int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3 AND is_active = true`
+
+	result, err := r.db.Exec(query, passwordHash, time.Now(), id)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to update password")
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("user not found")
+	}
+
+	return nil
+}
+
+func (r *UserRepository) MarkEmailVerified(id int) error {
+	query := `
+		UPDATE users SET email_verified_at = $1, updated_at = $1
+		WHERE id = $2 AND is_active = true AND email_verified_at IS NULL
+	`
+
+	_, err := r.db.Exec(query, time.Now(), id)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to mark email verified")
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) GetBotsByOwner(ownerID int) ([]User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE bot_owner_id = $1 AND is_bot = true AND is_active = true
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, ownerID)
+	if err != nil {
+		r.logger.WithError(err).WithField("owner_id", ownerID).Error("Failed to get bots")
+		return nil, fmt.Errorf("failed to get bots: %w", err)
+	}
+	defer rows.Close()
+
+	var bots []User
+	for rows.Next() {
+		bot, err := scanUser(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan bot")
+			continue
+		}
+		bots = append(bots, *bot)
+	}
+
+	return bots, nil
+}
+
+// SearchUsers finds active users other than searcherID whose username or
+// display name starts with or is similar to query. Exact and prefix matches
+// rank above trigram matches. Users on either side of a block never see each
+// other.
+func (r *UserRepository) SearchUsers(searcherID int, query string, limit, offset int) ([]User, int, error) {
+	term := strings.ToLower(query)
+	prefix := escapeLike(term) + "%"
+
+	filter := `
+		WHERE u.is_active = true AND u.id <> $1
+		AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.blocker_id = $1 AND b.blocked_id = u.id)
+			   OR (b.blocker_id = u.id AND b.blocked_id = $1)
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM user_settings s
+			WHERE s.user_id = u.id AND s.searchable = false
+		)
+		AND (
+			lower(u.username) LIKE $3 OR lower(u.display_name) LIKE $3
+			OR lower(u.username) % $2 OR lower(u.display_name) % $2
+		)
+	`
+
+	var total int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM users u`+filter, searcherID, term, prefix).Scan(&total)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to count user search results")
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	searchQuery := `
+		SELECT ` + userColumns + `
+		FROM users u` + filter + `
+		ORDER BY lower(u.username) = $2 DESC,
+		         (lower(u.username) LIKE $3 OR lower(u.display_name) LIKE $3) DESC,
+		         GREATEST(similarity(lower(u.username), $2),
+		                  similarity(lower(COALESCE(u.display_name, '')), $2)) DESC,
+		         u.username
+		LIMIT $4 OFFSET $5
+	`
+
+	rows, err := r.db.Query(searchQuery, searcherID, term, prefix, limit, offset)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to search users")
+		return nil, 0, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan user")
+			continue
+		}
+		users = append(users, *user)
+	}
+
+	return users, total, nil
+}
+
+// GetLastSeen returns last_seen for the active users among ids. Unknown and
+// inactive users are left out.
+func (r *UserRepository) GetLastSeen(ids []int) (map[int]*time.Time, error) {
+	query := `SELECT id, last_seen FROM users WHERE id = ANY($1) AND is_active = true`
+
+	rows, err := r.db.Query(query, ids)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to get last seen")
+		return nil, fmt.Errorf("failed to get last seen: %w", err)
+	}
+	defer rows.Close()
+
+	lastSeen := make(map[int]*time.Time, len(ids))
+	for rows.Next() {
+		var id int
+		var seen *time.Time
+		if err := rows.Scan(&id, &seen); err != nil {
+			r.logger.WithError(err).Error("Failed to scan last seen")
+			continue
+		}
+		lastSeen[id] = seen
+	}
+
+	return lastSeen, nil
+}
+
 func (r *UserRepository) UpdateLastSeen(id int) error {
 	query := `UPDATE users SET last_seen = $1 WHERE id = $2 AND is_active = true`
 
@@ -217,7 +393,7 @@ func (r *UserRepository) DeleteUser(id int) error {
 }
 
 func (r *UserRepository) EmailExists(email string) (bool, error) {
-	query := `SELECT COUNT(*) FROM users WHERE email = $1 AND is_active = true`
+	query := `SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1) AND is_active = true`
 
 	var count int
 	err := r.db.QueryRow(query, email).Scan(&count)
@@ -241,3 +417,44 @@ func (r *UserRepository) UsernameExists(username string) (bool, error) {
 
 	return count > 0, nil
 }
+
+// userColumns lists the users columns in the order scanUser reads them.
+const userColumns = `id, email, username, password_hash, created_at, updated_at,
+	last_seen, is_active, email_verified_at, is_bot, bot_owner_id,
+	display_name, bio, avatar_url, status_text, status_emoji, status_expires_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads userColumns, followed by any extra columns the query selects
+// after them.
+func scanUser(row rowScanner, extra ...interface{}) (*User, error) {
+	user := &User{}
+
+	dest := []interface{}{
+		&user.ID, &user.Email, &user.Username, &user.Password,
+		&user.CreatedAt, &user.UpdatedAt, &user.LastSeen,
+		&user.IsActive, &user.EmailVerifiedAt,
+		&user.IsBot, &user.BotOwnerID,
+		&user.DisplayName, &user.Bio, &user.AvatarURL,
+		&user.StatusText, &user.StatusEmoji, &user.StatusExpiresAt,
+	}
+
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -3,10 +3,12 @@ package users
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"onlineChat/pkg/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/sirupsen/logrus"
 )
 
@@ -33,13 +35,15 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	response, err := h.service.RegisterUser(req)
+	response, err := h.service.RegisterUser(req, clientInfo(c))
 	if err != nil {
 		h.logger.WithError(err).WithField("email", req.Email).Error("Failed to register user")
 
 		statusCode := http.StatusInternalServerError
 		if err.Error() == "email already exists" || err.Error() == "username already exists" {
 			statusCode = http.StatusConflict
+		} else if strings.HasPrefix(err.Error(), "password ") {
+			statusCode = http.StatusBadRequest
 		}
 
 		c.JSON(statusCode, gin.H{
@@ -63,9 +67,16 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	response, err := h.service.LoginUser(req)
+	response, err := h.service.LoginUser(req, clientInfo(c))
 	if err != nil {
 		h.logger.WithError(err).WithField("email", req.Email).Warn("Failed login attempt")
+		if err.Error() == "email not verified" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                 "Email address has not been verified",
+				"verification_required": true,
+			})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Invalid credentials",
 		})
@@ -75,6 +86,126 @@ func (h *Handler) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+func (h *Handler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+		h.logger.WithError(err).Debug("Invalid refresh request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	response, err := h.service.RefreshTokens(req.RefreshToken, clientInfo(c))
+	if err != nil {
+		h.logger.WithError(err).Warn("Failed to refresh token")
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired refresh token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RefreshRateLimitSubject counts refresh requests per session, so that tabs
+// of one session share a budget and users behind one address do not.
+// Requests without a known refresh token are counted per client IP.
+func (h *Handler) RefreshRateLimitSubject(c *gin.Context) string {
+	var req RefreshRequest
+	if err := c.ShouldBindBodyWith(&req, binding.JSON); err == nil {
+		if sessionID, err := h.service.RefreshTokenSession(req.RefreshToken); err == nil {
+			return "session:" + sessionID
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	sessionID, _ := utils.GetSessionID(c)
+
+	if err := h.service.Logout(userID, sessionID); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to log out")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No active session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Logged out successfully",
+	})
+}
+
+func (h *Handler) GetSessions(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	sessionID, _ := utils.GetSessionID(c)
+
+	sessions, err := h.service.GetSessions(userID, sessionID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get sessions")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get sessions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	sessionID := c.Param("id")
+
+	if err := h.service.RevokeSession(userID, sessionID); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"session_id": sessionID,
+		}).Warn("Failed to revoke session")
+
+		statusCode := http.StatusInternalServerError
+		if err.Error() == "session not found" {
+			statusCode = http.StatusNotFound
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked",
+	})
+}
+
 func (h *Handler) GetProfile(c *gin.Context) {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
@@ -118,10 +249,46 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 	user, err := h.service.UpdateUser(userID, req)
 	if err != nil {
 		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to update user profile")
+		c.JSON(profileStatusCode(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		if err.Error() == "username already exists" {
-			statusCode = http.StatusConflict
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *Handler) ChangePassword(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var req ChangePassword
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid change password request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	sessionID, _ := utils.GetSessionID(c)
+
+	if err := h.service.ChangePassword(userID, sessionID, req, clientInfo(c)); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to change password")
+
+		statusCode := http.StatusBadRequest
+		if err.Error() == "current password is incorrect" {
+			statusCode = http.StatusForbidden
+		} else if err.Error() == "too many failed attempts, try again later" {
+			statusCode = http.StatusTooManyRequests
+		} else if strings.HasPrefix(err.Error(), "failed to") {
+			statusCode = http.StatusInternalServerError
 		}
 
 		c.JSON(statusCode, gin.H{
@@ -130,10 +297,110 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed successfully",
+	})
 }
 
-func (h *Handler) DeleteAccount(c *gin.Context) {
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid verify email request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.service.VerifyEmail(req.Token, clientInfo(c))
+	if err != nil {
+		h.logger.WithError(err).Warn("Failed to verify email")
+
+		statusCode := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "failed to") {
+			statusCode = http.StatusInternalServerError
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email address verified",
+		"user":    user,
+	})
+}
+
+func (h *Handler) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid resend verification request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	h.service.ResendEmailVerification(req.Email)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If an unverified account with that email exists, a verification link has been sent",
+	})
+}
+
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid forgot password request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	h.service.ForgotPassword(req.Email, clientInfo(c))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If an account with that email exists, a reset link has been sent",
+	})
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Debug("Invalid reset password request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.service.ResetPassword(req, clientInfo(c)); err != nil {
+		h.logger.WithError(err).Warn("Failed to reset password")
+
+		statusCode := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "failed to") {
+			statusCode = http.StatusInternalServerError
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password has been reset",
+	})
+}
+
+func (h *Handler) GetSecurityLog(c *gin.Context) {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -142,21 +409,37 @@ func (h *Handler) DeleteAccount(c *gin.Context) {
 		return
 	}
 
-	err = h.service.DeleteUser(userID)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	events, err := h.service.GetSecurityLog(userID, limit, offset)
 	if err != nil {
-		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to delete user account")
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get security log")
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to delete account",
+			"error": "Failed to get security log",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Account deleted successfully",
-	})
+	c.JSON(http.StatusOK, events)
 }
 
 func (h *Handler) GetUserByID(c *gin.Context) {
+	viewerID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -166,7 +449,7 @@ func (h *Handler) GetUserByID(c *gin.Context) {
 		return
 	}
 
-	user, err := h.service.GetUserByID(id)
+	user, err := h.service.GetUserProfile(viewerID, id)
 	if err != nil {
 		h.logger.WithError(err).WithField("user_id", id).Error("Failed to get user by ID")
 		c.JSON(http.StatusNotFound, gin.H{
@@ -177,3 +460,123 @@ func (h *Handler) GetUserByID(c *gin.Context) {
 
 	c.JSON(http.StatusOK, user)
 }
+
+func (h *Handler) GetUserByUsername(c *gin.Context) {
+	viewerID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	username := c.Param("username")
+
+	user, err := h.service.GetUserByUsername(viewerID, username)
+	if err != nil {
+		h.logger.WithError(err).WithField("username", username).Debug("Failed to get user by username")
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "User not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// GetPresence looks up the presence of the users in the comma-separated ids
+// query parameter.
+func (h *Handler) GetPresence(c *gin.Context) {
+	viewerID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	var ids []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(c.Query("ids"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid user ID",
+			})
+			return
+		}
+
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	presence, err := h.service.GetPresence(viewerID, ids)
+	if err != nil {
+		h.logger.WithError(err).Warn("Failed to get presence")
+
+		statusCode := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "failed to") {
+			statusCode = http.StatusInternalServerError
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"presence": presence,
+	})
+}
+
+func (h *Handler) SearchUsers(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User not authenticated",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	results, err := h.service.SearchUsers(userID, c.Query("q"), limit, offset)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to search users")
+
+		statusCode := http.StatusBadRequest
+		if strings.HasPrefix(err.Error(), "failed to") {
+			statusCode = http.StatusInternalServerError
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+func clientInfo(c *gin.Context) ClientInfo {
+	return ClientInfo{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
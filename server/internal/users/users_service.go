@@ -2,30 +2,79 @@ package users
 
 import (
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"onlineChat/pkg/jwtauth"
+	"onlineChat/pkg/mailer"
+	"onlineChat/pkg/redis"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Trigram matching needs a few characters to be useful.
+const (
+	minUserSearchLength = 2
+	maxUserSearchLength = 100
+)
+
+// dummyPasswordHash is compared against when the account does not exist so
+// that failed logins take the same time either way.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+type ServiceConfig struct {
+	TokenKeys        *jwtauth.KeySet
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+	AppURL           string
+	PasswordResetTTL time.Duration
+	MailThrottle     time.Duration
+
+	EmailVerificationTTL time.Duration
+	UnverifiedPolicy     string
+
+	TwoFactorIssuer       string
+	TwoFactorChallengeTTL time.Duration
+
+	AccountDeletionGrace time.Duration
+
+	AvatarDir      string
+	AvatarBaseURL  string
+	AvatarSize     int
+	AvatarMaxBytes int64
+
+	ExportDir        string
+	ExportTTL        time.Duration
+	ExportLinkTTL    time.Duration
+	ExportLinkSecret []byte
+}
+
 type UserService struct {
-	repo      *UserRepository
-	jwtSecret string
-	jwtExpire time.Duration
-	logger    *logrus.Logger
+	repo   *UserRepository
+	guard  *LoginGuard
+	redis  *redis.RedisClient
+	mailer mailer.Mailer
+	oidc   *OIDCProvider
+	cfg    ServiceConfig
+	logger *logrus.Logger
 }
 
-func NewUserService(repo *UserRepository, jwtSecret string, jwtExpire time.Duration, logger *logrus.Logger) *UserService {
+func NewUserService(repo *UserRepository, guard *LoginGuard, redisClient *redis.RedisClient, mail mailer.Mailer, oidc *OIDCProvider, cfg ServiceConfig, logger *logrus.Logger) *UserService {
 	return &UserService{
-		repo:      repo,
-		jwtSecret: jwtSecret,
-		jwtExpire: jwtExpire,
-		logger:    logger,
+		repo:   repo,
+		guard:  guard,
+		redis:  redisClient,
+		mailer: mail,
+		oidc:   oidc,
+		cfg:    cfg,
+		logger: logger,
 	}
 }
 
-func (us *UserService) RegisterUser(req UserRegister) (*UserLoginResponse, error) {
+func (us *UserService) RegisterUser(req UserRegister, client ClientInfo) (*UserLoginResponse, error) {
 	exists, err := us.repo.EmailExists(req.Email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check email existence: %w", err)
@@ -42,6 +91,10 @@ func (us *UserService) RegisterUser(req UserRegister) (*UserLoginResponse, error
 		return nil, fmt.Errorf("username already exists")
 	}
 
+	if err := validatePassword(req.Password, req.Email, req.Username); err != nil {
+		return nil, err
+	}
+
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
@@ -58,51 +111,96 @@ func (us *UserService) RegisterUser(req UserRegister) (*UserLoginResponse, error
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
-	token, err := us.generateToken(createdUser.ID, createdUser.Username, createdUser.Email)
+	go us.sendEmailVerification(createdUser)
+
+	if us.cfg.UnverifiedPolicy == UnverifiedBlocked {
+		us.logger.WithField("user_id", createdUser.ID).Info("User registered, awaiting email verification")
+
+		return &UserLoginResponse{
+			User:                 createdUser.ToResponse(),
+			VerificationRequired: true,
+		}, nil
+	}
+
+	session, err := us.createSession(createdUser, client)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := us.issueTokens(createdUser, session.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, err
 	}
 
 	us.logger.WithField("user_id", createdUser.ID).Info("User registered successfully")
 
-	return &UserLoginResponse{
-		AccessToken: token,
-		User:        createdUser.ToResponse(),
-		ExpiresAt:   time.Now().Add(us.jwtExpire),
-	}, nil
+	return response, nil
 }
 
-func (us *UserService) LoginUser(req UserLogin) (*UserLoginResponse, error) {
+func (us *UserService) LoginUser(req UserLogin, client ClientInfo) (*UserLoginResponse, error) {
+	if until := us.guard.LockedUntil(req.Email, client.IP); until != nil {
+		// Take as long as a wrong password would, so the response time does
+		// not tell that the account is locked.
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		us.logger.WithFields(logrus.Fields{
+			"email":        req.Email,
+			"client_ip":    client.IP,
+			"locked_until": until,
+		}).Warn("Login attempt while locked out")
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
 	user, err := us.repo.GetByEmail(req.Email)
 	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		us.guard.RecordFailure(req.Email, client.IP)
 		us.logger.WithError(err).WithField("email", req.Email).Warn("Failed login attempt")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		if until := us.guard.RecordFailure(req.Email, client.IP); until != nil {
+			us.recordSecurityEvent(user.ID, EventAccountLocked, client,
+				fmt.Sprintf("locked until %s", until.Format(time.RFC3339)))
+		}
 		us.logger.WithError(err).WithField("user_id", user.ID).Warn("Failed login attempt - invalid password")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 
+	if us.guard.RecordSuccess(req.Email) {
+		us.recordSecurityEvent(user.ID, EventLockoutCleared, client, "")
+	}
+
+	if us.cfg.UnverifiedPolicy == UnverifiedBlocked && !user.IsEmailVerified() {
+		us.logger.WithField("user_id", user.ID).Info("Login refused, email not verified")
+		return nil, fmt.Errorf("email not verified")
+	}
+
+	return us.loginOrChallenge(user, client)
+}
+
+// completeLogin opens a session for a user who passed every login step.
+func (us *UserService) completeLogin(user *User, client ClientInfo) (*UserLoginResponse, error) {
 	go func() {
 		if err := us.repo.UpdateLastSeen(user.ID); err != nil {
 			us.logger.WithError(err).WithField("user_id", user.ID).Warn("Failed to update last seen")
 		}
 	}()
 
-	token, err := us.generateToken(user.ID, user.Username, user.Email)
+	session, err := us.createSession(user, client)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := us.issueTokens(user, session.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, err
 	}
 
 	us.logger.WithField("user_id", user.ID).Info("User logged in successfully")
 
-	return &UserLoginResponse{
-		AccessToken: token,
-		User:        user.ToResponse(),
-		ExpiresAt:   time.Now().Add(us.jwtExpire),
-	}, nil
+	return response, nil
 }
 
 func (us *UserService) GetUserByID(id int) (*UserResponse, error) {
@@ -115,7 +213,92 @@ func (us *UserService) GetUserByID(id int) (*UserResponse, error) {
 	return &response, nil
 }
 
+// GetUserProfile returns user id as seen by viewerID. The last seen time is
+// left out if the user hides their presence from the viewer.
+func (us *UserService) GetUserProfile(viewerID, id int) (*UserResponse, error) {
+	response, err := us.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.hidePresence(viewerID, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// GetUserByUsername looks a user up by exact username for viewerID. Like
+// search it does not find users blocked by or blocking the viewer, nor users
+// who opted out of search unless they are the viewer's contacts.
+func (us *UserService) GetUserByUsername(viewerID int, username string) (*UserSummary, error) {
+	user, err := us.repo.GetByUsername(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user.ID != viewerID {
+		blocked, err := us.repo.IsBlockedBetween(viewerID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, fmt.Errorf("user not found")
+		}
+
+		settings, err := us.repo.GetSettings(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !settings.Searchable {
+			contacts, err := us.repo.AreContacts(viewerID, user.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !contacts {
+				return nil, fmt.Errorf("user not found")
+			}
+		}
+	}
+
+	summary := user.ToSummary()
+	return &summary, nil
+}
+
+// SearchUsers looks up other users by username or display name for starting
+// conversations and sending invites. Users who opted out of search are left
+// out.
+func (us *UserService) SearchUsers(searcherID int, query string, limit, offset int) (*UserSearchResponse, error) {
+	query = strings.TrimSpace(query)
+	if utf8.RuneCountInString(query) < minUserSearchLength {
+		return nil, fmt.Errorf("search query must be at least %d characters", minUserSearchLength)
+	}
+	if utf8.RuneCountInString(query) > maxUserSearchLength {
+		return nil, fmt.Errorf("search query must be at most %d characters", maxUserSearchLength)
+	}
+
+	users, total, err := us.repo.SearchUsers(searcherID, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]UserSummary, 0, len(users))
+	for _, user := range users {
+		summaries = append(summaries, user.ToSummary())
+	}
+
+	return &UserSearchResponse{
+		Users:   summaries,
+		Total:   total,
+		HasMore: (offset + len(users)) < total,
+	}, nil
+}
+
 func (us *UserService) UpdateUser(id int, update UserUpdate) (*UserResponse, error) {
+	if err := validateProfileUpdate(&update); err != nil {
+		return nil, err
+	}
+
 	if update.Username != nil {
 		exists, err := us.repo.UsernameExists(*update.Username)
 		if err != nil {
@@ -132,6 +315,16 @@ func (us *UserService) UpdateUser(id int, update UserUpdate) (*UserResponse, err
 		}
 	}
 
+	if update.AvatarURL != nil {
+		// Go through SetAvatarURL so a replaced upload is removed from disk.
+		previous, err := us.repo.SetAvatarURL(id, nullIfEmpty(*update.AvatarURL))
+		if err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+		us.removeAvatarFile(previous)
+		update.AvatarURL = nil
+	}
+
 	user, err := us.repo.UpdateUser(id, update)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
@@ -143,50 +336,158 @@ func (us *UserService) UpdateUser(id int, update UserUpdate) (*UserResponse, err
 	return &response, nil
 }
 
-func (us *UserService) DeleteUser(id int) error {
-	err := us.repo.DeleteUser(id)
+// ChangePassword replaces the password of userID after checking the current
+// one. Every session other than sessionID is revoked.
+func (us *UserService) ChangePassword(userID int, sessionID string, req ChangePassword, client ClientInfo) error {
+	user, err := us.repo.GetByID(userID)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := us.verifyCurrentPassword(user, req.CurrentPassword, client); err != nil {
+		return err
+	}
+
+	if req.CurrentPassword == req.NewPassword {
+		return fmt.Errorf("new password must differ from the current password")
 	}
 
-	us.logger.WithField("user_id", id).Info("User deleted successfully")
+	if err := validatePassword(req.NewPassword, user.Email, user.Username); err != nil {
+		return err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if err := us.repo.UpdatePassword(userID, string(passwordHash)); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	revoked := us.revokeOtherSessions(userID, sessionID)
+	tokens := us.revokeOwnedPersonalTokens(userID)
+
+	us.recordSecurityEvent(userID, EventPasswordChange, client,
+		fmt.Sprintf("%d other sessions signed out, %d access tokens revoked", revoked, tokens))
+
+	us.logger.WithField("user_id", userID).Info("Password changed successfully")
 
 	return nil
 }
 
-func (us *UserService) generateToken(userID int, username, email string) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"email":    email,
-		"exp":      time.Now().Add(us.jwtExpire).Unix(),
-		"iat":      time.Now().Unix(),
+// verifyCurrentPassword checks the password of a signed-in user. Failures
+// count towards the same lockout as failed logins, so a stolen access token
+// cannot be used to guess the password.
+func (us *UserService) verifyCurrentPassword(user *User, password string, client ClientInfo) error {
+	if until := us.guard.LockedUntil(user.Email, client.IP); until != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		us.logger.WithFields(logrus.Fields{
+			"user_id":      user.ID,
+			"client_ip":    client.IP,
+			"locked_until": until,
+		}).Warn("Password check while locked out")
+		return fmt.Errorf("too many failed attempts, try again later")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		if until := us.guard.RecordFailure(user.Email, client.IP); until != nil {
+			us.recordSecurityEvent(user.ID, EventAccountLocked, client,
+				fmt.Sprintf("locked until %s", until.Format(time.RFC3339)))
+		}
+		return fmt.Errorf("current password is incorrect")
+	}
+
+	if us.guard.RecordSuccess(user.Email) {
+		us.recordSecurityEvent(user.ID, EventLockoutCleared, client, "")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(us.jwtSecret))
+	return nil
+}
+
+// revokeOtherSessions signs userID out everywhere except keepSessionID and
+// returns the number of sessions revoked.
+func (us *UserService) revokeOtherSessions(userID int, keepSessionID string) int {
+	sessionIDs, err := us.repo.RevokeOtherSessions(userID, keepSessionID)
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		us.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke other sessions")
+		return 0
+	}
+
+	for _, sessionID := range sessionIDs {
+		us.terminateSession(userID, sessionID)
 	}
 
-	return tokenString, nil
+	return len(sessionIDs)
 }
 
-func (us *UserService) ValidateToken(tokenString string) (*jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(us.jwtSecret), nil
-	})
+// revokeOwnedPersonalTokens revokes the personal access tokens of userID and
+// their bots, which would otherwise outlive a password change, and returns
+// the number revoked.
+func (us *UserService) revokeOwnedPersonalTokens(userID int) int {
+	revoked, err := us.repo.RevokeOwnedPersonalTokens(userID)
+	if err != nil {
+		us.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke personal access tokens")
+		return 0
+	}
 
+	return revoked
+}
+
+func (us *UserService) GetSecurityLog(userID int, limit, offset int) (*SecurityEventListResponse, error) {
+	events, total, err := us.repo.GetSecurityEvents(userID, limit, offset)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to get security log: %w", err)
+	}
+
+	return &SecurityEventListResponse{
+		Events:  events,
+		Total:   total,
+		HasMore: (offset + len(events)) < total,
+	}, nil
+}
+
+func (us *UserService) recordSecurityEvent(userID int, eventType string, client ClientInfo, details string) {
+	event := SecurityEvent{
+		UserID:    userID,
+		EventType: eventType,
+	}
+	if client.IP != "" {
+		event.IPAddress = &client.IP
+	}
+	if client.UserAgent != "" {
+		event.UserAgent = &client.UserAgent
+	}
+	if details != "" {
+		event.Details = &details
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return &claims, nil
+	if err := us.repo.RecordSecurityEvent(event); err != nil {
+		us.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userID,
+			"event_type": eventType,
+		}).Warn("Failed to record security event")
 	}
+}
+
+func (us *UserService) generateToken(user *User, sessionID string) (string, error) {
+	now := time.Now()
+
+	claims := &jwtauth.Claims{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.IsEmailVerified(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(us.cfg.AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	return us.cfg.TokenKeys.Sign(claims)
+}
 
-	return nil, fmt.Errorf("invalid token")
+func (us *UserService) ValidateToken(tokenString string) (*jwtauth.Claims, error) {
+	return us.cfg.TokenKeys.Parse(tokenString)
 }
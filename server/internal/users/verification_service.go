@@ -0,0 +1,89 @@
+package users
+
+import (
+	"fmt"
+	"time"
+
+	"onlineChat/pkg/mailer"
+)
+
+func (us *UserService) sendEmailVerification(user *User) {
+	token, tokenHash, err := generateOpaqueToken(32)
+	if err != nil {
+		us.logger.WithError(err).Error("Failed to generate email verification token")
+		return
+	}
+
+	if err := us.repo.CreateEmailVerificationToken(user.ID, tokenHash, time.Now().Add(us.cfg.EmailVerificationTTL)); err != nil {
+		return
+	}
+
+	msg, err := mailer.NewMessage(user.Email, "email_verification", map[string]interface{}{
+		"Username":  user.Username,
+		"Link":      us.appLink("/verify-email", token),
+		"ExpiresIn": humanDuration(us.cfg.EmailVerificationTTL),
+	})
+	if err != nil {
+		us.logger.WithError(err).Error("Failed to render email verification mail")
+		return
+	}
+
+	if err := us.mailer.Send(msg); err != nil {
+		us.logger.WithError(err).WithField("user_id", user.ID).Error("Failed to send email verification mail")
+		return
+	}
+
+	us.logger.WithField("user_id", user.ID).Info("Email verification mail sent")
+}
+
+// VerifyEmail marks the address of the user a verification token was sent to
+// as verified. Tokens issued before verification keep their old claims until
+// they are refreshed.
+func (us *UserService) VerifyEmail(token string, client ClientInfo) (*UserResponse, error) {
+	userID, err := us.repo.ConsumeEmailVerificationToken(hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired verification token")
+	}
+
+	if err := us.repo.MarkEmailVerified(userID); err != nil {
+		return nil, err
+	}
+
+	user, err := us.repo.GetByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired verification token")
+	}
+
+	us.recordSecurityEvent(user.ID, EventEmailVerified, client, "")
+
+	us.logger.WithField("user_id", user.ID).Info("Email verified")
+
+	response := user.ToResponse()
+	return &response, nil
+}
+
+// ResendEmailVerification sends a new verification link to email if it
+// belongs to an unverified account. Like ForgotPassword it does not reveal
+// whether the account exists.
+func (us *UserService) ResendEmailVerification(email string) {
+	address := normalizeEmail(email)
+
+	allowed, err := us.redis.Throttle("email_verification:"+address, us.cfg.MailThrottle)
+	if err != nil {
+		us.logger.WithError(err).Error("Failed to check email verification throttle")
+		return
+	}
+	if !allowed {
+		us.logger.WithField("email", address).Info("Email verification request throttled")
+		return
+	}
+
+	go func() {
+		user, err := us.repo.GetByEmail(address)
+		if err != nil || user.IsEmailVerified() {
+			return
+		}
+
+		us.sendEmailVerification(user)
+	}()
+}
@@ -0,0 +1,592 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// chatExportBatchSize is how many messages are read from the database at
+// once while writing an export.
+const chatExportBatchSize = 1000
+
+// ChatExportContentTypes maps each export format to its Content-Type.
+var ChatExportContentTypes = map[string]string{
+	"json": "application/json; charset=utf-8",
+	"csv":  "text/csv; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+	"txt":  "text/plain; charset=utf-8",
+}
+
+// checkExportAccess allows owners and admins of chatID to export it.
+func (s *chatService) checkExportAccess(chatID, userID int) error {
+	role, err := s.repo.GetUserRoleInChat(userID, chatID)
+	if err != nil && err.Error() != "user not found in chat" {
+		return fmt.Errorf("failed to get user role: %w", err)
+	}
+
+	if role != "owner" && role != "admin" {
+		return fmt.Errorf("insufficient permissions")
+	}
+
+	return nil
+}
+
+// RequestChatExport checks that userID may export chatID. Exports of up to
+// ExportSyncLimit messages return nil and are written with WriteChatExport
+// straight away; larger ones are queued and returned. Asking again while the
+// same export runs returns it rather than queueing another.
+func (s *chatService) RequestChatExport(chatID, userID int, req ChatExportRequest) (*ChatExport, error) {
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		return nil, fmt.Errorf("invalid time range")
+	}
+
+	if err := s.checkExportAccess(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	count, err := s.repo.CountExportMessages(chatID, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	if count <= s.cfg.ExportSyncLimit {
+		return nil, nil
+	}
+
+	export, err := s.repo.CreateChatExport(&ChatExport{
+		ChatID: chatID,
+		UserID: userID,
+		Format: req.Format,
+		From:   req.From,
+		To:     req.To,
+	})
+	if err != nil {
+		if err.Error() == "an export is already in progress" {
+			return s.sameUnfinishedExport(chatID, userID, req, err)
+		}
+		return nil, err
+	}
+
+	go s.runChatExport(*export)
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"chat_id":   chatID,
+		"export_id": export.ID,
+		"messages":  count,
+	}).Info("Chat export queued")
+
+	return export, nil
+}
+
+// WriteChatExport writes the messages of chatID in the requested format and
+// time range to w.
+func (s *chatService) WriteChatExport(chatID int, req ChatExportRequest, w io.Writer) error {
+	_, err := s.writeChatExport(chatID, req, w)
+	return err
+}
+
+// GetChatExport returns an export of chatID requested by userID, as long as
+// they may still export the chat.
+func (s *chatService) GetChatExport(chatID, userID, exportID int) (*ChatExport, error) {
+	if err := s.checkExportAccess(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	export, err := s.repo.GetChatExport(exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export.ChatID != chatID || export.UserID != userID {
+		return nil, fmt.Errorf("export not found")
+	}
+
+	attachChatExportURL(export)
+	return export, nil
+}
+
+// OpenChatExport returns the path on disk of a ready export.
+func (s *chatService) OpenChatExport(chatID, userID, exportID int) (string, error) {
+	export, err := s.GetChatExport(chatID, userID, exportID)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case export.Status == ChatExportExpired,
+		export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt):
+		return "", fmt.Errorf("export has expired")
+	case export.Status != ChatExportReady || export.FileName == nil:
+		return "", fmt.Errorf("export is not ready")
+	}
+
+	return filepath.Join(s.cfg.ExportDir, *export.FileName), nil
+}
+
+// sameUnfinishedExport returns the export of chatID that userID already has
+// running when it matches req, so repeating a request does not fail. A
+// running export of anything else is reported as conflictErr.
+func (s *chatService) sameUnfinishedExport(chatID, userID int, req ChatExportRequest, conflictErr error) (*ChatExport, error) {
+	existing, err := s.repo.GetUnfinishedChatExport(chatID, userID)
+	if err != nil {
+		if err.Error() == "export not found" {
+			// It finished in the meantime.
+			return nil, conflictErr
+		}
+		return nil, err
+	}
+
+	if existing.Format != req.Format || !sameExportBound(existing.From, req.From) || !sameExportBound(existing.To, req.To) {
+		return nil, conflictErr
+	}
+
+	return existing, nil
+}
+
+// sameExportBound compares time range bounds at the microsecond precision
+// they are stored with.
+func sameExportBound(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
+
+// RunChatExportWorker restarts exports interrupted by a shutdown, then
+// removes expired files every interval. It does not return.
+func (s *chatService) RunChatExportWorker(interval time.Duration) {
+	unfinished, err := s.repo.GetUnfinishedChatExports()
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to resume chat exports")
+	}
+	for _, export := range unfinished {
+		go s.runChatExport(export)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.expireChatExports()
+	}
+}
+
+func (s *chatService) expireChatExports() {
+	expired, err := s.repo.GetExpiredChatExports(time.Now())
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to get expired chat exports")
+		return
+	}
+
+	for _, export := range expired {
+		if export.FileName != nil {
+			path := filepath.Join(s.cfg.ExportDir, *export.FileName)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				s.logger.WithError(err).WithField("export_id", export.ID).Warn("Failed to remove expired chat export")
+				continue
+			}
+		}
+
+		if err := s.repo.ExpireChatExport(export.ID); err != nil {
+			continue
+		}
+
+		s.logger.WithField("export_id", export.ID).Info("Chat export expired")
+	}
+}
+
+func (s *chatService) runChatExport(export ChatExport) {
+	fields := logrus.Fields{
+		"user_id":   export.UserID,
+		"chat_id":   export.ChatID,
+		"export_id": export.ID,
+	}
+
+	if err := s.repo.StartChatExport(export.ID); err != nil {
+		return
+	}
+
+	req := ChatExportRequest{Format: export.Format, From: export.From, To: export.To}
+	fileName, size, count, err := s.buildChatExport(export, req)
+
+	var done *ChatExport
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).Error("Failed to build chat export")
+		done, err = s.repo.FailChatExport(export.ID, "The export could not be created. Please try again.")
+	} else {
+		done, err = s.repo.CompleteChatExport(export.ID, fileName, size, count, time.Now().Add(s.cfg.ExportTTL))
+		s.logger.WithFields(fields).WithField("size_bytes", size).Info("Chat export ready")
+	}
+	if err != nil {
+		return
+	}
+
+	attachChatExportURL(done)
+	if err := s.redis.PublishUserEvent(export.UserID, ChatExportEvent{Type: EventChatExportReady, Export: done}); err != nil {
+		s.logger.WithError(err).WithFields(fields).Warn("Failed to publish chat export event")
+	}
+}
+
+// buildChatExport writes the export under a temporary name and renames it
+// once complete, so a partial file is never offered for download.
+func (s *chatService) buildChatExport(export ChatExport, req ChatExportRequest) (string, int64, int, error) {
+	if err := os.MkdirAll(s.cfg.ExportDir, 0o700); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to generate export name: %w", err)
+	}
+	name := fmt.Sprintf("%d-%d-%s.%s", export.ChatID, export.ID, hex.EncodeToString(b), export.Format)
+	path := filepath.Join(s.cfg.ExportDir, name)
+
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	count, err := s.writeChatExport(export.ChatID, req, file)
+	if err != nil {
+		file.Close()
+		os.Remove(path + ".tmp")
+		return "", 0, 0, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return "", 0, 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	info, err := os.Stat(path + ".tmp")
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return "", 0, 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return "", 0, 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	return name, info.Size(), count, nil
+}
+
+func attachChatExportURL(export *ChatExport) {
+	if export.Status == ChatExportReady {
+		export.DownloadURL = fmt.Sprintf("/chats/%d/exports/%d/download", export.ChatID, export.ID)
+	}
+}
+
+// ChatExportFileName is the name offered for downloading an export of chatID.
+func ChatExportFileName(chatID int, format string) string {
+	return fmt.Sprintf("chat-%d-%s.%s", chatID, time.Now().UTC().Format("20060102"), format)
+}
+
+// chatExportHeader describes the chat at the top of every format.
+type chatExportHeader struct {
+	Chat       ChatResponse `json:"chat"`
+	ExportedAt time.Time    `json:"exported_at"`
+	From       *time.Time   `json:"from,omitempty"`
+	To         *time.Time   `json:"to,omitempty"`
+}
+
+// writeChatExport streams the export to w batch by batch and returns the
+// number of messages written.
+func (s *chatService) writeChatExport(chatID int, req ChatExportRequest, w io.Writer) (int, error) {
+	chat, err := s.repo.GetChatByID(chatID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	header := chatExportHeader{
+		Chat:       chat.ToResponse(),
+		ExportedAt: time.Now(),
+		From:       req.From,
+		To:         req.To,
+	}
+
+	out := bufio.NewWriter(w)
+	var writer chatExportWriter
+	switch req.Format {
+	case "csv":
+		writer = &csvChatExport{out: csv.NewWriter(out)}
+	case "html":
+		writer = &htmlChatExport{out: out}
+	case "txt":
+		writer = &textChatExport{out: out}
+	default:
+		writer = &jsonChatExport{out: out}
+	}
+
+	if err := writer.header(header); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	afterID := 0
+	for {
+		messages, err := s.repo.GetExportMessages(chatID, req.From, req.To, afterID, chatExportBatchSize)
+		if err != nil {
+			return count, err
+		}
+
+		for _, message := range messages {
+			if err := writer.message(message); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if len(messages) < chatExportBatchSize {
+			break
+		}
+		afterID = messages[len(messages)-1].ID
+	}
+
+	if err := writer.footer(); err != nil {
+		return count, err
+	}
+	if err := out.Flush(); err != nil {
+		return count, fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return count, nil
+}
+
+type chatExportWriter interface {
+	header(header chatExportHeader) error
+	message(message ChatExportMessage) error
+	footer() error
+}
+
+// jsonChatExport writes the header object with the messages as its last
+// field, one per line.
+type jsonChatExport struct {
+	out   io.Writer
+	count int
+}
+
+func (e *jsonChatExport) header(header chatExportHeader) error {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to marshal export header: %w", err)
+	}
+
+	// Reopen the header object to append the messages array to it.
+	data = append(data[:len(data)-1], `,"messages":[`...)
+	if _, err := e.out.Write(data); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+func (e *jsonChatExport) message(message ChatExportMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	separator := ",\n"
+	if e.count == 0 {
+		separator = "\n"
+	}
+	e.count++
+
+	if _, err := io.WriteString(e.out, separator); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if _, err := e.out.Write(data); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+func (e *jsonChatExport) footer() error {
+	if _, err := io.WriteString(e.out, "\n]}\n"); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+// csvChatExport writes one row per message under a header row. The chat
+// itself is not described, as CSV has no place for it.
+type csvChatExport struct {
+	out *csv.Writer
+}
+
+func (e *csvChatExport) header(chatExportHeader) error {
+	return e.write([]string{
+		"id", "created_at", "user_id", "username", "message_type", "content",
+		"reply_to_id", "reply_to_username", "edited_at",
+	})
+}
+
+func (e *csvChatExport) message(message ChatExportMessage) error {
+	replyToID, replyTo, editedAt := "", "", ""
+	if message.ReplyToID != nil {
+		replyToID = strconv.Itoa(*message.ReplyToID)
+	}
+	if message.ReplyToUsername != nil {
+		replyTo = *message.ReplyToUsername
+	}
+	if message.EditedAt != nil {
+		editedAt = message.EditedAt.UTC().Format(time.RFC3339)
+	}
+
+	return e.write([]string{
+		strconv.Itoa(message.ID),
+		message.CreatedAt.UTC().Format(time.RFC3339),
+		strconv.Itoa(message.UserID),
+		message.Username,
+		message.MessageType,
+		message.Content,
+		replyToID,
+		replyTo,
+		editedAt,
+	})
+}
+
+func (e *csvChatExport) footer() error {
+	e.out.Flush()
+	if err := e.out.Error(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+func (e *csvChatExport) write(record []string) error {
+	if err := e.out.Write(record); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+// textChatExport writes a transcript with one message per entry.
+type textChatExport struct {
+	out io.Writer
+}
+
+func (e *textChatExport) header(header chatExportHeader) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Chat: %s\n", header.Chat.Name)
+	fmt.Fprintf(&b, "Exported: %s\n", formatExportTime(header.ExportedAt))
+	if header.From != nil {
+		fmt.Fprintf(&b, "From: %s\n", formatExportTime(*header.From))
+	}
+	if header.To != nil {
+		fmt.Fprintf(&b, "To: %s\n", formatExportTime(*header.To))
+	}
+	b.WriteString("\n")
+
+	if _, err := io.WriteString(e.out, b.String()); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+func (e *textChatExport) message(message ChatExportMessage) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", formatExportTime(message.CreatedAt), message.Username)
+	if message.ReplyToUsername != nil {
+		fmt.Fprintf(&b, " (reply to %s)", *message.ReplyToUsername)
+	}
+	b.WriteString(": ")
+	// Indent continuation lines so every entry starts with a timestamp.
+	b.WriteString(strings.ReplaceAll(message.Content, "\n", "\n    "))
+	if message.EditedAt != nil {
+		b.WriteString(" (edited)")
+	}
+	b.WriteString("\n")
+
+	if _, err := io.WriteString(e.out, b.String()); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+func (e *textChatExport) footer() error {
+	return nil
+}
+
+// htmlChatExport renders a standalone page to read the history in a
+// browser.
+type htmlChatExport struct {
+	out io.Writer
+}
+
+func (e *htmlChatExport) header(header chatExportHeader) error {
+	return e.render("header", header)
+}
+
+func (e *htmlChatExport) message(message ChatExportMessage) error {
+	return e.render("message", message)
+}
+
+func (e *htmlChatExport) footer() error {
+	return e.render("footer", nil)
+}
+
+func (e *htmlChatExport) render(name string, data interface{}) error {
+	if err := chatExportViewer.ExecuteTemplate(e.out, name, data); err != nil {
+		return fmt.Errorf("failed to render export: %w", err)
+	}
+
+	return nil
+}
+
+func formatExportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+var chatExportViewer = template.Must(template.New("viewer").Funcs(template.FuncMap{
+	"time": formatExportTime,
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Chat.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2rem auto; max-width: 60rem; color: #222; }
+.message { border-bottom: 1px solid #ddd; padding: .5rem 0; }
+.meta { color: #666; font-size: .85rem; }
+.reply { color: #666; font-size: .85rem; font-style: italic; }
+.content { white-space: pre-wrap; word-break: break-word; }
+</style>
+</head>
+<body>
+<h1>{{.Chat.Name}}</h1>
+{{with .Chat.Description}}<p>{{.}}</p>{{end}}
+<p class="meta">Exported {{time .ExportedAt}}{{with .From}}, from {{time .}}{{end}}{{with .To}}, to {{time .}}{{end}}.</p>
+{{end}}
+
+{{define "message"}}<div class="message" id="m{{.ID}}">
+<div class="meta"><strong>{{.Username}}</strong> {{time .CreatedAt}}{{if .EditedAt}} (edited){{end}}</div>
+{{if .ReplyToID}}<div class="reply"><a href="#m{{.ReplyToID}}">In reply to {{with .ReplyToUsername}}{{.}}{{else}}a message{{end}}</a></div>{{end}}
+<div class="content">{{.Content}}</div>
+</div>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+`))
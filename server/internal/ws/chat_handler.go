@@ -2,9 +2,12 @@ package ws
 
 import (
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"onlineChat/pkg/jwtauth"
 	"onlineChat/pkg/utils"
 
 	"github.com/gin-gonic/gin"
@@ -50,6 +53,8 @@ func (h *Handler) ServeWS(c *gin.Context) {
 		return
 	}
 
+	sessionID, _ := utils.GetSessionID(c)
+
 	chatIDStr := c.Param("chatID")
 	chatID, err := strconv.Atoi(chatIDStr)
 	if err != nil {
@@ -85,6 +90,30 @@ func (h *Handler) ServeWS(c *gin.Context) {
 		return
 	}
 
+	role, err := h.service.GetUserRole(userID, chatID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to get user role")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify chat membership"})
+		return
+	}
+
+	chat, err := h.service.GetChatByID(chatID)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get chat")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify chat membership"})
+		return
+	}
+
+	blocked, err := h.service.GetBlockedUserIDs(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get blocked users")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load blocked users"})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to upgrade connection to WebSocket")
@@ -96,11 +125,19 @@ func (h *Handler) ServeWS(c *gin.Context) {
 		ID:         userID,
 		Username:   username,
 		ChatID:     chatID,
+		Role:       role,
+		SessionID:  sessionID,
+		IsBot:      utils.IsBot(c),
+		CanSend:    utils.HasScope(c, jwtauth.ScopeMessagesWrite),
+		IsDirect:   chat.IsDirect,
 		Connection: conn,
 		Send:       make(chan []byte, 256),
 		Hub:        h.hub,
 		LastPing:   time.Now(),
+		ConnID:     newConnectionID(),
 	}
+	client.setBlocked(blocked)
+	client.markActive()
 
 	h.hub.register <- client
 
@@ -114,6 +151,57 @@ func (h *Handler) ServeWS(c *gin.Context) {
 	}).Info("WebSocket connection established")
 }
 
+// ServeUserWS opens a socket that is not tied to a chat. It receives events
+// addressed to the user, like contact requests, and keeps the user online.
+func (h *Handler) ServeUserWS(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	username, err := utils.GetUsername(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get username from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sessionID, _ := utils.GetSessionID(c)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to upgrade connection to WebSocket")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to establish WebSocket connection"})
+		return
+	}
+
+	client := &Client{
+		ID:         userID,
+		Username:   username,
+		SessionID:  sessionID,
+		IsBot:      utils.IsBot(c),
+		CanSend:    utils.HasScope(c, jwtauth.ScopeMessagesWrite),
+		Connection: conn,
+		Send:       make(chan []byte, 256),
+		Hub:        h.hub,
+		LastPing:   time.Now(),
+		ConnID:     newConnectionID(),
+	}
+	client.markActive()
+
+	h.hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"username": username,
+	}).Info("User WebSocket connection established")
+}
+
 func (h *Handler) CreateChat(c *gin.Context) {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
@@ -145,6 +233,54 @@ func (h *Handler) CreateChat(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"chat": chat})
 }
 
+// CreateDirectChat opens the direct chat with another user, creating it on
+// first use.
+func (h *Handler) CreateDirectChat(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req DirectChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	chat, created, err := h.service.CreateDirectChat(userID, req.UserID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userID,
+			"other_id": req.UserID,
+		}).Warn("Failed to open direct chat")
+
+		statusCode := http.StatusBadRequest
+		switch {
+		case err.Error() == "user not found":
+			statusCode = http.StatusNotFound
+		case err.Error() == "cannot message this user":
+			statusCode = http.StatusForbidden
+		case strings.HasPrefix(err.Error(), "failed to"):
+			statusCode = http.StatusInternalServerError
+		}
+
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	statusCode := http.StatusOK
+	if created {
+		statusCode = http.StatusCreated
+	}
+
+	c.JSON(statusCode, gin.H{"chat": chat})
+}
+
 func (h *Handler) GetAllChats(c *gin.Context) {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
@@ -234,6 +370,53 @@ func (h *Handler) LeaveChat(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully left chat"})
 }
 
+func (h *Handler) SetSlowMode(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatIDStr := c.Param("chatID")
+	chatID, err := strconv.Atoi(chatIDStr)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatIDStr).Error("Invalid chat ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	var req SlowModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logger.WithError(err).Error("Invalid slow mode request")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	chat, err := h.service.SetSlowMode(chatID, userID, req.Seconds)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to set slow mode")
+
+		statusCode := http.StatusInternalServerError
+		if err.Error() == "insufficient permissions" {
+			statusCode = http.StatusForbidden
+		}
+
+		c.JSON(statusCode, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.hub.SetSlowMode(chatID, chat.SlowModeSeconds)
+
+	c.JSON(http.StatusOK, gin.H{"chat": chat})
+}
+
 func (h *Handler) SearchPublicChats(c *gin.Context) {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
@@ -318,7 +501,7 @@ func (h *Handler) GetChatMessages(c *gin.Context) {
 		offset = 0
 	}
 
-	messages, err := h.service.GetMessages(chatID, limit, offset)
+	messages, err := h.service.GetMessages(chatID, userID, limit, offset)
 	if err != nil {
 		h.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get messages")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get messages"})
@@ -382,3 +565,497 @@ func (h *Handler) GetClientsByChatID(c *gin.Context) {
 		"count":   len(clientResponses),
 	})
 }
+
+// ExportChat streams the chat history in the requested format. Exports too
+// large to stream are queued instead and answered with 202 and the export to
+// poll.
+func (h *Handler) ExportChat(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatIDStr := c.Param("chatID")
+	chatID, err := strconv.Atoi(chatIDStr)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatIDStr).Error("Invalid chat ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	var req ChatExportRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+	if req.Format == "" {
+		req.Format = "json"
+	}
+
+	export, err := h.service.RequestChatExport(chatID, userID, req)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Warn("Failed to export chat")
+		c.JSON(chatExportStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if export != nil {
+		c.JSON(http.StatusAccepted, gin.H{"export": export})
+		return
+	}
+
+	c.Header("Content-Type", ChatExportContentTypes[req.Format])
+	c.Header("Content-Disposition", `attachment; filename="`+ChatExportFileName(chatID, req.Format)+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the download
+	// short.
+	if err := h.service.WriteChatExport(chatID, req, c.Writer); err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to write chat export")
+	}
+}
+
+func (h *Handler) GetChatExport(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	exportID, err := strconv.Atoi(c.Param("exportID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	export, err := h.service.GetChatExport(chatID, userID, exportID)
+	if err != nil {
+		c.JSON(chatExportStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"export": export})
+}
+
+func (h *Handler) DownloadChatExport(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	exportID, err := strconv.Atoi(c.Param("exportID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	path, err := h.service.OpenChatExport(chatID, userID, exportID)
+	if err != nil {
+		c.JSON(chatExportStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.FileAttachment(path, ChatExportFileName(chatID, strings.TrimPrefix(filepath.Ext(path), ".")))
+}
+
+func chatExportStatusCode(err error) int {
+	switch msg := err.Error(); {
+	case msg == "insufficient permissions":
+		return http.StatusForbidden
+	case msg == "export not found":
+		return http.StatusNotFound
+	case msg == "an export is already in progress", msg == "export is not ready":
+		return http.StatusConflict
+	case msg == "export has expired":
+		return http.StatusGone
+	case strings.HasPrefix(msg, "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (h *Handler) GetPinnedMessages(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	members, err := h.service.GetChatMembers(chatID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to get chat members")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify chat membership"})
+		return
+	}
+
+	isMember := false
+	for _, memberID := range members {
+		if memberID == userID {
+			isMember = true
+			break
+		}
+	}
+
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	pins, err := h.service.GetPinnedMessages(chatID, userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get pinned messages")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pinned messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pins)
+}
+
+func (h *Handler) PinMessage(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	pin, err := h.service.PinMessage(chatID, messageID, userID)
+	if err != nil {
+		c.JSON(pinStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.hub.BroadcastPinEvent(PinEvent{
+		Type:      EventPinned,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+		Pin:       pin,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"pin": pin})
+}
+
+func (h *Handler) UnpinMessage(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	if err := h.service.UnpinMessage(chatID, messageID, userID); err != nil {
+		c.JSON(pinStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.hub.BroadcastPinEvent(PinEvent{
+		Type:      EventUnpinned,
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message unpinned"})
+}
+
+func pinStatusCode(err error) int {
+	switch msg := err.Error(); {
+	case msg == "insufficient permissions":
+		return http.StatusForbidden
+	case msg == "chat not found", msg == "message not found", msg == "message not pinned":
+		return http.StatusNotFound
+	case msg == "message already pinned", msg == "pin limit reached":
+		return http.StatusConflict
+	case strings.HasPrefix(msg, "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (h *Handler) GetMentions(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	unreadOnly := c.Query("unread") == "true"
+
+	mentions, err := h.service.GetMentions(userID, unreadOnly, limit, offset)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get mentions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mentions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mentions)
+}
+
+func (h *Handler) GetMentionCounts(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	counts, err := h.service.GetMentionCounts(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get mention counts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get mention counts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+func (h *Handler) MarkMentionsRead(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	marked, err := h.service.MarkMentionsRead(userID, chatID)
+	if err != nil {
+		if err.Error() == "user not found in chat" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to mark mentions as read")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark mentions as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+func (h *Handler) GetNotifications(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	unreadOnly := c.Query("unread") == "true"
+
+	notifications, err := h.service.GetNotifications(userID, unreadOnly, limit, offset)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to get notifications")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+func (h *Handler) GetNotificationCount(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	unread, err := h.service.GetUnreadNotificationCount(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to count notifications")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+func (h *Handler) MarkNotificationsRead(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Without a body every notification is marked as read.
+	var req NotificationReadRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request data",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	marked, unread, err := h.service.MarkNotificationsRead(userID, req)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to mark notifications as read")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked, "unread": unread})
+}
+
+func (h *Handler) GetChatNotificationSettings(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	settings, err := h.service.GetChatNotificationSettings(userID, chatID)
+	if err != nil {
+		c.JSON(notificationSettingsStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+func (h *Handler) UpdateChatNotificationSettings(c *gin.Context) {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get user ID from token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.Atoi(c.Param("chatID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	var req ChatNotificationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request data",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	settings, err := h.service.UpdateChatNotificationSettings(userID, chatID, req)
+	if err != nil {
+		c.JSON(notificationSettingsStatusCode(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+func notificationSettingsStatusCode(err error) int {
+	switch msg := err.Error(); {
+	case msg == "user not found in chat":
+		return http.StatusForbidden
+	case strings.HasPrefix(msg, "failed to"):
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
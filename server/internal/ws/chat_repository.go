@@ -2,6 +2,7 @@ package ws
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -14,13 +15,52 @@ type ChatRepository interface {
 	GetUserChats(userID int, limit, offset int) ([]Chat, int, error)
 	SearchPublicChats(userID int, searchTerm string, limit, offset int) ([]Chat, int, error)
 	UpdateChat(chatID int, req ChatRequest) (*Chat, error)
+	UpdateSlowMode(chatID int, seconds int) (*Chat, error)
 	DeleteChat(chatID int) error
 	AddUserToChat(userID, chatID int, role string) error
 	RemoveUserFromChat(userID, chatID int) error
 	GetChatMembers(chatID int) ([]int, error)
 	GetUserRoleInChat(userID, chatID int) (string, error)
-	SaveMessage(message *Message) error
-	GetMessages(chatID int, limit, offset int) ([]Message, int, error)
+	SaveMessage(message *Message, mentions []MessageMention) error
+	GetMessages(chatID, viewerID int, limit, offset int) ([]Message, int, error)
+	GetDirectChat(userID, otherID int) (*Chat, error)
+	UserExists(userID int) (bool, error)
+	IsBlockedBetween(userID, otherID int) (bool, error)
+	IsBlockedInChat(chatID, userID int) (bool, error)
+	GetBlockedUserIDs(userID int) ([]int, error)
+	GetUserChatIDs(userID int) ([]int, error)
+	UpdateLastSeen(userID int) error
+	GetPrivacySettings(userID int) (string, string, error)
+	AreContacts(userID, otherID int) (bool, error)
+	GetContactIDs(userID int) ([]int, error)
+	CountExportMessages(chatID int, from, to *time.Time) (int, error)
+	GetExportMessages(chatID int, from, to *time.Time, afterID, limit int) ([]ChatExportMessage, error)
+	CreateChatExport(export *ChatExport) (*ChatExport, error)
+	GetChatExport(id int) (*ChatExport, error)
+	GetUnfinishedChatExports() ([]ChatExport, error)
+	GetExpiredChatExports(now time.Time) ([]ChatExport, error)
+	StartChatExport(id int) error
+	CompleteChatExport(id int, fileName string, size int64, count int, expiresAt time.Time) (*ChatExport, error)
+	FailChatExport(id int, reason string) (*ChatExport, error)
+	ExpireChatExport(id int) error
+	PinMessage(chatID, messageID, userID, limit int) error
+	UnpinMessage(chatID, messageID int) error
+	GetPinnedMessage(chatID, messageID, viewerID int) (*PinnedMessage, error)
+	GetPinnedMessages(chatID, viewerID int) ([]PinnedMessage, error)
+	GetMentionTargets(chatID int, usernames []string, everyone bool) ([]MentionTarget, error)
+	GetMentions(userID int, unreadOnly bool, limit, offset int) ([]Mention, int, error)
+	GetMentionCounts(userID int) ([]MentionCount, error)
+	MarkMentionsRead(userID, chatID int) (int, error)
+	CreateMessageNotifications(message *Message, mentionIDs, connectedIDs []int) ([]Notification, error)
+	CreateNotification(notification *Notification) error
+	GetNotifications(userID int, unreadOnly bool, limit, offset int) ([]Notification, int, error)
+	CountUnreadNotifications(userIDs []int) (map[int]int, error)
+	MarkNotificationsRead(userID int, ids []int, chatID int) (int, error)
+	GetChatNotificationSettings(userID, chatID int) (*ChatNotificationSettings, error)
+	UpdateChatNotificationSettings(userID int, settings ChatNotificationSettings) (*ChatNotificationSettings, error)
+	CreateDirectChat(chat *Chat, userID, otherID int) (*Chat, bool, error)
+	IsDirectMessageRefused(chatID, userID int) (bool, error)
+	GetUnfinishedChatExport(chatID, userID int) (*ChatExport, error)
 }
 
 type chatRepository struct {
@@ -35,18 +75,18 @@ func NewChatRepository(db *sql.DB, logger *logrus.Logger) ChatRepository {
 	}
 }
 
-func (r *chatRepository) CreateChat(chat *Chat) (*Chat, error) {
-	query := `
-		INSERT INTO chats (name, description, created_by, created_at, updated_at, 
-		                  is_private, is_active, max_members, current_members)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, created_at, updated_at
-	`
+const createChatQuery = `
+	INSERT INTO chats (name, description, created_by, created_at, updated_at, 
+	                  is_private, is_active, max_members, current_members, is_direct)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING id, created_at, updated_at
+`
 
+func (r *chatRepository) CreateChat(chat *Chat) (*Chat, error) {
 	now := time.Now()
-	row := r.db.QueryRow(query,
+	row := r.db.QueryRow(createChatQuery,
 		chat.Name, chat.Description, chat.CreatedBy, now, now,
-		chat.IsPrivate, chat.IsActive, chat.MaxMembers, chat.CurrentMembers,
+		chat.IsPrivate, chat.IsActive, chat.MaxMembers, chat.CurrentMembers, chat.IsDirect,
 	)
 
 	err := row.Scan(&chat.ID, &chat.CreatedAt, &chat.UpdatedAt)
@@ -61,7 +101,8 @@ func (r *chatRepository) CreateChat(chat *Chat) (*Chat, error) {
 func (r *chatRepository) GetChatByID(chatID int) (*Chat, error) {
 	query := `
 		SELECT id, name, description, created_by, created_at, updated_at,
-		       is_private, is_active, max_members, current_members
+		       is_private, is_active, max_members, current_members, slow_mode_seconds,
+		       is_direct
 		FROM chats
 		WHERE id = $1 AND is_active = true
 	`
@@ -72,7 +113,7 @@ func (r *chatRepository) GetChatByID(chatID int) (*Chat, error) {
 	err := row.Scan(
 		&chat.ID, &chat.Name, &chat.Description, &chat.CreatedBy,
 		&chat.CreatedAt, &chat.UpdatedAt, &chat.IsPrivate, &chat.IsActive,
-		&chat.MaxMembers, &chat.CurrentMembers,
+		&chat.MaxMembers, &chat.CurrentMembers, &chat.SlowModeSeconds, &chat.IsDirect,
 	)
 
 	if err != nil {
@@ -103,7 +144,8 @@ func (r *chatRepository) GetUserChats(userID int, limit, offset int) ([]Chat, in
 
 	query := `
 		SELECT c.id, c.name, c.description, c.created_by, c.created_at, c.updated_at,
-		       c.is_private, c.is_active, c.max_members, c.current_members
+		       c.is_private, c.is_active, c.max_members, c.current_members, c.slow_mode_seconds,
+		       c.is_direct
 		FROM chats c
 		INNER JOIN user_chat uc ON c.id = uc.chat_id
 		WHERE uc.user_id = $1 AND c.is_active = true
@@ -124,7 +166,7 @@ func (r *chatRepository) GetUserChats(userID int, limit, offset int) ([]Chat, in
 		err := rows.Scan(
 			&chat.ID, &chat.Name, &chat.Description, &chat.CreatedBy,
 			&chat.CreatedAt, &chat.UpdatedAt, &chat.IsPrivate, &chat.IsActive,
-			&chat.MaxMembers, &chat.CurrentMembers,
+			&chat.MaxMembers, &chat.CurrentMembers, &chat.SlowModeSeconds, &chat.IsDirect,
 		)
 		if err != nil {
 			r.logger.WithError(err).Error("Failed to scan chat")
@@ -170,7 +212,8 @@ func (r *chatRepository) SearchPublicChats(userID int, searchTerm string, limit,
 
 	query := fmt.Sprintf(`
 		SELECT c.id, c.name, c.description, c.created_by, c.created_at, c.updated_at,
-		       c.is_private, c.is_active, c.max_members, c.current_members
+		       c.is_private, c.is_active, c.max_members, c.current_members, c.slow_mode_seconds,
+		       c.is_direct
 		FROM chats c
 		WHERE c.is_active = true 
 		AND c.is_private = false
@@ -199,7 +242,7 @@ func (r *chatRepository) SearchPublicChats(userID int, searchTerm string, limit,
 		err := rows.Scan(
 			&chat.ID, &chat.Name, &chat.Description, &chat.CreatedBy,
 			&chat.CreatedAt, &chat.UpdatedAt, &chat.IsPrivate, &chat.IsActive,
-			&chat.MaxMembers, &chat.CurrentMembers,
+			&chat.MaxMembers, &chat.CurrentMembers, &chat.SlowModeSeconds, &chat.IsDirect,
 		)
 		if err != nil {
 			r.logger.WithError(err).Error("Failed to scan chat")
@@ -217,7 +260,8 @@ func (r *chatRepository) UpdateChat(chatID int, req ChatRequest) (*Chat, error)
 		SET name = $1, description = $2, max_members = $3, updated_at = $4
 		WHERE id = $5 AND is_active = true
 		RETURNING id, name, description, created_by, created_at, updated_at,
-		          is_private, is_active, max_members, current_members
+		          is_private, is_active, max_members, current_members, slow_mode_seconds,
+		          is_direct
 	`
 
 	chat := &Chat{}
@@ -226,7 +270,7 @@ func (r *chatRepository) UpdateChat(chatID int, req ChatRequest) (*Chat, error)
 	err := row.Scan(
 		&chat.ID, &chat.Name, &chat.Description, &chat.CreatedBy,
 		&chat.CreatedAt, &chat.UpdatedAt, &chat.IsPrivate, &chat.IsActive,
-		&chat.MaxMembers, &chat.CurrentMembers,
+		&chat.MaxMembers, &chat.CurrentMembers, &chat.SlowModeSeconds, &chat.IsDirect,
 	)
 
 	if err != nil {
@@ -240,6 +284,36 @@ func (r *chatRepository) UpdateChat(chatID int, req ChatRequest) (*Chat, error)
 	return chat, nil
 }
 
+func (r *chatRepository) UpdateSlowMode(chatID int, seconds int) (*Chat, error) {
+	query := `
+		UPDATE chats 
+		SET slow_mode_seconds = $1, updated_at = $2
+		WHERE id = $3 AND is_active = true
+		RETURNING id, name, description, created_by, created_at, updated_at,
+		          is_private, is_active, max_members, current_members, slow_mode_seconds,
+		          is_direct
+	`
+
+	chat := &Chat{}
+	row := r.db.QueryRow(query, seconds, time.Now(), chatID)
+
+	err := row.Scan(
+		&chat.ID, &chat.Name, &chat.Description, &chat.CreatedBy,
+		&chat.CreatedAt, &chat.UpdatedAt, &chat.IsPrivate, &chat.IsActive,
+		&chat.MaxMembers, &chat.CurrentMembers, &chat.SlowModeSeconds, &chat.IsDirect,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("chat not found")
+		}
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to update slow mode")
+		return nil, fmt.Errorf("failed to update slow mode: %w", err)
+	}
+
+	return chat, nil
+}
+
 func (r *chatRepository) DeleteChat(chatID int) error {
 	query := `UPDATE chats SET is_active = false, updated_at = $1 WHERE id = $2`
 
@@ -261,18 +335,18 @@ func (r *chatRepository) DeleteChat(chatID int) error {
 	return nil
 }
 
-func (r *chatRepository) AddUserToChat(userID, chatID int, role string) error {
-	query := `
-		INSERT INTO user_chat (user_id, chat_id, role, joined_at, last_read_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (user_id, chat_id) DO UPDATE SET
-		role = EXCLUDED.role,
-		is_banned = false,
-		banned_until = NULL
-	`
+const addUserToChatQuery = `
+	INSERT INTO user_chat (user_id, chat_id, role, joined_at, last_read_at)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, chat_id) DO UPDATE SET
+	role = EXCLUDED.role,
+	is_banned = false,
+	banned_until = NULL
+`
 
+func (r *chatRepository) AddUserToChat(userID, chatID int, role string) error {
 	now := time.Now()
-	_, err := r.db.Exec(query, userID, chatID, role, now, now)
+	_, err := r.db.Exec(addUserToChatQuery, userID, chatID, role, now, now)
 	if err != nil {
 		r.logger.WithError(err).WithFields(logrus.Fields{
 			"user_id": userID,
@@ -358,21 +432,34 @@ func (r *chatRepository) GetUserRoleInChat(userID, chatID int) (string, error) {
 	return role, nil
 }
 
-func (r *chatRepository) SaveMessage(message *Message) error {
+// SaveMessage inserts message together with the mentions it records, if
+// any, in one transaction.
+func (r *chatRepository) SaveMessage(message *Message, mentions []MessageMention) error {
+	entities, err := encodeMentionEntities(message.Mentions)
+	if err != nil {
+		return fmt.Errorf("failed to encode mentions: %w", err)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO messages (chat_id, user_id, content, message_type, reply_to_id, 
-		                     created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		                     mentions, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id, created_at, updated_at
 	`
 
 	now := time.Now()
-	row := r.db.QueryRow(query,
+	row := tx.QueryRow(query,
 		message.ChatID, message.UserID, message.Content, message.MessageType,
-		message.ReplyToID, now, now,
+		message.ReplyToID, entities, now, now,
 	)
 
-	err := row.Scan(&message.ID, &message.CreatedAt, &message.UpdatedAt)
+	err = row.Scan(&message.ID, &message.CreatedAt, &message.UpdatedAt)
 	if err != nil {
 		r.logger.WithError(err).WithFields(logrus.Fields{
 			"user_id": message.UserID,
@@ -381,10 +468,38 @@ func (r *chatRepository) SaveMessage(message *Message) error {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 
+	if len(mentions) > 0 {
+		userIDs := make([]int, len(mentions))
+		kinds := make([]string, len(mentions))
+		for i, mention := range mentions {
+			userIDs[i] = mention.UserID
+			kinds[i] = mention.Kind
+		}
+
+		mentionQuery := `
+			INSERT INTO message_mentions (message_id, chat_id, user_id, kind, created_at)
+			SELECT $1, $2, m.user_id, m.kind, $3
+			FROM unnest($4::int[], $5::text[]) AS m(user_id, kind)
+			ON CONFLICT DO NOTHING
+		`
+
+		if _, err := tx.Exec(mentionQuery, message.ID, message.ChatID, message.CreatedAt, userIDs, kinds); err != nil {
+			r.logger.WithError(err).WithField("message_id", message.ID).Error("Failed to save mentions")
+			return fmt.Errorf("failed to save mentions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return nil
 }
 
-func (r *chatRepository) GetMessages(chatID int, limit, offset int) ([]Message, int, error) {
+// GetMessages returns a page of messages in chatID. Messages from users that
+// viewerID has blocked are flagged with SenderBlocked. Messages left by
+// deleted accounts have user ID 0.
+func (r *chatRepository) GetMessages(chatID, viewerID int, limit, offset int) ([]Message, int, error) {
 	countQuery := `
 		SELECT COUNT(*)
 		FROM messages
@@ -399,17 +514,18 @@ func (r *chatRepository) GetMessages(chatID int, limit, offset int) ([]Message,
 	}
 
 	query := `
-		SELECT m.id, m.chat_id, m.user_id, u.username, m.content, m.message_type,
-		       m.reply_to_id, m.edited_at, m.is_deleted, m.deleted_at,
-		       m.created_at, m.updated_at
+		SELECT m.id, m.chat_id, COALESCE(m.user_id, 0), COALESCE(u.username, 'Deleted user'),
+		       m.content, m.message_type, m.reply_to_id, COALESCE(u.is_bot, false), m.edited_at, m.is_deleted, m.deleted_at,
+		       m.created_at, m.updated_at, m.mentions, b.blocked_id IS NOT NULL
 		FROM messages m
 		LEFT JOIN users u ON m.user_id = u.id
+		LEFT JOIN user_blocks b ON b.blocker_id = $2 AND b.blocked_id = m.user_id
 		WHERE m.chat_id = $1 AND m.is_deleted = false
 		ORDER BY m.created_at DESC
-		LIMIT $2 OFFSET $3
+		LIMIT $3 OFFSET $4
 	`
 
-	rows, err := r.db.Query(query, chatID, limit, offset)
+	rows, err := r.db.Query(query, chatID, viewerID, limit, offset)
 	if err != nil {
 		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get messages")
 		return nil, 0, fmt.Errorf("failed to get messages: %w", err)
@@ -419,18 +535,1217 @@ func (r *chatRepository) GetMessages(chatID int, limit, offset int) ([]Message,
 	var messages []Message
 	for rows.Next() {
 		var message Message
+		var mentions []byte
 		err := rows.Scan(
 			&message.ID, &message.ChatID, &message.UserID, &message.Username,
 			&message.Content, &message.MessageType, &message.ReplyToID,
-			&message.EditedAt, &message.IsDeleted, &message.DeletedAt,
-			&message.CreatedAt, &message.UpdatedAt,
+			&message.IsBot, &message.EditedAt, &message.IsDeleted, &message.DeletedAt,
+			&message.CreatedAt, &message.UpdatedAt, &mentions, &message.SenderBlocked,
 		)
 		if err != nil {
 			r.logger.WithError(err).Error("Failed to scan message")
 			continue
 		}
+		message.Mentions = r.decodeMentionEntities(message.ID, mentions)
 		messages = append(messages, message)
 	}
 
 	return messages, total, nil
 }
+
+// directChatQuery selects the direct chat between users $1 and $2.
+const directChatQuery = `
+	SELECT c.id, c.name, c.description, c.created_by, c.created_at, c.updated_at,
+	       c.is_private, c.is_active, c.max_members, c.current_members, c.slow_mode_seconds,
+	       c.is_direct
+	FROM chats c
+	INNER JOIN user_chat a ON a.chat_id = c.id AND a.user_id = $1
+	INNER JOIN user_chat b ON b.chat_id = c.id AND b.user_id = $2
+	WHERE c.is_direct = true AND c.is_active = true
+	LIMIT 1
+`
+
+// GetDirectChat returns the direct chat between userID and otherID.
+func (r *chatRepository) GetDirectChat(userID, otherID int) (*Chat, error) {
+	return r.scanDirectChat(r.db.QueryRow(directChatQuery, userID, otherID), userID)
+}
+
+func (r *chatRepository) scanDirectChat(row rowScanner, userID int) (*Chat, error) {
+	chat := &Chat{}
+
+	err := row.Scan(
+		&chat.ID, &chat.Name, &chat.Description, &chat.CreatedBy,
+		&chat.CreatedAt, &chat.UpdatedAt, &chat.IsPrivate, &chat.IsActive,
+		&chat.MaxMembers, &chat.CurrentMembers, &chat.SlowModeSeconds, &chat.IsDirect,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("chat not found")
+		}
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get direct chat")
+		return nil, fmt.Errorf("failed to get direct chat: %w", err)
+	}
+
+	return chat, nil
+}
+
+// CreateDirectChat creates chat with userID and otherID as its members,
+// unless the two already have a direct chat, which it returns instead. The
+// second result tells whether the chat was created. The transaction holds an
+// advisory lock on the pair, so concurrent requests cannot both create one.
+func (r *chatRepository) CreateDirectChat(chat *Chat, userID, otherID int) (*Chat, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	low, high := userID, otherID
+	if low > high {
+		low, high = high, low
+	}
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1::int, $2::int)`, low, high); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to lock direct chat pair")
+		return nil, false, fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	existing, err := r.scanDirectChat(tx.QueryRow(directChatQuery, userID, otherID), userID)
+	if err == nil {
+		return existing, false, nil
+	}
+	if err.Error() != "chat not found" {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	err = tx.QueryRow(createChatQuery,
+		chat.Name, chat.Description, chat.CreatedBy, now, now,
+		chat.IsPrivate, chat.IsActive, chat.MaxMembers, chat.CurrentMembers, chat.IsDirect,
+	).Scan(&chat.ID, &chat.CreatedAt, &chat.UpdatedAt)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to create direct chat")
+		return nil, false, fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	for _, memberID := range []int{userID, otherID} {
+		if _, err := tx.Exec(addUserToChatQuery, memberID, chat.ID, "member", now, now); err != nil {
+			r.logger.WithError(err).WithFields(logrus.Fields{
+				"user_id": memberID,
+				"chat_id": chat.ID,
+			}).Error("Failed to add user to direct chat")
+			return nil, false, fmt.Errorf("failed to add user to chat: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return chat, true, nil
+}
+
+func (r *chatRepository) UserExists(userID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND is_active = true)`
+
+	var exists bool
+	if err := r.db.QueryRow(query, userID).Scan(&exists); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to check user existence")
+		return false, fmt.Errorf("failed to check user existence: %w", err)
+	}
+
+	return exists, nil
+}
+
+// IsBlockedBetween reports whether either user has blocked the other.
+func (r *chatRepository) IsBlockedBetween(userID, otherID int) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2)
+			   OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`
+
+	var blocked bool
+	if err := r.db.QueryRow(query, userID, otherID).Scan(&blocked); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to check blocks")
+		return false, fmt.Errorf("failed to check blocks: %w", err)
+	}
+
+	return blocked, nil
+}
+
+// IsBlockedInChat reports whether userID and any other member of chatID have
+// blocked each other.
+func (r *chatRepository) IsBlockedInChat(chatID, userID int) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_chat uc
+			INNER JOIN user_blocks b
+			        ON (b.blocker_id = uc.user_id AND b.blocked_id = $2)
+			        OR (b.blocker_id = $2 AND b.blocked_id = uc.user_id)
+			WHERE uc.chat_id = $1 AND uc.user_id <> $2
+		)
+	`
+
+	var blocked bool
+	if err := r.db.QueryRow(query, chatID, userID).Scan(&blocked); err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to check blocks in chat")
+		return false, fmt.Errorf("failed to check blocks: %w", err)
+	}
+
+	return blocked, nil
+}
+
+// IsDirectMessageRefused reports whether another member of chatID does not
+// accept direct messages from userID under their privacy settings.
+func (r *chatRepository) IsDirectMessageRefused(chatID, userID int) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_chat uc
+			INNER JOIN user_settings s ON s.user_id = uc.user_id
+			WHERE uc.chat_id = $1 AND uc.user_id <> $2
+			  AND (s.direct_messages = 'nobody'
+			       OR (s.direct_messages = 'contacts' AND NOT EXISTS (
+			           SELECT 1 FROM contacts c WHERE c.user_id = uc.user_id AND c.contact_id = $2
+			       )))
+		)
+	`
+
+	var refused bool
+	if err := r.db.QueryRow(query, chatID, userID).Scan(&refused); err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to check direct message settings")
+		return false, fmt.Errorf("failed to check direct message settings: %w", err)
+	}
+
+	return refused, nil
+}
+
+func (r *chatRepository) GetBlockedUserIDs(userID int) ([]int, error) {
+	query := `SELECT blocked_id FROM user_blocks WHERE blocker_id = $1`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get blocked users")
+		return nil, fmt.Errorf("failed to get blocked users: %w", err)
+	}
+	defer rows.Close()
+
+	var blocked []int
+	for rows.Next() {
+		var blockedID int
+		if err := rows.Scan(&blockedID); err != nil {
+			r.logger.WithError(err).Error("Failed to scan blocked user ID")
+			continue
+		}
+		blocked = append(blocked, blockedID)
+	}
+
+	return blocked, nil
+}
+
+// GetUserChatIDs returns the active chats userID is a member of.
+func (r *chatRepository) GetUserChatIDs(userID int) ([]int, error) {
+	query := `
+		SELECT uc.chat_id
+		FROM user_chat uc
+		INNER JOIN chats c ON c.id = uc.chat_id
+		WHERE uc.user_id = $1 AND uc.is_banned = false AND c.is_active = true
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get user chat IDs")
+		return nil, fmt.Errorf("failed to get user chats: %w", err)
+	}
+	defer rows.Close()
+
+	var chatIDs []int
+	for rows.Next() {
+		var chatID int
+		if err := rows.Scan(&chatID); err != nil {
+			r.logger.WithError(err).Error("Failed to scan chat ID")
+			continue
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	return chatIDs, nil
+}
+
+func (r *chatRepository) UpdateLastSeen(userID int) error {
+	query := `UPDATE users SET last_seen = $1 WHERE id = $2 AND is_active = true`
+
+	if _, err := r.db.Exec(query, time.Now(), userID); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to update last seen")
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+
+	return nil
+}
+
+// GetPrivacySettings returns who may start a direct chat with userID and who
+// may see their presence. Users without settings allow everyone.
+func (r *chatRepository) GetPrivacySettings(userID int) (string, string, error) {
+	query := `SELECT direct_messages, presence_visibility FROM user_settings WHERE user_id = $1`
+
+	var directMessages, presenceVisibility string
+	err := r.db.QueryRow(query, userID).Scan(&directMessages, &presenceVisibility)
+	if err == sql.ErrNoRows {
+		return "everyone", "everyone", nil
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get privacy settings")
+		return "", "", fmt.Errorf("failed to get privacy settings: %w", err)
+	}
+
+	return directMessages, presenceVisibility, nil
+}
+
+func (r *chatRepository) AreContacts(userID, otherID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM contacts WHERE user_id = $1 AND contact_id = $2)`
+
+	var exists bool
+	if err := r.db.QueryRow(query, userID, otherID).Scan(&exists); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to check contacts")
+		return false, fmt.Errorf("failed to check contacts: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r *chatRepository) GetContactIDs(userID int) ([]int, error) {
+	query := `SELECT contact_id FROM contacts WHERE user_id = $1`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get contact IDs")
+		return nil, fmt.Errorf("failed to get contacts: %w", err)
+	}
+	defer rows.Close()
+
+	var contactIDs []int
+	for rows.Next() {
+		var contactID int
+		if err := rows.Scan(&contactID); err != nil {
+			r.logger.WithError(err).Error("Failed to scan contact ID")
+			continue
+		}
+		contactIDs = append(contactIDs, contactID)
+	}
+
+	return contactIDs, nil
+}
+
+// CountExportMessages counts the messages of chatID that an export with the
+// given time range would contain.
+func (r *chatRepository) CountExportMessages(chatID int, from, to *time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM messages
+		WHERE chat_id = $1 AND is_deleted = false
+		  AND ($2::timestamptz IS NULL OR created_at >= $2)
+		  AND ($3::timestamptz IS NULL OR created_at < $3)
+	`
+
+	var count int
+	if err := r.db.QueryRow(query, chatID, from, to).Scan(&count); err != nil {
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to count messages for export")
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetExportMessages returns up to limit messages of chatID in the time range
+// with an ID above afterID, in ID order, so a whole history can be read in
+// batches.
+func (r *chatRepository) GetExportMessages(chatID int, from, to *time.Time, afterID, limit int) ([]ChatExportMessage, error) {
+	query := `
+		SELECT m.id, COALESCE(m.user_id, 0), COALESCE(u.username, 'Deleted user'), m.content,
+		       COALESCE(m.message_type, 'text'), m.reply_to_id,
+		       CASE WHEN r.id IS NULL THEN NULL ELSE COALESCE(ru.username, 'Deleted user') END,
+		       m.edited_at, m.created_at
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		LEFT JOIN messages r ON r.id = m.reply_to_id
+		LEFT JOIN users ru ON ru.id = r.user_id
+		WHERE m.chat_id = $1 AND m.is_deleted = false AND m.id > $2
+		  AND ($3::timestamptz IS NULL OR m.created_at >= $3)
+		  AND ($4::timestamptz IS NULL OR m.created_at < $4)
+		ORDER BY m.id
+		LIMIT $5
+	`
+
+	rows, err := r.db.Query(query, chatID, afterID, from, to, limit)
+	if err != nil {
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get messages for export")
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []ChatExportMessage{}
+	for rows.Next() {
+		var message ChatExportMessage
+		err := rows.Scan(
+			&message.ID, &message.UserID, &message.Username, &message.Content,
+			&message.MessageType, &message.ReplyToID, &message.ReplyToUsername,
+			&message.EditedAt, &message.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+const chatExportColumns = `id, chat_id, user_id, format, from_time, to_time, status, file_name,
+	size_bytes, message_count, error, created_at, completed_at, expires_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanChatExport(row rowScanner) (*ChatExport, error) {
+	var export ChatExport
+	err := row.Scan(
+		&export.ID,
+		&export.ChatID,
+		&export.UserID,
+		&export.Format,
+		&export.From,
+		&export.To,
+		&export.Status,
+		&export.FileName,
+		&export.SizeBytes,
+		&export.MessageCount,
+		&export.Error,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// CreateChatExport queues a background export. A user can only have one
+// unfinished export per chat at a time.
+func (r *chatRepository) CreateChatExport(export *ChatExport) (*ChatExport, error) {
+	query := `
+		INSERT INTO chat_exports (chat_id, user_id, format, from_time, to_time, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, 'pending', $6)
+		ON CONFLICT DO NOTHING
+		RETURNING ` + chatExportColumns
+
+	created, err := scanChatExport(r.db.QueryRow(query,
+		export.ChatID, export.UserID, export.Format, export.From, export.To, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("an export is already in progress")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": export.UserID,
+			"chat_id": export.ChatID,
+		}).Error("Failed to create chat export")
+		return nil, fmt.Errorf("failed to create chat export: %w", err)
+	}
+
+	return created, nil
+}
+
+func (r *chatRepository) GetChatExport(id int) (*ChatExport, error) {
+	query := `SELECT ` + chatExportColumns + ` FROM chat_exports WHERE id = $1`
+
+	export, err := scanChatExport(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("export not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to get chat export")
+		return nil, fmt.Errorf("failed to get chat export: %w", err)
+	}
+
+	return export, nil
+}
+
+// GetUnfinishedChatExports lists exports that were pending or being built,
+// oldest first. After a restart these are started again.
+// GetUnfinishedChatExport returns the pending or processing export of chatID
+// requested by userID. There is at most one.
+func (r *chatRepository) GetUnfinishedChatExport(chatID, userID int) (*ChatExport, error) {
+	query := `
+		SELECT ` + chatExportColumns + `
+		FROM chat_exports
+		WHERE chat_id = $1 AND user_id = $2 AND status IN ('pending', 'processing')
+	`
+
+	export, err := scanChatExport(r.db.QueryRow(query, chatID, userID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("export not found")
+	}
+	if err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to get unfinished chat export")
+		return nil, fmt.Errorf("failed to get chat export: %w", err)
+	}
+
+	return export, nil
+}
+
+func (r *chatRepository) GetUnfinishedChatExports() ([]ChatExport, error) {
+	query := `
+		SELECT ` + chatExportColumns + `
+		FROM chat_exports
+		WHERE status IN ('pending', 'processing')
+		ORDER BY created_at
+	`
+
+	return r.queryChatExports(query)
+}
+
+// GetExpiredChatExports lists ready exports whose download window has passed.
+func (r *chatRepository) GetExpiredChatExports(now time.Time) ([]ChatExport, error) {
+	query := `
+		SELECT ` + chatExportColumns + `
+		FROM chat_exports
+		WHERE status = 'ready' AND expires_at <= $1
+	`
+
+	return r.queryChatExports(query, now)
+}
+
+func (r *chatRepository) queryChatExports(query string, args ...interface{}) ([]ChatExport, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to get chat exports")
+		return nil, fmt.Errorf("failed to get chat exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []ChatExport{}
+	for rows.Next() {
+		export, err := scanChatExport(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan chat export")
+			continue
+		}
+		exports = append(exports, *export)
+	}
+
+	return exports, nil
+}
+
+func (r *chatRepository) StartChatExport(id int) error {
+	query := `UPDATE chat_exports SET status = 'processing' WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id); err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to start chat export")
+		return fmt.Errorf("failed to start chat export: %w", err)
+	}
+
+	return nil
+}
+
+func (r *chatRepository) CompleteChatExport(id int, fileName string, size int64, count int, expiresAt time.Time) (*ChatExport, error) {
+	query := `
+		UPDATE chat_exports
+		SET status = 'ready', file_name = $1, size_bytes = $2, message_count = $3,
+		    completed_at = $4, expires_at = $5, error = NULL
+		WHERE id = $6
+		RETURNING ` + chatExportColumns
+
+	export, err := scanChatExport(r.db.QueryRow(query, fileName, size, count, time.Now(), expiresAt, id))
+	if err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to complete chat export")
+		return nil, fmt.Errorf("failed to complete chat export: %w", err)
+	}
+
+	return export, nil
+}
+
+func (r *chatRepository) FailChatExport(id int, reason string) (*ChatExport, error) {
+	query := `
+		UPDATE chat_exports
+		SET status = 'failed', error = $1, completed_at = $2
+		WHERE id = $3
+		RETURNING ` + chatExportColumns
+
+	export, err := scanChatExport(r.db.QueryRow(query, reason, time.Now(), id))
+	if err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to mark chat export as failed")
+		return nil, fmt.Errorf("failed to update chat export: %w", err)
+	}
+
+	return export, nil
+}
+
+// ExpireChatExport marks an export expired once its file has been removed.
+func (r *chatRepository) ExpireChatExport(id int) error {
+	query := `UPDATE chat_exports SET status = 'expired', file_name = NULL WHERE id = $1`
+
+	if _, err := r.db.Exec(query, id); err != nil {
+		r.logger.WithError(err).WithField("export_id", id).Error("Failed to expire chat export")
+		return fmt.Errorf("failed to expire chat export: %w", err)
+	}
+
+	return nil
+}
+
+// PinMessage pins a message of chatID unless the chat already has limit
+// pins. The chat row is locked so concurrent pins cannot exceed the limit.
+func (r *chatRepository) PinMessage(chatID, messageID, userID, limit int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRow(`SELECT id FROM chats WHERE id = $1 AND is_active = true FOR UPDATE`, chatID).Scan(&locked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("chat not found")
+		}
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to lock chat")
+		return fmt.Errorf("failed to pin message: %w", err)
+	}
+
+	var exists bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1 AND chat_id = $2 AND is_deleted = false)
+	`, messageID, chatID).Scan(&exists)
+	if err != nil {
+		r.logger.WithError(err).WithField("message_id", messageID).Error("Failed to check message")
+		return fmt.Errorf("failed to pin message: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("message not found")
+	}
+
+	var pinned int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pinned_messages WHERE chat_id = $1`, chatID).Scan(&pinned); err != nil {
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to count pinned messages")
+		return fmt.Errorf("failed to pin message: %w", err)
+	}
+
+	query := `
+		INSERT INTO pinned_messages (message_id, chat_id, pinned_by, pinned_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (message_id) DO NOTHING
+		RETURNING message_id
+	`
+
+	// Insert before checking the limit so pinning a message that is
+	// already pinned reports that rather than a full chat.
+	var inserted int
+	err = tx.QueryRow(query, messageID, chatID, userID, time.Now()).Scan(&inserted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("message already pinned")
+		}
+		r.logger.WithError(err).WithField("message_id", messageID).Error("Failed to pin message")
+		return fmt.Errorf("failed to pin message: %w", err)
+	}
+	if pinned >= limit {
+		return fmt.Errorf("pin limit reached")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *chatRepository) UnpinMessage(chatID, messageID int) error {
+	query := `DELETE FROM pinned_messages WHERE chat_id = $1 AND message_id = $2`
+
+	result, err := r.db.Exec(query, chatID, messageID)
+	if err != nil {
+		r.logger.WithError(err).WithField("message_id", messageID).Error("Failed to unpin message")
+		return fmt.Errorf("failed to unpin message: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to unpin message: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("message not pinned")
+	}
+
+	return nil
+}
+
+const pinnedMessageQuery = `
+	SELECT p.chat_id, p.message_id, p.pinned_by, pu.username, p.pinned_at,
+	       m.id, m.chat_id, COALESCE(m.user_id, 0), COALESCE(u.username, 'Deleted user'),
+	       m.content, m.message_type, m.reply_to_id, COALESCE(u.is_bot, false), m.edited_at, m.is_deleted, m.deleted_at,
+	       m.created_at, m.updated_at, m.mentions, b.blocked_id IS NOT NULL
+	FROM pinned_messages p
+	INNER JOIN messages m ON m.id = p.message_id
+	LEFT JOIN users u ON m.user_id = u.id
+	LEFT JOIN users pu ON p.pinned_by = pu.id
+	LEFT JOIN user_blocks b ON b.blocker_id = $2 AND b.blocked_id = m.user_id
+	WHERE p.chat_id = $1 AND m.is_deleted = false
+`
+
+func (r *chatRepository) scanPinnedMessage(row rowScanner) (*PinnedMessage, error) {
+	pin := &PinnedMessage{Message: &Message{}}
+	message := pin.Message
+
+	var mentions []byte
+	err := row.Scan(
+		&pin.ChatID, &pin.MessageID, &pin.PinnedBy, &pin.PinnedByUsername, &pin.PinnedAt,
+		&message.ID, &message.ChatID, &message.UserID, &message.Username,
+		&message.Content, &message.MessageType, &message.ReplyToID,
+		&message.IsBot, &message.EditedAt, &message.IsDeleted, &message.DeletedAt,
+		&message.CreatedAt, &message.UpdatedAt, &mentions, &message.SenderBlocked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	message.Mentions = r.decodeMentionEntities(message.ID, mentions)
+
+	return pin, nil
+}
+
+func (r *chatRepository) GetPinnedMessage(chatID, messageID, viewerID int) (*PinnedMessage, error) {
+	query := pinnedMessageQuery + ` AND p.message_id = $3`
+
+	pin, err := r.scanPinnedMessage(r.db.QueryRow(query, chatID, viewerID, messageID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("message not pinned")
+		}
+		r.logger.WithError(err).WithField("message_id", messageID).Error("Failed to get pinned message")
+		return nil, fmt.Errorf("failed to get pinned message: %w", err)
+	}
+
+	return pin, nil
+}
+
+// GetPinnedMessages returns the pins of chatID, most recent first, with
+// their messages flagged for viewerID as in GetMessages.
+func (r *chatRepository) GetPinnedMessages(chatID, viewerID int) ([]PinnedMessage, error) {
+	query := pinnedMessageQuery + ` ORDER BY p.pinned_at DESC`
+
+	rows, err := r.db.Query(query, chatID, viewerID)
+	if err != nil {
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get pinned messages")
+		return nil, fmt.Errorf("failed to get pinned messages: %w", err)
+	}
+	defer rows.Close()
+
+	pins := []PinnedMessage{}
+	for rows.Next() {
+		pin, err := r.scanPinnedMessage(rows)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan pinned message")
+			continue
+		}
+		pins = append(pins, *pin)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get pinned messages: %w", err)
+	}
+
+	return pins, nil
+}
+
+// encodeMentionEntities returns the JSON stored in messages.mentions, or nil
+// for a message without mentions.
+func encodeMentionEntities(entities []MentionEntity) (interface{}, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (r *chatRepository) decodeMentionEntities(messageID int, data []byte) []MentionEntity {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var entities []MentionEntity
+	if err := json.Unmarshal(data, &entities); err != nil {
+		r.logger.WithError(err).WithField("message_id", messageID).Warn("Failed to decode message mentions")
+		return nil
+	}
+
+	return entities
+}
+
+// GetMentionTargets returns the members of chatID whose username matches one
+// of usernames, compared case-insensitively, or every member when everyone
+// is set. Banned members cannot be mentioned.
+func (r *chatRepository) GetMentionTargets(chatID int, usernames []string, everyone bool) ([]MentionTarget, error) {
+	query := `
+		SELECT u.id, u.username
+		FROM user_chat uc
+		INNER JOIN users u ON u.id = uc.user_id
+		WHERE uc.chat_id = $1 AND uc.is_banned = false AND u.is_active = true
+		  AND ($3 OR lower(u.username) = ANY($2))
+	`
+
+	rows, err := r.db.Query(query, chatID, usernames, everyone)
+	if err != nil {
+		r.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get mention targets")
+		return nil, fmt.Errorf("failed to get mention targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []MentionTarget
+	for rows.Next() {
+		var target MentionTarget
+		if err := rows.Scan(&target.UserID, &target.Username); err != nil {
+			r.logger.WithError(err).Error("Failed to scan mention target")
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get mention targets: %w", err)
+	}
+
+	return targets, nil
+}
+
+// mentionInboxFilter limits mentions to active chats the user is still a
+// member of, and hides those from senders the user has blocked since.
+const mentionInboxFilter = `
+	m.is_deleted = false
+	AND c.is_active = true
+	AND EXISTS (
+		SELECT 1 FROM user_chat uc
+		WHERE uc.chat_id = mm.chat_id AND uc.user_id = mm.user_id AND uc.is_banned = false
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM user_blocks b
+		WHERE b.blocker_id = mm.user_id AND b.blocked_id = m.user_id
+	)
+`
+
+// GetMentions returns a page of the mentions of userID, most recent first.
+func (r *chatRepository) GetMentions(userID int, unreadOnly bool, limit, offset int) ([]Mention, int, error) {
+	countQuery := `
+		SELECT COUNT(*)
+		FROM message_mentions mm
+		INNER JOIN messages m ON m.id = mm.message_id
+		INNER JOIN chats c ON c.id = mm.chat_id
+		WHERE mm.user_id = $1 AND (NOT $2 OR mm.read_at IS NULL) AND ` + mentionInboxFilter
+
+	var total int
+	if err := r.db.QueryRow(countQuery, userID, unreadOnly).Scan(&total); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to count mentions")
+		return nil, 0, fmt.Errorf("failed to count mentions: %w", err)
+	}
+
+	query := `
+		SELECT mm.message_id, mm.chat_id, c.name, mm.kind, mm.created_at, mm.read_at,
+		       m.id, m.chat_id, COALESCE(m.user_id, 0), COALESCE(u.username, 'Deleted user'),
+		       m.content, m.message_type, m.reply_to_id, COALESCE(u.is_bot, false), m.edited_at, m.is_deleted, m.deleted_at,
+		       m.created_at, m.updated_at, m.mentions
+		FROM message_mentions mm
+		INNER JOIN messages m ON m.id = mm.message_id
+		INNER JOIN chats c ON c.id = mm.chat_id
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE mm.user_id = $1 AND (NOT $2 OR mm.read_at IS NULL) AND ` + mentionInboxFilter + `
+		ORDER BY mm.created_at DESC, mm.message_id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := r.db.Query(query, userID, unreadOnly, limit, offset)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get mentions")
+		return nil, 0, fmt.Errorf("failed to get mentions: %w", err)
+	}
+	defer rows.Close()
+
+	mentions := []Mention{}
+	for rows.Next() {
+		var mention Mention
+		var entities []byte
+		message := &mention.Message
+
+		err := rows.Scan(
+			&mention.MessageID, &mention.ChatID, &mention.ChatName, &mention.Kind, &mention.CreatedAt, &mention.ReadAt,
+			&message.ID, &message.ChatID, &message.UserID, &message.Username,
+			&message.Content, &message.MessageType, &message.ReplyToID,
+			&message.IsBot, &message.EditedAt, &message.IsDeleted, &message.DeletedAt,
+			&message.CreatedAt, &message.UpdatedAt, &entities,
+		)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan mention")
+			continue
+		}
+		message.Mentions = r.decodeMentionEntities(message.ID, entities)
+
+		mentions = append(mentions, mention)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get mentions: %w", err)
+	}
+
+	return mentions, total, nil
+}
+
+// GetMentionCounts returns the number of unread mentions of userID in each
+// chat that has any.
+func (r *chatRepository) GetMentionCounts(userID int) ([]MentionCount, error) {
+	query := `
+		SELECT mm.chat_id, COUNT(*)
+		FROM message_mentions mm
+		INNER JOIN messages m ON m.id = mm.message_id
+		INNER JOIN chats c ON c.id = mm.chat_id
+		WHERE mm.user_id = $1 AND mm.read_at IS NULL AND ` + mentionInboxFilter + `
+		GROUP BY mm.chat_id
+		ORDER BY mm.chat_id
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to count mentions")
+		return nil, fmt.Errorf("failed to count mentions: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []MentionCount{}
+	for rows.Next() {
+		var count MentionCount
+		if err := rows.Scan(&count.ChatID, &count.Unread); err != nil {
+			r.logger.WithError(err).Error("Failed to scan mention count")
+			continue
+		}
+		counts = append(counts, count)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count mentions: %w", err)
+	}
+
+	return counts, nil
+}
+
+// MarkMentionsRead marks the unread mentions of userID in chatID as read and
+// returns how many there were.
+func (r *chatRepository) MarkMentionsRead(userID, chatID int) (int, error) {
+	query := `
+		UPDATE message_mentions
+		SET read_at = $1
+		WHERE user_id = $2 AND chat_id = $3 AND read_at IS NULL
+	`
+
+	result, err := r.db.Exec(query, time.Now(), userID, chatID)
+	if err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to mark mentions as read")
+		return 0, fmt.Errorf("failed to mark mentions as read: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark mentions as read: %w", err)
+	}
+
+	return int(rows), nil
+}
+
+// CreateMessageNotifications records the notifications a new message causes:
+// a mention for mentionIDs, a reply for the author of the message replied
+// to and a direct message for the other member of a direct chat. Each user
+// gets the first that applies, and none if they are the sender, among
+// connectedIDs, have blocked the sender or turned it off for the chat.
+func (r *chatRepository) CreateMessageNotifications(message *Message, mentionIDs, connectedIDs []int) ([]Notification, error) {
+	query := `
+		WITH candidates (user_id, type, priority) AS (
+			SELECT unnest($4::int[]), 'mention', 1
+			UNION ALL
+			SELECT p.user_id, 'reply', 2
+			FROM messages p
+			WHERE p.id = $5 AND p.chat_id = $2 AND p.is_deleted = false
+			UNION ALL
+			SELECT uc.user_id, 'direct_message', 3
+			FROM user_chat uc
+			INNER JOIN chats c ON c.id = uc.chat_id
+			WHERE uc.chat_id = $2 AND c.is_direct = true
+		), recipients AS (
+			SELECT DISTINCT ON (cand.user_id) cand.user_id, cand.type
+			FROM candidates cand
+			INNER JOIN user_chat uc ON uc.chat_id = $2 AND uc.user_id = cand.user_id AND uc.is_banned = false
+			LEFT JOIN chat_notification_settings s ON s.user_id = cand.user_id AND s.chat_id = $2
+			WHERE cand.user_id <> $3
+			  AND cand.user_id <> ALL($6::int[])
+			  AND (s.muted_until IS NULL OR s.muted_until <= $7)
+			  AND (COALESCE(s.level, 'all') = 'all' OR (s.level = 'mentions' AND cand.type = 'mention'))
+			  AND NOT EXISTS (
+			      SELECT 1 FROM user_blocks b
+			      WHERE b.blocker_id = cand.user_id AND b.blocked_id = $3
+			  )
+			ORDER BY cand.user_id, cand.priority
+		)
+		INSERT INTO notifications (user_id, type, chat_id, message_id, actor_id, created_at)
+		SELECT user_id, type, $2, $1, $3, $7
+		FROM recipients
+		ON CONFLICT DO NOTHING
+		RETURNING id, user_id, type, created_at
+	`
+
+	if mentionIDs == nil {
+		mentionIDs = []int{}
+	}
+	if connectedIDs == nil {
+		connectedIDs = []int{}
+	}
+
+	rows, err := r.db.Query(query,
+		message.ID, message.ChatID, message.UserID, mentionIDs, message.ReplyToID, connectedIDs, time.Now(),
+	)
+	if err != nil {
+		r.logger.WithError(err).WithField("message_id", message.ID).Error("Failed to create notifications")
+		return nil, fmt.Errorf("failed to create notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var notification Notification
+		if err := rows.Scan(&notification.ID, &notification.UserID, &notification.Type, &notification.CreatedAt); err != nil {
+			r.logger.WithError(err).Error("Failed to scan notification")
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to create notifications: %w", err)
+	}
+
+	return notifications, nil
+}
+
+func (r *chatRepository) CreateNotification(notification *Notification) error {
+	query := `
+		INSERT INTO notifications (user_id, type, chat_id, message_id, actor_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	row := r.db.QueryRow(query,
+		notification.UserID, notification.Type, notification.ChatID,
+		notification.MessageID, notification.ActorID, time.Now(),
+	)
+
+	if err := row.Scan(&notification.ID, &notification.CreatedAt); err != nil {
+		r.logger.WithError(err).WithField("user_id", notification.UserID).Error("Failed to create notification")
+		return fmt.Errorf("failed to create notification: %w", err)
+	}
+
+	return nil
+}
+
+// notificationFrom hides notifications about deleted messages and chats.
+const notificationFrom = `
+	FROM notifications n
+	LEFT JOIN messages m ON m.id = n.message_id
+	LEFT JOIN chats c ON c.id = n.chat_id
+	WHERE (m.id IS NULL OR m.is_deleted = false) AND (c.id IS NULL OR c.is_active = true)
+`
+
+// notificationPreviewLength is how many characters of a message a
+// notification shows.
+const notificationPreviewLength = 100
+
+// GetNotifications returns a page of the notifications of userID, most
+// recent first.
+func (r *chatRepository) GetNotifications(userID int, unreadOnly bool, limit, offset int) ([]Notification, int, error) {
+	countQuery := `SELECT COUNT(*)` + notificationFrom + ` AND n.user_id = $1 AND (NOT $2 OR n.read_at IS NULL)`
+
+	var total int
+	if err := r.db.QueryRow(countQuery, userID, unreadOnly).Scan(&total); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to count notifications")
+		return nil, 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+
+	query := `
+		SELECT n.id, n.user_id, n.type, n.chat_id, c.name, n.message_id, n.actor_id, a.username,
+		       LEFT(m.content, $5), n.created_at, n.read_at
+		FROM notifications n
+		LEFT JOIN messages m ON m.id = n.message_id
+		LEFT JOIN chats c ON c.id = n.chat_id
+		LEFT JOIN users a ON a.id = n.actor_id
+		WHERE (m.id IS NULL OR m.is_deleted = false) AND (c.id IS NULL OR c.is_active = true)
+		  AND n.user_id = $1 AND (NOT $2 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := r.db.Query(query, userID, unreadOnly, limit, offset, notificationPreviewLength)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to get notifications")
+		return nil, 0, fmt.Errorf("failed to get notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(
+			&n.ID, &n.UserID, &n.Type, &n.ChatID, &n.ChatName, &n.MessageID, &n.ActorID, &n.ActorUsername,
+			&n.Preview, &n.CreatedAt, &n.ReadAt,
+		)
+		if err != nil {
+			r.logger.WithError(err).Error("Failed to scan notification")
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to get notifications: %w", err)
+	}
+
+	return notifications, total, nil
+}
+
+// CountUnreadNotifications returns the unread count of each of userIDs.
+func (r *chatRepository) CountUnreadNotifications(userIDs []int) (map[int]int, error) {
+	query := `SELECT n.user_id, COUNT(*)` + notificationFrom + `
+		  AND n.user_id = ANY($1) AND n.read_at IS NULL
+		GROUP BY n.user_id
+	`
+
+	rows, err := r.db.Query(query, userIDs)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to count unread notifications")
+		return nil, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int, len(userIDs))
+	for _, userID := range userIDs {
+		counts[userID] = 0
+	}
+	for rows.Next() {
+		var userID, count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			r.logger.WithError(err).Error("Failed to scan notification count")
+			continue
+		}
+		counts[userID] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+
+	return counts, nil
+}
+
+// MarkNotificationsRead marks unread notifications of userID as read: those
+// in ids if given, limited to chatID if not zero. It returns how many it
+// marked.
+func (r *chatRepository) MarkNotificationsRead(userID int, ids []int, chatID int) (int, error) {
+	query := `
+		UPDATE notifications
+		SET read_at = $1
+		WHERE user_id = $2 AND read_at IS NULL
+		  AND (cardinality($3::int[]) = 0 OR id = ANY($3))
+		  AND ($4 = 0 OR chat_id = $4)
+	`
+
+	if ids == nil {
+		ids = []int{}
+	}
+
+	result, err := r.db.Exec(query, time.Now(), userID, ids, chatID)
+	if err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Error("Failed to mark notifications as read")
+		return 0, fmt.Errorf("failed to mark notifications as read: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications as read: %w", err)
+	}
+
+	return int(rows), nil
+}
+
+// GetChatNotificationSettings returns the settings of userID for chatID, or
+// the defaults if they never changed them.
+func (r *chatRepository) GetChatNotificationSettings(userID, chatID int) (*ChatNotificationSettings, error) {
+	query := `
+		SELECT chat_id, level, muted_until
+		FROM chat_notification_settings
+		WHERE user_id = $1 AND chat_id = $2
+	`
+
+	settings := &ChatNotificationSettings{}
+	err := r.db.QueryRow(query, userID, chatID).Scan(&settings.ChatID, &settings.Level, &settings.MutedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ChatNotificationSettings{ChatID: chatID, Level: NotifyAll}, nil
+		}
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to get notification settings")
+		return nil, fmt.Errorf("failed to get notification settings: %w", err)
+	}
+
+	return settings, nil
+}
+
+func (r *chatRepository) UpdateChatNotificationSettings(userID int, settings ChatNotificationSettings) (*ChatNotificationSettings, error) {
+	query := `
+		INSERT INTO chat_notification_settings (user_id, chat_id, level, muted_until, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, chat_id) DO UPDATE
+		SET level = EXCLUDED.level, muted_until = EXCLUDED.muted_until, updated_at = EXCLUDED.updated_at
+		RETURNING chat_id, level, muted_until
+	`
+
+	updated := &ChatNotificationSettings{}
+	row := r.db.QueryRow(query, userID, settings.ChatID, settings.Level, settings.MutedUntil, time.Now())
+	if err := row.Scan(&updated.ChatID, &updated.Level, &updated.MutedUntil); err != nil {
+		r.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": settings.ChatID,
+		}).Error("Failed to update notification settings")
+		return nil, fmt.Errorf("failed to update notification settings: %w", err)
+	}
+
+	return updated, nil
+}
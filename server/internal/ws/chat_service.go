@@ -2,8 +2,11 @@ package ws
 
 import (
 	"fmt"
+	"io"
 	"time"
 
+	"onlineChat/pkg/redis"
+
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,20 +18,62 @@ type ChatService interface {
 	JoinChat(userID, chatID int) error
 	LeaveChat(userID, chatID int) error
 	SaveMessage(message *Message) error
-	GetMessages(chatID int, limit, offset int) (*MessageListResponse, error)
+	GetMessages(chatID, viewerID int, limit, offset int) (*MessageListResponse, error)
+	CreateDirectChat(userID, otherID int) (*ChatResponse, bool, error)
+	IsBlockedInChat(chatID, userID int) (bool, error)
+	GetBlockedUserIDs(userID int) ([]int, error)
 	UpdateChat(chatID int, userID int, req ChatRequest) (*ChatResponse, error)
+	SetSlowMode(chatID int, userID int, seconds int) (*ChatResponse, error)
 	DeleteChat(chatID int, userID int) error
 	GetChatMembers(chatID int) ([]int, error)
+	GetUserRole(userID, chatID int) (string, error)
+	GetUserChatIDs(userID int) ([]int, error)
+	UpdateLastSeen(userID int) error
+	GetPresenceAudience(userID int) ([]int, bool, error)
+	RequestChatExport(chatID, userID int, req ChatExportRequest) (*ChatExport, error)
+	WriteChatExport(chatID int, req ChatExportRequest, w io.Writer) error
+	GetChatExport(chatID, userID, exportID int) (*ChatExport, error)
+	OpenChatExport(chatID, userID, exportID int) (string, error)
+	RunChatExportWorker(interval time.Duration)
+	PinMessage(chatID, messageID, userID int) (*PinnedMessage, error)
+	UnpinMessage(chatID, messageID, userID int) error
+	GetPinnedMessages(chatID, viewerID int) (*PinnedMessageListResponse, error)
+	GetMentions(userID int, unreadOnly bool, limit, offset int) (*MentionListResponse, error)
+	GetMentionCounts(userID int) (*MentionCountsResponse, error)
+	MarkMentionsRead(userID, chatID int) (int, error)
+	GetNotifications(userID int, unreadOnly bool, limit, offset int) (*NotificationListResponse, error)
+	GetUnreadNotificationCount(userID int) (int, error)
+	MarkNotificationsRead(userID int, req NotificationReadRequest) (int, int, error)
+	GetChatNotificationSettings(userID, chatID int) (*ChatNotificationSettings, error)
+	UpdateChatNotificationSettings(userID, chatID int, req ChatNotificationSettingsRequest) (*ChatNotificationSettings, error)
+	GetContactIDs(userID int) ([]int, error)
+	IsDirectMessageRefused(chatID, userID int) (bool, error)
+}
+
+type ServiceConfig struct {
+	// ExportDir holds background chat exports for ExportTTL after they are
+	// built. Exports of more than ExportSyncLimit messages run in the
+	// background instead of streaming in the request.
+	ExportDir       string
+	ExportTTL       time.Duration
+	ExportSyncLimit int
+
+	// MaxPins caps the pinned messages of each chat.
+	MaxPins int
 }
 
 type chatService struct {
 	repo   ChatRepository
+	redis  *redis.RedisClient
+	cfg    ServiceConfig
 	logger *logrus.Logger
 }
 
-func NewChatService(repo ChatRepository, logger *logrus.Logger) ChatService {
+func NewChatService(repo ChatRepository, redisClient *redis.RedisClient, cfg ServiceConfig, logger *logrus.Logger) ChatService {
 	return &chatService{
 		repo:   repo,
+		redis:  redisClient,
+		cfg:    cfg,
 		logger: logger,
 	}
 }
@@ -76,6 +121,70 @@ func (s *chatService) CreateChat(req ChatRequest, userID int) (*ChatResponse, er
 	return &response, nil
 }
 
+// CreateDirectChat returns the direct chat between userID and otherID,
+// creating it if needed. The bool reports whether it was created. Users who
+// have blocked each other cannot start one, and a new one is only created if
+// otherID's privacy settings allow userID to message them.
+func (s *chatService) CreateDirectChat(userID, otherID int) (*ChatResponse, bool, error) {
+	if userID == otherID {
+		return nil, false, fmt.Errorf("cannot start a direct chat with yourself")
+	}
+
+	exists, err := s.repo.UserExists(otherID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, fmt.Errorf("user not found")
+	}
+
+	blocked, err := s.repo.IsBlockedBetween(userID, otherID)
+	if err != nil {
+		return nil, false, err
+	}
+	if blocked {
+		return nil, false, fmt.Errorf("cannot message this user")
+	}
+
+	if chat, err := s.repo.GetDirectChat(userID, otherID); err == nil {
+		response := chat.ToResponse()
+		return &response, false, nil
+	} else if err.Error() != "chat not found" {
+		return nil, false, err
+	}
+
+	if err := s.checkDirectMessagePolicy(userID, otherID); err != nil {
+		return nil, false, err
+	}
+
+	chat, created, err := s.repo.CreateDirectChat(&Chat{
+		Name:       "Direct message",
+		CreatedBy:  userID,
+		IsPrivate:  true,
+		IsDirect:   true,
+		MaxMembers: 2,
+		IsActive:   true,
+	}, userID, otherID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !created {
+		response := chat.ToResponse()
+		return &response, false, nil
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"other_id": otherID,
+		"chat_id":  chat.ID,
+	}).Info("Direct chat created")
+
+	s.notifyInvite(chat.ID, otherID, userID)
+
+	response := chat.ToResponse()
+	return &response, true, nil
+}
+
 func (s *chatService) GetChatByID(chatID int) (*ChatResponse, error) {
 	chat, err := s.repo.GetChatByID(chatID)
 	if err != nil {
@@ -133,6 +242,10 @@ func (s *chatService) JoinChat(userID, chatID int) error {
 		return fmt.Errorf("chat is not active")
 	}
 
+	if chat.IsDirect {
+		return fmt.Errorf("cannot join a direct chat")
+	}
+
 	members, err := s.repo.GetChatMembers(chatID)
 	if err != nil {
 		return fmt.Errorf("failed to get chat members: %w", err)
@@ -181,8 +294,13 @@ func (s *chatService) LeaveChat(userID, chatID int) error {
 	return nil
 }
 
+// SaveMessage stores message with the mentions found in its content, which
+// it sets on message.Mentions, and notifies its recipients in the
+// background.
 func (s *chatService) SaveMessage(message *Message) error {
-	if err := s.repo.SaveMessage(message); err != nil {
+	mentions := s.resolveMentions(message)
+
+	if err := s.repo.SaveMessage(message, mentions); err != nil {
 		s.logger.WithError(err).WithFields(logrus.Fields{
 			"user_id": message.UserID,
 			"chat_id": message.ChatID,
@@ -190,11 +308,13 @@ func (s *chatService) SaveMessage(message *Message) error {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 
+	go s.notifyMessage(*message, mentions)
+
 	return nil
 }
 
-func (s *chatService) GetMessages(chatID int, limit, offset int) (*MessageListResponse, error) {
-	messages, total, err := s.repo.GetMessages(chatID, limit, offset)
+func (s *chatService) GetMessages(chatID, viewerID int, limit, offset int) (*MessageListResponse, error) {
+	messages, total, err := s.repo.GetMessages(chatID, viewerID, limit, offset)
 	if err != nil {
 		s.logger.WithError(err).WithField("chat_id", chatID).Error("Failed to get messages")
 		return nil, fmt.Errorf("failed to get messages: %w", err)
@@ -237,6 +357,35 @@ func (s *chatService) UpdateChat(chatID int, userID int, req ChatRequest) (*Chat
 	return &response, nil
 }
 
+func (s *chatService) SetSlowMode(chatID int, userID int, seconds int) (*ChatResponse, error) {
+	role, err := s.repo.GetUserRoleInChat(userID, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user role: %w", err)
+	}
+
+	if role != "owner" && role != "admin" {
+		return nil, fmt.Errorf("insufficient permissions")
+	}
+
+	chat, err := s.repo.UpdateSlowMode(chatID, seconds)
+	if err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to update slow mode")
+		return nil, fmt.Errorf("failed to update slow mode: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"chat_id": chatID,
+		"seconds": seconds,
+	}).Info("Chat slow mode updated")
+
+	response := chat.ToResponse()
+	return &response, nil
+}
+
 func (s *chatService) DeleteChat(chatID int, userID int) error {
 	role, err := s.repo.GetUserRoleInChat(userID, chatID)
 	if err != nil {
@@ -272,3 +421,85 @@ func (s *chatService) GetChatMembers(chatID int) ([]int, error) {
 
 	return members, nil
 }
+
+func (s *chatService) GetUserRole(userID, chatID int) (string, error) {
+	role, err := s.repo.GetUserRoleInChat(userID, chatID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user role: %w", err)
+	}
+
+	return role, nil
+}
+
+func (s *chatService) IsBlockedInChat(chatID, userID int) (bool, error) {
+	return s.repo.IsBlockedInChat(chatID, userID)
+}
+
+// IsDirectMessageRefused applies the direct message setting of the other
+// member of a direct chat to every message, so changing it also covers
+// chats that already exist.
+func (s *chatService) IsDirectMessageRefused(chatID, userID int) (bool, error) {
+	return s.repo.IsDirectMessageRefused(chatID, userID)
+}
+
+func (s *chatService) GetBlockedUserIDs(userID int) ([]int, error) {
+	return s.repo.GetBlockedUserIDs(userID)
+}
+
+func (s *chatService) GetUserChatIDs(userID int) ([]int, error) {
+	return s.repo.GetUserChatIDs(userID)
+}
+
+func (s *chatService) UpdateLastSeen(userID int) error {
+	return s.repo.UpdateLastSeen(userID)
+}
+
+// checkDirectMessagePolicy reports whether otherID accepts new direct chats
+// from userID.
+func (s *chatService) checkDirectMessagePolicy(userID, otherID int) error {
+	directMessages, _, err := s.repo.GetPrivacySettings(otherID)
+	if err != nil {
+		return err
+	}
+
+	switch directMessages {
+	case "nobody":
+		return fmt.Errorf("cannot message this user")
+	case "contacts":
+		isContact, err := s.repo.AreContacts(otherID, userID)
+		if err != nil {
+			return err
+		}
+		if !isContact {
+			return fmt.Errorf("cannot message this user")
+		}
+	}
+
+	return nil
+}
+
+func (s *chatService) GetContactIDs(userID int) ([]int, error) {
+	return s.repo.GetContactIDs(userID)
+}
+
+// GetPresenceAudience returns who may see userID's presence. The bool is true
+// when everyone may; otherwise only the returned users, possibly none, may.
+func (s *chatService) GetPresenceAudience(userID int) ([]int, bool, error) {
+	_, presenceVisibility, err := s.repo.GetPrivacySettings(userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch presenceVisibility {
+	case "nobody":
+		return nil, false, nil
+	case "contacts":
+		contactIDs, err := s.repo.GetContactIDs(userID)
+		if err != nil {
+			return nil, false, err
+		}
+		return contactIDs, false, nil
+	default:
+		return nil, true, nil
+	}
+}
@@ -3,28 +3,44 @@ package ws
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"onlineChat/pkg/redis"
+
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	writeWait       = 10 * time.Second
-	pongWait        = 60 * time.Second
-	pingPeriod      = (pongWait * 9) / 10
-	maxMessageSize  = 4096
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 4096
 )
 
 type Client struct {
 	ID         int             `json:"id"`
 	Username   string          `json:"username"`
 	ChatID     int             `json:"chat_id"`
+	Role       string          `json:"role"`
+	SessionID  string          `json:"-"`
+	IsBot      bool            `json:"is_bot"`
+	CanSend    bool            `json:"-"`
+	IsDirect   bool            `json:"-"`
+	ConnID     string          `json:"-"`
 	Connection *websocket.Conn `json:"-"`
 	Message    chan *Message   `json:"-"`
 	Send       chan []byte     `json:"-"`
 	Hub        *Hub            `json:"-"`
 	LastPing   time.Time       `json:"-"`
+
+	blockedMu sync.RWMutex
+	blocked   map[int]bool
+
+	lastActive atomic.Int64
+	away       atomic.Bool
 }
 
 func (c *Client) readPump() {
@@ -63,11 +79,42 @@ func (c *Client) readPump() {
 			continue
 		}
 
+		c.markActive()
+
+		// Presence needs no scope, so read-only clients still show up
+		// as online or away.
+		if msg.Type == "presence" {
+			c.setAway(msg.Status == redis.PresenceAway)
+			c.Hub.touchPresence(c)
+			continue
+		}
+
+		if !c.CanSend {
+			c.sendError("Token is missing the messages:write scope")
+			continue
+		}
+
+		// User sockets only carry presence updates.
+		if c.ChatID == 0 {
+			c.sendError("Not connected to a chat")
+			continue
+		}
+
 		if err := c.validateMessage(msg); err != nil {
 			c.sendError(fmt.Sprintf("Invalid message: %v", err))
 			continue
 		}
 
+		if c.IsDirect && (c.Hub.isBlockedInChat(c) || c.Hub.isDirectMessageRefused(c)) {
+			c.sendError("You cannot message this user")
+			continue
+		}
+
+		if code, retryAfter, limited := c.Hub.checkSendLimits(c); limited {
+			c.sendRateLimited(code, retryAfter)
+			continue
+		}
+
 		message := &Message{
 			ChatID:      c.ChatID,
 			UserID:      c.ID,
@@ -75,6 +122,7 @@ func (c *Client) readPump() {
 			Content:     msg.Content,
 			MessageType: msg.MessageType,
 			ReplyToID:   msg.ReplyToID,
+			IsBot:       c.IsBot,
 			CreatedAt:   time.Now(),
 		}
 
@@ -127,6 +175,8 @@ func (c *Client) writePump() {
 				}).Error("Failed to send ping")
 				return
 			}
+
+			c.Hub.touchPresence(c)
 		}
 	}
 }
@@ -175,6 +225,29 @@ func (c *Client) sendError(message string) {
 	}
 }
 
+func (c *Client) sendRateLimited(code string, retryAfter time.Duration) {
+	message := "You are sending messages too fast"
+	if code == "slow_mode" {
+		message = "Slow mode is enabled in this chat"
+	}
+
+	errorMsg := map[string]interface{}{
+		"type":           "error",
+		"code":           code,
+		"message":        message,
+		"retry_after_ms": retryAfter.Milliseconds(),
+		"time":           time.Now(),
+	}
+
+	if data, err := json.Marshal(errorMsg); err == nil {
+		select {
+		case c.Send <- data:
+		default:
+			c.Connection.Close()
+		}
+	}
+}
+
 func (c *Client) sendMessage(message *Message) {
 	if data, err := json.Marshal(message); err == nil {
 		select {
@@ -186,6 +259,25 @@ func (c *Client) sendMessage(message *Message) {
 	}
 }
 
+// hasBlocked reports whether the client's user has blocked userID.
+func (c *Client) hasBlocked(userID int) bool {
+	c.blockedMu.RLock()
+	defer c.blockedMu.RUnlock()
+
+	return c.blocked[userID]
+}
+
+func (c *Client) setBlocked(userIDs []int) {
+	blocked := make(map[int]bool, len(userIDs))
+	for _, id := range userIDs {
+		blocked[id] = true
+	}
+
+	c.blockedMu.Lock()
+	c.blocked = blocked
+	c.blockedMu.Unlock()
+}
+
 func (c *Client) IsActive() bool {
 	return c.Connection != nil
 }
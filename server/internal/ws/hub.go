@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"onlineChat/pkg/config"
+	"strconv"
 	"sync"
 	"time"
 
@@ -13,45 +14,74 @@ import (
 )
 
 type Hub struct {
-	chats      map[int]map[int]*Client
-	broadcast  chan *Message
-	register   chan *Client
-	unregister chan *Client
-	redis      *redis.RedisClient
-	service    ChatService
-	logger     *logrus.Logger
-	mu         sync.RWMutex
+	chats             map[int]map[int]*Client
+	users             map[int]map[*Client]bool
+	broadcast         chan *Message
+	register          chan *Client
+	unregister        chan *Client
+	presence          chan presenceEvent
+	userEvents        chan redis.UserEvent
+	redis             *redis.RedisClient
+	service           ChatService
+	logger            *logrus.Logger
+	messageRateLimit  int
+	messageRateWindow time.Duration
+	presenceAwayAfter time.Duration
+	mu                sync.RWMutex
 }
 
-func NewHub(redisCfg config.RedisConfig, service ChatService, logger *logrus.Logger) *Hub {
+func NewHub(redisClient *redis.RedisClient, chatCfg config.ChatConfig, service ChatService, logger *logrus.Logger) *Hub {
 	return &Hub{
-		chats:      make(map[int]map[int]*Client),
-		broadcast:  make(chan *Message),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		redis: redis.NewRedisClient(redis.RedisConfig{
-			Address:  redisCfg.Address,
-			Password: redisCfg.Password,
-			DB:       redisCfg.DB,
-		}, logger),
-		service: service,
-		logger:  logger,
+		chats:             make(map[int]map[int]*Client),
+		users:             make(map[int]map[*Client]bool),
+		broadcast:         make(chan *Message),
+		register:          make(chan *Client),
+		unregister:        make(chan *Client),
+		presence:          make(chan presenceEvent, 256),
+		userEvents:        make(chan redis.UserEvent, 256),
+		redis:             redisClient,
+		service:           service,
+		logger:            logger,
+		messageRateLimit:  chatCfg.MessageRateLimit,
+		messageRateWindow: chatCfg.MessageRateWindow,
+		presenceAwayAfter: chatCfg.PresenceAwayAfter,
 	}
 }
 
 func (h *Hub) Run() {
 	h.logger.Info("Starting WebSocket hub")
 
+	go h.listenSessionRevocations()
+	go h.listenBlockChanges()
+	go h.listenPresence()
+	go h.sweepPresence()
+	go h.listenUserEvents()
+	go h.listenUserDisconnects()
+
 	for {
 		select {
 		case client := <-h.register:
-			h.registerClient(client)
+			if client.ChatID == 0 {
+				h.registerUserClient(client)
+			} else {
+				h.registerClient(client)
+			}
 
 		case client := <-h.unregister:
-			h.unregisterClient(client)
+			if client.ChatID == 0 {
+				h.unregisterUserClient(client)
+			} else {
+				h.unregisterClient(client)
+			}
 
 		case message := <-h.broadcast:
 			h.broadcastMessage(message)
+
+		case event := <-h.presence:
+			h.deliverPresence(event)
+
+		case event := <-h.userEvents:
+			h.deliverUserEvent(event)
 		}
 	}
 }
@@ -83,6 +113,8 @@ func (h *Hub) registerClient(client *Client) {
 		}).Error("Failed to add user to Redis")
 	}
 
+	h.touchPresence(client)
+
 	h.sendSystemMessage(client.ChatID, fmt.Sprintf("User %s joined the chat", client.Username))
 
 	h.logger.WithFields(logrus.Fields{
@@ -97,8 +129,12 @@ func (h *Hub) unregisterClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	h.dropPresence(client)
+
 	if chat, exists := h.chats[client.ChatID]; exists {
-		if _, clientExists := chat[client.ID]; clientExists {
+		// A newer connection of the same user may have replaced this one
+		// already; leave it alone.
+		if current, clientExists := chat[client.ID]; clientExists && current == client {
 			if err := h.redis.RemoveUser(client.ChatID, client.ID); err != nil {
 				h.logger.WithError(err).WithFields(logrus.Fields{
 					"user_id":  client.ID,
@@ -165,9 +201,25 @@ func (h *Hub) broadcastMessage(message *Message) {
 			return
 		}
 
+		// Recipients who blocked the sender get a copy flagged for hiding.
+		var blockedData []byte
+
 		for clientID, client := range chat {
+			data := messageData
+			if message.UserID != 0 && client.hasBlocked(message.UserID) {
+				if blockedData == nil {
+					flagged := *message
+					flagged.SenderBlocked = true
+					if blockedData, err = json.Marshal(&flagged); err != nil {
+						h.logger.WithError(err).WithField("chat_id", message.ChatID).Error("Failed to marshal message")
+						continue
+					}
+				}
+				data = blockedData
+			}
+
 			select {
-			case client.Send <- messageData:
+			case client.Send <- data:
 			default:
 				h.logger.WithFields(logrus.Fields{
 					"client_id": clientID,
@@ -191,7 +243,207 @@ func (h *Hub) sendSystemMessage(chatID int, content string) {
 		CreatedAt:   time.Now(),
 	}
 
-	h.broadcast <- systemMessage
+	// This is also called from inside Run, which is the only reader of
+	// broadcast, so the send must not block the caller.
+	go func() {
+		h.broadcast <- systemMessage
+	}()
+}
+
+// checkSendLimits applies the per-user message rate limit and the chat's slow
+// mode to a message about to be sent by client. Redis failures are logged and
+// the message is let through.
+func (h *Hub) checkSendLimits(client *Client) (string, time.Duration, bool) {
+	fields := logrus.Fields{
+		"user_id": client.ID,
+		"chat_id": client.ChatID,
+	}
+
+	if h.messageRateLimit > 0 {
+		allowed, retryAfter, err := h.redis.AllowMessage(client.ChatID, client.ID, h.messageRateLimit, h.messageRateWindow)
+		if err != nil {
+			h.logger.WithError(err).WithFields(fields).Warn("Failed to check message rate limit")
+		} else if !allowed {
+			return "rate_limited", retryAfter, true
+		}
+	}
+
+	seconds := h.chatSlowMode(client.ChatID)
+	if seconds <= 0 {
+		return "", 0, false
+	}
+
+	// The role is looked up again since it may have changed after the
+	// client connected.
+	role, err := h.service.GetUserRole(client.ID, client.ChatID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(fields).Warn("Failed to get role for slow mode")
+		role = client.Role
+	}
+	if isChatModerator(role) {
+		return "", 0, false
+	}
+
+	allowed, retryAfter, err := h.redis.CheckSlowMode(client.ChatID, client.ID, time.Duration(seconds)*time.Second)
+	if err != nil {
+		h.logger.WithError(err).WithFields(fields).Warn("Failed to check slow mode")
+		return "", 0, false
+	}
+	if !allowed {
+		return "slow_mode", retryAfter, true
+	}
+
+	return "", 0, false
+}
+
+func (h *Hub) chatSlowMode(chatID int) int {
+	seconds, cached, err := h.redis.GetChatSlowMode(chatID)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatID).Warn("Failed to get cached slow mode")
+	}
+	if cached {
+		return seconds
+	}
+
+	chat, err := h.service.GetChatByID(chatID)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatID).Warn("Failed to load chat slow mode")
+		return 0
+	}
+
+	if err := h.redis.SetChatSlowMode(chatID, chat.SlowModeSeconds); err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatID).Warn("Failed to cache slow mode")
+	}
+
+	return chat.SlowModeSeconds
+}
+
+// SetSlowMode refreshes the cached slow mode of a chat and notifies its
+// connected clients.
+func (h *Hub) SetSlowMode(chatID, seconds int) {
+	if err := h.redis.SetChatSlowMode(chatID, seconds); err != nil {
+		h.logger.WithError(err).WithField("chat_id", chatID).Warn("Failed to cache slow mode")
+	}
+
+	if seconds == 0 {
+		h.sendSystemMessage(chatID, "Slow mode disabled")
+		return
+	}
+
+	h.sendSystemMessage(chatID, fmt.Sprintf("Slow mode enabled: one message every %d seconds", seconds))
+}
+
+// listenSessionRevocations closes the connections of sessions revoked on any
+// instance. Closing the socket makes readPump unregister the client.
+func (h *Hub) listenSessionRevocations() {
+	pubsub := h.redis.SubscribeSessionRevocations()
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		h.DisconnectSession(msg.Payload)
+	}
+}
+
+func (h *Hub) DisconnectSession(sessionID string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, chat := range h.chats {
+		for _, client := range chat {
+			if client.SessionID == sessionID {
+				h.logger.WithFields(logrus.Fields{
+					"user_id":    client.ID,
+					"chat_id":    client.ChatID,
+					"session_id": sessionID,
+				}).Info("Closing connection of revoked session")
+
+				client.Connection.Close()
+			}
+		}
+	}
+
+	for _, sockets := range h.users {
+		for client := range sockets {
+			if client.SessionID == sessionID {
+				h.logger.WithFields(logrus.Fields{
+					"user_id":    client.ID,
+					"session_id": sessionID,
+				}).Info("Closing user socket of revoked session")
+
+				client.Connection.Close()
+			}
+		}
+	}
+}
+
+// isBlockedInChat reports whether the client's user and the other member of
+// a direct chat have blocked each other. Errors are logged and let the
+// message through.
+func (h *Hub) isBlockedInChat(client *Client) bool {
+	blocked, err := h.service.IsBlockedInChat(client.ChatID, client.ID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": client.ID,
+			"chat_id": client.ChatID,
+		}).Warn("Failed to check blocks")
+		return false
+	}
+
+	return blocked
+}
+
+func (h *Hub) isDirectMessageRefused(client *Client) bool {
+	refused, err := h.service.IsDirectMessageRefused(client.ChatID, client.ID)
+	if err != nil {
+		h.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": client.ID,
+			"chat_id": client.ChatID,
+		}).Warn("Failed to check direct message settings")
+		return false
+	}
+
+	return refused
+}
+
+// listenBlockChanges reloads the block list of connected users when they
+// block or unblock someone on any instance.
+func (h *Hub) listenBlockChanges() {
+	pubsub := h.redis.SubscribeBlockChanges()
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		userID, err := strconv.Atoi(msg.Payload)
+		if err != nil {
+			continue
+		}
+		h.ReloadBlockedUsers(userID)
+	}
+}
+
+func (h *Hub) ReloadBlockedUsers(userID int) {
+	var clients []*Client
+
+	h.mu.RLock()
+	for _, chat := range h.chats {
+		if client, exists := chat[userID]; exists {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(clients) == 0 {
+		return
+	}
+
+	blocked, err := h.service.GetBlockedUserIDs(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to reload blocked users")
+		return
+	}
+
+	for _, client := range clients {
+		client.setBlocked(blocked)
+	}
 }
 
 func (h *Hub) GetChatClients(chatID int) []*Client {
@@ -241,6 +493,13 @@ func (h *Hub) Close() {
 		}
 	}
 
+	for _, sockets := range h.users {
+		for client := range sockets {
+			client.Close()
+		}
+	}
+
 	h.chats = make(map[int]map[int]*Client)
+	h.users = make(map[int]map[*Client]bool)
 	h.logger.Info("Hub closed")
 }
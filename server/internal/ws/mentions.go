@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"onlineChat/pkg/redis"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mentionPattern matches @name where name has the format of a username. The
+// @ must not follow a word character, so email addresses are not mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@./])(@([A-Za-z0-9_]{1,50}))`)
+
+// mentionToken is a mention found in message content. Start and End are
+// byte offsets of the mention, including the @. Name is lowercased.
+type mentionToken struct {
+	Name       string
+	Start, End int
+}
+
+func parseMentions(content string) []mentionToken {
+	var tokens []mentionToken
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		tokens = append(tokens, mentionToken{
+			Name:  strings.ToLower(content[match[4]:match[5]]),
+			Start: match[2],
+			End:   match[3],
+		})
+	}
+
+	return tokens
+}
+
+// resolveMentions sets the mention entities of a text message and returns
+// the members to record them for. @here reaches members who are online and
+// @all every member, but only when sent by a moderator. Senders are never
+// recorded as mentioning themselves.
+func (s *chatService) resolveMentions(message *Message) []MessageMention {
+	if message.MessageType != "" && message.MessageType != "text" {
+		return nil
+	}
+
+	tokens := parseMentions(message.Content)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	fields := logrus.Fields{
+		"user_id": message.UserID,
+		"chat_id": message.ChatID,
+	}
+
+	var usernames []string
+	var here, all bool
+	for _, token := range tokens {
+		switch token.Name {
+		case MentionHere:
+			here = true
+		case MentionAll:
+			all = true
+		default:
+			usernames = append(usernames, token.Name)
+		}
+	}
+
+	if all {
+		role, err := s.repo.GetUserRoleInChat(message.UserID, message.ChatID)
+		if err != nil {
+			s.logger.WithError(err).WithFields(fields).Warn("Failed to check role for @all")
+		}
+		all = isChatModerator(role)
+	}
+
+	if len(usernames) == 0 && !here && !all {
+		return nil
+	}
+
+	targets, err := s.repo.GetMentionTargets(message.ChatID, usernames, here || all)
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).Warn("Failed to resolve mentions")
+		return nil
+	}
+
+	// Usernames are unique as typed, so prefer an exact match over one
+	// differing in case.
+	byName := make(map[string]MentionTarget, len(targets))
+	for _, target := range targets {
+		name := strings.ToLower(target.Username)
+		if existing, ok := byName[name]; !ok || existing.Username != name {
+			byName[name] = target
+		}
+	}
+
+	kinds := make(map[int]string)
+	for _, token := range tokens {
+		entity := MentionEntity{
+			Offset: utf8.RuneCountInString(message.Content[:token.Start]),
+			Length: utf8.RuneCountInString(message.Content[token.Start:token.End]),
+		}
+
+		switch token.Name {
+		case MentionHere:
+			entity.Type = MentionHere
+		case MentionAll:
+			if !all {
+				continue
+			}
+			entity.Type = MentionAll
+		default:
+			target, ok := byName[token.Name]
+			if !ok {
+				continue
+			}
+			entity.Type = MentionUser
+			entity.UserID = target.UserID
+			entity.Username = target.Username
+			kinds[target.UserID] = MentionUser
+		}
+
+		message.Mentions = append(message.Mentions, entity)
+	}
+
+	if all || here {
+		var online map[int]string
+		if !all {
+			ids := make([]int, len(targets))
+			for i, target := range targets {
+				ids[i] = target.UserID
+			}
+			if online, err = s.redis.GetPresence(ids); err != nil {
+				s.logger.WithError(err).WithFields(fields).Warn("Failed to get presence for @here")
+			}
+		}
+
+		for _, target := range targets {
+			if _, ok := kinds[target.UserID]; ok {
+				continue
+			}
+			if all {
+				kinds[target.UserID] = MentionAll
+			} else if online[target.UserID] == redis.PresenceOnline {
+				kinds[target.UserID] = MentionHere
+			}
+		}
+	}
+
+	delete(kinds, message.UserID)
+
+	mentions := make([]MessageMention, 0, len(kinds))
+	for userID, kind := range kinds {
+		mentions = append(mentions, MessageMention{UserID: userID, Kind: kind})
+	}
+
+	return mentions
+}
+
+func (s *chatService) GetMentions(userID int, unreadOnly bool, limit, offset int) (*MentionListResponse, error) {
+	mentions, total, err := s.repo.GetMentions(userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MentionListResponse{
+		Mentions: mentions,
+		Total:    total,
+		HasMore:  offset+len(mentions) < total,
+	}, nil
+}
+
+func (s *chatService) GetMentionCounts(userID int) (*MentionCountsResponse, error) {
+	counts, err := s.repo.GetMentionCounts(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, count := range counts {
+		total += count.Unread
+	}
+
+	return &MentionCountsResponse{
+		Counts: counts,
+		Total:  total,
+	}, nil
+}
+
+// MarkMentionsRead marks the mentions of userID in chatID as read.
+func (s *chatService) MarkMentionsRead(userID, chatID int) (int, error) {
+	if _, err := s.repo.GetUserRoleInChat(userID, chatID); err != nil {
+		return 0, err
+	}
+
+	return s.repo.MarkMentionsRead(userID, chatID)
+}
@@ -5,17 +5,19 @@ import (
 )
 
 type Chat struct {
-	ID             int             `json:"id" db:"id"`
-	Name           string          `json:"name" db:"name"`
-	Description    *string         `json:"description,omitempty" db:"description"`
-	CreatedBy      int             `json:"created_by" db:"created_by"`
-	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
-	UpdatedAt      time.Time       `json:"updated_at" db:"updated_at"`
-	IsPrivate      bool            `json:"is_private" db:"is_private"`
-	IsActive       bool            `json:"is_active" db:"is_active"`
-	MaxMembers     int             `json:"max_members" db:"max_members"`
-	CurrentMembers int             `json:"current_members" db:"current_members"`
-	Clients        map[int]*Client `json:"-" db:"-"`
+	ID              int             `json:"id" db:"id"`
+	Name            string          `json:"name" db:"name"`
+	Description     *string         `json:"description,omitempty" db:"description"`
+	CreatedBy       int             `json:"created_by" db:"created_by"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at" db:"updated_at"`
+	IsPrivate       bool            `json:"is_private" db:"is_private"`
+	IsDirect        bool            `json:"is_direct" db:"is_direct"`
+	IsActive        bool            `json:"is_active" db:"is_active"`
+	MaxMembers      int             `json:"max_members" db:"max_members"`
+	CurrentMembers  int             `json:"current_members" db:"current_members"`
+	SlowModeSeconds int             `json:"slow_mode_seconds" db:"slow_mode_seconds"`
+	Clients         map[int]*Client `json:"-" db:"-"`
 }
 
 type Message struct {
@@ -26,11 +28,20 @@ type Message struct {
 	Content     string     `json:"content" db:"content"`
 	MessageType string     `json:"message_type" db:"message_type"`
 	ReplyToID   *int       `json:"reply_to_id,omitempty" db:"reply_to_id"`
+	IsBot       bool       `json:"is_bot" db:"is_bot"`
 	EditedAt    *time.Time `json:"edited_at,omitempty" db:"edited_at"`
 	IsDeleted   bool       `json:"is_deleted" db:"is_deleted"`
 	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+
+	// Mentions locates the @mentions in Content that resolved to members
+	// of the chat, or to @here and @all.
+	Mentions []MentionEntity `json:"mentions,omitempty" db:"mentions"`
+
+	// SenderBlocked is set per recipient when they have blocked the sender,
+	// so clients can collapse the message. Other members are unaffected.
+	SenderBlocked bool `json:"sender_blocked,omitempty" db:"-"`
 }
 
 type ChatRequest struct {
@@ -41,22 +52,37 @@ type ChatRequest struct {
 }
 
 type ChatResponse struct {
-	ID             int       `json:"id"`
-	Name           string    `json:"name"`
-	Description    *string   `json:"description,omitempty"`
-	CreatedBy      int       `json:"created_by"`
-	CreatedAt      time.Time `json:"created_at"`
-	IsPrivate      bool      `json:"is_private"`
-	MaxMembers     int       `json:"max_members"`
-	CurrentMembers int       `json:"current_members"`
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	Description     *string   `json:"description,omitempty"`
+	CreatedBy       int       `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+	IsPrivate       bool      `json:"is_private"`
+	IsDirect        bool      `json:"is_direct"`
+	MaxMembers      int       `json:"max_members"`
+	CurrentMembers  int       `json:"current_members"`
+	SlowModeSeconds int       `json:"slow_mode_seconds"`
 }
 
 type MessageRequest struct {
+	// Type is "presence" for status updates from the client, which carry
+	// Status instead of content. Chat messages leave it empty.
+	Type   string `json:"type,omitempty"`
+	Status string `json:"status,omitempty"`
+
 	Content     string `json:"content" binding:"required,min=1,max=4000"`
 	MessageType string `json:"message_type,omitempty" binding:"omitempty,oneof=text image file system"`
 	ReplyToID   *int   `json:"reply_to_id,omitempty"`
 }
 
+type DirectChatRequest struct {
+	UserID int `json:"user_id" binding:"required"`
+}
+
+type SlowModeRequest struct {
+	Seconds int `json:"seconds" binding:"min=0,max=21600"`
+}
+
 type JoinChatRequest struct {
 	ChatID int `json:"chat_id" binding:"required"`
 }
@@ -83,15 +109,244 @@ type UserChatRole struct {
 	LastReadAt  *time.Time `json:"last_read_at,omitempty" db:"last_read_at"`
 }
 
+const (
+	ChatExportPending    = "pending"
+	ChatExportProcessing = "processing"
+	ChatExportReady      = "ready"
+	ChatExportFailed     = "failed"
+	ChatExportExpired    = "expired"
+)
+
+// EventChatExportReady is pushed to the requester's connections when a
+// background chat export finishes, successfully or not.
+const EventChatExportReady = "chat_export_ready"
+
+// ChatExportRequest selects the format and time range of a chat export. From
+// is inclusive and To exclusive; either may be left out.
+type ChatExportRequest struct {
+	Format string     `form:"format" binding:"omitempty,oneof=json csv html txt"`
+	From   *time.Time `form:"from"`
+	To     *time.Time `form:"to"`
+}
+
+// ChatExport is a chat history export too large to stream in the request,
+// built in the background. DownloadURL is set once it is ready.
+type ChatExport struct {
+	ID           int        `json:"id" db:"id"`
+	ChatID       int        `json:"chat_id" db:"chat_id"`
+	UserID       int        `json:"user_id" db:"user_id"`
+	Format       string     `json:"format" db:"format"`
+	From         *time.Time `json:"from,omitempty" db:"from_time"`
+	To           *time.Time `json:"to,omitempty" db:"to_time"`
+	Status       string     `json:"status" db:"status"`
+	FileName     *string    `json:"-" db:"file_name"`
+	SizeBytes    *int64     `json:"size_bytes,omitempty" db:"size_bytes"`
+	MessageCount *int       `json:"message_count,omitempty" db:"message_count"`
+	Error        *string    `json:"error,omitempty" db:"error"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	DownloadURL  string     `json:"download_url,omitempty" db:"-"`
+}
+
+type ChatExportEvent struct {
+	Type   string      `json:"type"`
+	Export *ChatExport `json:"export"`
+}
+
+// ChatExportMessage is one message in a chat export. ReplyToUsername names
+// the author of the message replied to.
+type ChatExportMessage struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Username        string     `json:"username"`
+	Content         string     `json:"content"`
+	MessageType     string     `json:"message_type"`
+	ReplyToID       *int       `json:"reply_to_id,omitempty"`
+	ReplyToUsername *string    `json:"reply_to_username,omitempty"`
+	EditedAt        *time.Time `json:"edited_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// Pin events are broadcast to the connected members of a chat when a message
+// is pinned or unpinned.
+const (
+	EventPinned   = "pinned"
+	EventUnpinned = "unpinned"
+)
+
+// PinnedMessage is a message pinned in a chat. PinnedBy is nil once the
+// user who pinned it has deleted their account.
+type PinnedMessage struct {
+	ChatID           int       `json:"chat_id" db:"chat_id"`
+	MessageID        int       `json:"message_id" db:"message_id"`
+	PinnedBy         *int      `json:"pinned_by,omitempty" db:"pinned_by"`
+	PinnedByUsername *string   `json:"pinned_by_username,omitempty" db:"-"`
+	PinnedAt         time.Time `json:"pinned_at" db:"pinned_at"`
+	Message          *Message  `json:"message,omitempty" db:"-"`
+}
+
+type PinnedMessageListResponse struct {
+	Pins  []PinnedMessage `json:"pins"`
+	Total int             `json:"total"`
+	Limit int             `json:"limit"`
+}
+
+// PinEvent tells chat clients that UserID pinned or unpinned MessageID. Pin
+// is only set for EventPinned.
+type PinEvent struct {
+	Type      string         `json:"type"`
+	ChatID    int            `json:"chat_id"`
+	MessageID int            `json:"message_id"`
+	UserID    int            `json:"user_id"`
+	Pin       *PinnedMessage `json:"pin,omitempty"`
+}
+
+// Mention kinds. A user mentioned by name and through @here or @all is
+// recorded as MentionUser.
+const (
+	MentionUser = "user"
+	MentionHere = "here"
+	MentionAll  = "all"
+)
+
+// MentionEntity is a mention in a message. Offset and Length count
+// characters (Unicode code points) of the content, including the @. UserID
+// and Username are only set for MentionUser.
+type MentionEntity struct {
+	Type     string `json:"type"`
+	UserID   int    `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+// MessageMention records that a message mentioned UserID.
+type MessageMention struct {
+	UserID int    `db:"user_id"`
+	Kind   string `db:"kind"`
+}
+
+// MentionTarget is a chat member a mention can resolve to.
+type MentionTarget struct {
+	UserID   int    `db:"user_id"`
+	Username string `db:"username"`
+}
+
+// Mention is an entry of a user's mention inbox.
+type Mention struct {
+	MessageID int        `json:"message_id" db:"message_id"`
+	ChatID    int        `json:"chat_id" db:"chat_id"`
+	ChatName  string     `json:"chat_name" db:"chat_name"`
+	Kind      string     `json:"kind" db:"kind"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty" db:"read_at"`
+	Message   Message    `json:"message" db:"-"`
+}
+
+type MentionListResponse struct {
+	Mentions []Mention `json:"mentions"`
+	Total    int       `json:"total"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// MentionCount is the number of unread mentions of a user in a chat.
+type MentionCount struct {
+	ChatID int `json:"chat_id" db:"chat_id"`
+	Unread int `json:"unread" db:"unread"`
+}
+
+type MentionCountsResponse struct {
+	Counts []MentionCount `json:"counts"`
+	Total  int            `json:"total"`
+}
+
+// Notification types.
+const (
+	NotificationMention       = "mention"
+	NotificationDirectMessage = "direct_message"
+	NotificationReply         = "reply"
+	NotificationInvite        = "invite"
+)
+
+// Per-chat notification levels. NotifyAll records every notification type,
+// NotifyMentions only mentions and NotifyNone nothing.
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyNone     = "none"
+)
+
+// EventNotification is pushed to every connection of a user when they get a
+// notification or read some, with their new unread count.
+const EventNotification = "notification"
+
+// Notification is an entry of a user's notification center. Preview is the
+// start of the message, if any.
+type Notification struct {
+	ID            int        `json:"id" db:"id"`
+	UserID        int        `json:"-" db:"user_id"`
+	Type          string     `json:"type" db:"type"`
+	ChatID        *int       `json:"chat_id,omitempty" db:"chat_id"`
+	ChatName      *string    `json:"chat_name,omitempty" db:"chat_name"`
+	MessageID     *int       `json:"message_id,omitempty" db:"message_id"`
+	ActorID       *int       `json:"actor_id,omitempty" db:"actor_id"`
+	ActorUsername *string    `json:"actor_username,omitempty" db:"actor_username"`
+	Preview       *string    `json:"preview,omitempty" db:"preview"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	ReadAt        *time.Time `json:"read_at,omitempty" db:"read_at"`
+}
+
+type NotificationListResponse struct {
+	Notifications []Notification `json:"notifications"`
+	Total         int            `json:"total"`
+	Unread        int            `json:"unread"`
+	HasMore       bool           `json:"has_more"`
+}
+
+// NotificationReadRequest marks the notifications with IDs as read, or all
+// of them in ChatID, or all of them when both are left out.
+type NotificationReadRequest struct {
+	IDs    []int `json:"ids,omitempty" binding:"omitempty,max=500"`
+	ChatID int   `json:"chat_id,omitempty" binding:"omitempty,min=1"`
+}
+
+// NotificationEvent carries the unread count and, for a new notification,
+// the notification itself.
+type NotificationEvent struct {
+	Type         string        `json:"type"`
+	Unread       int           `json:"unread"`
+	Notification *Notification `json:"notification,omitempty"`
+}
+
+// ChatNotificationSettings is how a member is notified about a chat. While
+// MutedUntil is in the future the chat notifies nothing, whatever Level.
+type ChatNotificationSettings struct {
+	ChatID     int        `json:"chat_id" db:"chat_id"`
+	Level      string     `json:"level" db:"level"`
+	MutedUntil *time.Time `json:"muted_until,omitempty" db:"muted_until"`
+}
+
+type ChatNotificationSettingsRequest struct {
+	Level      string     `json:"level" binding:"required,oneof=all mentions none"`
+	MutedUntil *time.Time `json:"muted_until"`
+}
+
+func isChatModerator(role string) bool {
+	return role == "owner" || role == "admin" || role == "moderator"
+}
+
 func (c *Chat) ToResponse() ChatResponse {
 	return ChatResponse{
-		ID:             c.ID,
-		Name:           c.Name,
-		Description:    c.Description,
-		CreatedBy:      c.CreatedBy,
-		CreatedAt:      c.CreatedAt,
-		IsPrivate:      c.IsPrivate,
-		MaxMembers:     c.MaxMembers,
-		CurrentMembers: c.CurrentMembers,
+		ID:              c.ID,
+		Name:            c.Name,
+		Description:     c.Description,
+		CreatedBy:       c.CreatedBy,
+		CreatedAt:       c.CreatedAt,
+		IsPrivate:       c.IsPrivate,
+		IsDirect:        c.IsDirect,
+		MaxMembers:      c.MaxMembers,
+		CurrentMembers:  c.CurrentMembers,
+		SlowModeSeconds: c.SlowModeSeconds,
 	}
 }
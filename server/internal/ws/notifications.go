@@ -0,0 +1,204 @@
+package ws
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// notifyMessage records the notifications of a saved message and pushes
+// them to their users. Members connected to the chat see the message as it
+// arrives and are not notified. Failures are logged; the message is sent
+// either way.
+func (s *chatService) notifyMessage(message Message, mentions []MessageMention) {
+	fields := logrus.Fields{
+		"chat_id":    message.ChatID,
+		"message_id": message.ID,
+	}
+
+	connected, err := s.redis.GetChatMembers(message.ChatID)
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).Warn("Failed to get connected members")
+	}
+
+	mentionIDs := make([]int, len(mentions))
+	for i, mention := range mentions {
+		mentionIDs[i] = mention.UserID
+	}
+
+	notifications, err := s.repo.CreateMessageNotifications(&message, mentionIDs, connected)
+	if err != nil {
+		s.logger.WithError(err).WithFields(fields).Error("Failed to create notifications")
+		return
+	}
+
+	preview := notificationPreview(message.Content)
+	for i := range notifications {
+		notifications[i].ChatID = &message.ChatID
+		notifications[i].MessageID = &message.ID
+		notifications[i].ActorID = &message.UserID
+		notifications[i].ActorUsername = &message.Username
+		notifications[i].Preview = &preview
+	}
+
+	s.pushNotifications(notifications)
+}
+
+// notifyInvite tells userID that actorID added them to chatID.
+func (s *chatService) notifyInvite(chatID, userID, actorID int) {
+	notification := &Notification{
+		UserID:  userID,
+		Type:    NotificationInvite,
+		ChatID:  &chatID,
+		ActorID: &actorID,
+	}
+
+	if err := s.repo.CreateNotification(notification); err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": userID,
+			"chat_id": chatID,
+		}).Error("Failed to create invite notification")
+		return
+	}
+
+	s.pushNotifications([]Notification{*notification})
+}
+
+// pushNotifications sends each new notification with the unread count of
+// its user to their connections.
+func (s *chatService) pushNotifications(notifications []Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	userIDs := make([]int, len(notifications))
+	for i, notification := range notifications {
+		userIDs[i] = notification.UserID
+	}
+
+	counts, err := s.repo.CountUnreadNotifications(userIDs)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to count unread notifications")
+		return
+	}
+
+	for i := range notifications {
+		notification := &notifications[i]
+		event := NotificationEvent{
+			Type:         EventNotification,
+			Unread:       counts[notification.UserID],
+			Notification: notification,
+		}
+		if err := s.redis.PublishUserEvent(notification.UserID, event); err != nil {
+			s.logger.WithError(err).WithField("user_id", notification.UserID).Warn("Failed to publish notification")
+		}
+	}
+}
+
+// pushUnreadCount sends the unread count of userID to their connections,
+// such as after they read notifications on another device.
+func (s *chatService) pushUnreadCount(userID, unread int) {
+	event := NotificationEvent{
+		Type:   EventNotification,
+		Unread: unread,
+	}
+
+	if err := s.redis.PublishUserEvent(userID, event); err != nil {
+		s.logger.WithError(err).WithField("user_id", userID).Warn("Failed to publish notification count")
+	}
+}
+
+func notificationPreview(content string) string {
+	runes := []rune(content)
+	if len(runes) <= notificationPreviewLength {
+		return content
+	}
+
+	return string(runes[:notificationPreviewLength])
+}
+
+func (s *chatService) GetNotifications(userID int, unreadOnly bool, limit, offset int) (*NotificationListResponse, error) {
+	notifications, total, err := s.repo.GetNotifications(userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	unread, err := s.GetUnreadNotificationCount(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotificationListResponse{
+		Notifications: notifications,
+		Total:         total,
+		Unread:        unread,
+		HasMore:       offset+len(notifications) < total,
+	}, nil
+}
+
+func (s *chatService) GetUnreadNotificationCount(userID int) (int, error) {
+	counts, err := s.repo.CountUnreadNotifications([]int{userID})
+	if err != nil {
+		return 0, err
+	}
+
+	return counts[userID], nil
+}
+
+// MarkNotificationsRead marks notifications of userID as read and returns
+// how many it marked and how many are left unread.
+func (s *chatService) MarkNotificationsRead(userID int, req NotificationReadRequest) (int, int, error) {
+	marked, err := s.repo.MarkNotificationsRead(userID, req.IDs, req.ChatID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	unread, err := s.GetUnreadNotificationCount(userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if marked > 0 {
+		s.pushUnreadCount(userID, unread)
+	}
+
+	return marked, unread, nil
+}
+
+func (s *chatService) GetChatNotificationSettings(userID, chatID int) (*ChatNotificationSettings, error) {
+	if _, err := s.repo.GetUserRoleInChat(userID, chatID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetChatNotificationSettings(userID, chatID)
+}
+
+// UpdateChatNotificationSettings sets how userID is notified about chatID.
+// A MutedUntil in the past clears the mute.
+func (s *chatService) UpdateChatNotificationSettings(userID, chatID int, req ChatNotificationSettingsRequest) (*ChatNotificationSettings, error) {
+	if _, err := s.repo.GetUserRoleInChat(userID, chatID); err != nil {
+		return nil, err
+	}
+
+	mutedUntil := req.MutedUntil
+	if mutedUntil != nil && !mutedUntil.After(time.Now()) {
+		mutedUntil = nil
+	}
+
+	settings, err := s.repo.UpdateChatNotificationSettings(userID, ChatNotificationSettings{
+		ChatID:     chatID,
+		Level:      req.Level,
+		MutedUntil: mutedUntil,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"chat_id": chatID,
+		"level":   settings.Level,
+	}).Info("Chat notification settings updated")
+
+	return settings, nil
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkPinAccess allows owners, admins and moderators of chatID to pin and
+// unpin its messages.
+func (s *chatService) checkPinAccess(chatID, userID int) error {
+	role, err := s.repo.GetUserRoleInChat(userID, chatID)
+	if err != nil && err.Error() != "user not found in chat" {
+		return fmt.Errorf("failed to get user role: %w", err)
+	}
+
+	if !isChatModerator(role) {
+		return fmt.Errorf("insufficient permissions")
+	}
+
+	return nil
+}
+
+// PinMessage pins messageID in chatID for userID, up to MaxPins per chat.
+// The pin is broadcast to every member, so its message is not flagged for
+// anyone's blocks.
+func (s *chatService) PinMessage(chatID, messageID, userID int) (*PinnedMessage, error) {
+	if err := s.checkPinAccess(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.PinMessage(chatID, messageID, userID, s.cfg.MaxPins); err != nil {
+		return nil, err
+	}
+
+	pin, err := s.repo.GetPinnedMessage(chatID, messageID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"chat_id":    chatID,
+		"message_id": messageID,
+	}).Info("Message pinned")
+
+	return pin, nil
+}
+
+func (s *chatService) UnpinMessage(chatID, messageID, userID int) error {
+	if err := s.checkPinAccess(chatID, userID); err != nil {
+		return err
+	}
+
+	if err := s.repo.UnpinMessage(chatID, messageID); err != nil {
+		return err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"chat_id":    chatID,
+		"message_id": messageID,
+	}).Info("Message unpinned")
+
+	return nil
+}
+
+func (s *chatService) GetPinnedMessages(chatID, viewerID int) (*PinnedMessageListResponse, error) {
+	pins, err := s.repo.GetPinnedMessages(chatID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PinnedMessageListResponse{
+		Pins:  pins,
+		Total: len(pins),
+		Limit: s.cfg.MaxPins,
+	}, nil
+}
+
+// BroadcastPinEvent sends a pinned or unpinned event to the clients
+// connected to the chat. Clients too slow to take it miss the event and
+// can reload the pins.
+func (h *Hub) BroadcastPinEvent(event PinEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		h.logger.WithError(err).WithField("chat_id", event.ChatID).Error("Failed to marshal pin event")
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for clientID, client := range h.chats[event.ChatID] {
+		select {
+		case client.Send <- payload:
+		default:
+			h.logger.WithFields(logrus.Fields{
+				"client_id": clientID,
+				"chat_id":   event.ChatID,
+			}).Debug("Dropped pin event for slow client")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"onlineChat/pkg/redis"
+
+	"github.com/sirupsen/logrus"
+)
+
+// presenceTTL is how long a connection counts as live without a heartbeat.
+// Heartbeats are sent with every ping, so two missed pings mark it gone.
+const presenceTTL = 2 * pingPeriod
+
+// presenceEvent is published to every instance when a user's aggregate
+// presence changes. ChatIDs are the chats whose members should be told and
+// ContactIDs the contacts, who are told on their user sockets too. When
+// Restricted is set, only members listed in Audience are told.
+type presenceEvent struct {
+	UserID     int        `json:"user_id"`
+	Status     string     `json:"status"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	ChatIDs    []int      `json:"chat_ids"`
+	ContactIDs []int      `json:"contact_ids,omitempty"`
+	Restricted bool       `json:"restricted,omitempty"`
+	Audience   []int      `json:"audience,omitempty"`
+}
+
+// PresenceMessage is pushed to clients that share a chat with the user and
+// to the user's contacts.
+type PresenceMessage struct {
+	Type     string     `json:"type"`
+	UserID   int        `json:"user_id"`
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+func newConnectionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// presenceState is what this connection reports: away when the user said so
+// or has been idle for awayAfter, online otherwise.
+func (c *Client) presenceState(awayAfter time.Duration) string {
+	if c.away.Load() {
+		return redis.PresenceAway
+	}
+
+	idle := time.Since(time.Unix(0, c.lastActive.Load()))
+	if awayAfter > 0 && idle >= awayAfter {
+		return redis.PresenceAway
+	}
+
+	return redis.PresenceOnline
+}
+
+func (c *Client) markActive() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// setAway records a status reported by the client itself.
+func (c *Client) setAway(away bool) {
+	c.away.Store(away)
+	if !away {
+		c.markActive()
+	}
+}
+
+// touchPresence records a heartbeat for client's connection and announces the
+// user's status if it changed.
+func (h *Hub) touchPresence(client *Client) {
+	status, changed, err := h.redis.SetConnectionPresence(client.ID, client.ConnID, client.presenceState(h.presenceAwayAfter), presenceTTL)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", client.ID).Warn("Failed to record presence")
+		return
+	}
+
+	if changed {
+		go h.announcePresence(client.ID, status, nil)
+	}
+}
+
+// dropPresence removes client's connection. When it was the user's last one,
+// last_seen is updated and the user is announced offline.
+func (h *Hub) dropPresence(client *Client) {
+	status, changed, err := h.redis.RemoveConnectionPresence(client.ID, client.ConnID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", client.ID).Warn("Failed to remove presence")
+		return
+	}
+
+	if changed {
+		go h.presenceChanged(client.ID, status)
+	}
+}
+
+// sweepPresence finds users whose connections stopped sending heartbeats
+// without being closed, as when the instance holding them dies, and
+// announces their new status. Every instance sweeps; only the one that sees
+// a status change first announces it.
+func (h *Hub) sweepPresence() {
+	ticker := time.NewTicker(presenceTTL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := h.redis.SweepPresence()
+		if err != nil {
+			h.logger.WithError(err).Warn("Failed to sweep presence")
+		}
+
+		for userID, status := range changed {
+			h.presenceChanged(userID, status)
+		}
+	}
+}
+
+// presenceChanged announces a new aggregate status of userID. Going offline
+// also updates last_seen.
+func (h *Hub) presenceChanged(userID int, status string) {
+	var lastSeen *time.Time
+	if status == redis.PresenceOffline {
+		now := time.Now()
+		lastSeen = &now
+
+		if err := h.service.UpdateLastSeen(userID); err != nil {
+			h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to update last seen")
+		}
+	}
+
+	h.announcePresence(userID, status, lastSeen)
+}
+
+// announcePresence tells the members of userID's chats and their contacts
+// about a status change, limited to those the user's privacy settings allow.
+func (h *Hub) announcePresence(userID int, status string, lastSeen *time.Time) {
+	audience, all, err := h.service.GetPresenceAudience(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to get presence audience")
+		return
+	}
+	if !all && len(audience) == 0 {
+		return
+	}
+
+	chatIDs, err := h.service.GetUserChatIDs(userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to get chats for presence")
+		return
+	}
+
+	// A restricted audience is the user's contacts.
+	contactIDs := audience
+	if all {
+		if contactIDs, err = h.service.GetContactIDs(userID); err != nil {
+			h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to get contacts for presence")
+			return
+		}
+	}
+
+	if len(chatIDs) == 0 && len(contactIDs) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(presenceEvent{
+		UserID:     userID,
+		Status:     status,
+		LastSeen:   lastSeen,
+		ChatIDs:    chatIDs,
+		ContactIDs: contactIDs,
+		Restricted: !all,
+		Audience:   audience,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to marshal presence")
+		return
+	}
+
+	if err := h.redis.PublishPresence(payload); err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to publish presence")
+	}
+}
+
+// listenPresence delivers presence changes from every instance to the local
+// clients that share a chat with the user or are their contacts.
+func (h *Hub) listenPresence() {
+	pubsub := h.redis.SubscribePresence()
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		var event presenceEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			h.logger.WithError(err).Warn("Failed to parse presence event")
+			continue
+		}
+
+		// Delivery runs on the hub goroutine, which owns the clients' Send
+		// channels.
+		h.presence <- event
+	}
+}
+
+func (h *Hub) deliverPresence(event presenceEvent) {
+	data, err := json.Marshal(PresenceMessage{
+		Type:     "presence",
+		UserID:   event.UserID,
+		Status:   event.Status,
+		LastSeen: event.LastSeen,
+	})
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", event.UserID).Error("Failed to marshal presence")
+		return
+	}
+
+	var audience map[int]bool
+	if event.Restricted {
+		audience = make(map[int]bool, len(event.Audience))
+		for _, id := range event.Audience {
+			audience[id] = true
+		}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, chatID := range event.ChatIDs {
+		for clientID, client := range h.chats[chatID] {
+			if clientID == event.UserID || (audience != nil && !audience[clientID]) {
+				continue
+			}
+
+			// Presence is best effort; a full buffer is left to the next
+			// chat message to deal with.
+			select {
+			case client.Send <- data:
+			default:
+				h.logger.WithFields(logrus.Fields{
+					"client_id": clientID,
+					"chat_id":   chatID,
+				}).Debug("Dropped presence update for slow client")
+			}
+		}
+	}
+
+	for _, contactID := range event.ContactIDs {
+		for client := range h.users[contactID] {
+			select {
+			case client.Send <- data:
+			default:
+				h.logger.WithField("client_id", contactID).Debug("Dropped presence update for slow user socket")
+			}
+		}
+	}
+}
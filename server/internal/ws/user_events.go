@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"onlineChat/pkg/redis"
+
+	"github.com/sirupsen/logrus"
+)
+
+// User sockets are connections that are not tied to a chat. They receive
+// events addressed to the user, such as friend requests, and count towards
+// the user's presence. A user may hold several at once, one per device.
+
+func (h *Hub) registerUserClient(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.users[client.ID] == nil {
+		h.users[client.ID] = make(map[*Client]bool)
+	}
+	h.users[client.ID][client] = true
+
+	h.touchPresence(client)
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":  client.ID,
+		"username": client.Username,
+		"sockets":  len(h.users[client.ID]),
+	}).Info("User socket registered")
+}
+
+func (h *Hub) unregisterUserClient(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sockets, exists := h.users[client.ID]
+	if !exists || !sockets[client] {
+		return
+	}
+
+	h.dropPresence(client)
+
+	client.Close()
+	delete(sockets, client)
+	if len(sockets) == 0 {
+		delete(h.users, client.ID)
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":  client.ID,
+		"username": client.Username,
+	}).Info("User socket unregistered")
+}
+
+// listenUserEvents delivers events published for a user on any instance to
+// that user's local connections.
+func (h *Hub) listenUserEvents() {
+	pubsub := h.redis.SubscribeUserEvents()
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		var event redis.UserEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			h.logger.WithError(err).Warn("Failed to parse user event")
+			continue
+		}
+
+		// Delivery runs on the hub goroutine, which owns the clients' Send
+		// channels.
+		h.userEvents <- event
+	}
+}
+
+// deliverUserEvent sends an event to every socket of the user, both user
+// sockets and chat connections, so clients see it whichever they have open.
+func (h *Hub) deliverUserEvent(event redis.UserEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var clients []*Client
+	for client := range h.users[event.UserID] {
+		clients = append(clients, client)
+	}
+	for _, chat := range h.chats {
+		if client, exists := chat[event.UserID]; exists {
+			clients = append(clients, client)
+		}
+	}
+
+	for _, client := range clients {
+		select {
+		case client.Send <- event.Payload:
+		default:
+			h.logger.WithFields(logrus.Fields{
+				"user_id": event.UserID,
+				"chat_id": client.ChatID,
+			}).Debug("Dropped user event for slow client")
+		}
+	}
+}
+
+// listenUserDisconnects closes every connection of users disconnected on any
+// instance, such as deleted accounts.
+func (h *Hub) listenUserDisconnects() {
+	pubsub := h.redis.SubscribeUserDisconnects()
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		userID, err := strconv.Atoi(msg.Payload)
+		if err != nil {
+			continue
+		}
+		h.DisconnectUser(userID)
+	}
+}
+
+// DisconnectUser closes the user sockets and chat connections of userID.
+// Closing the socket makes readPump unregister the client.
+func (h *Hub) DisconnectUser(userID int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.users[userID] {
+		client.Connection.Close()
+	}
+	for _, chat := range h.chats {
+		if client, exists := chat[userID]; exists {
+			client.Connection.Close()
+		}
+	}
+
+	h.logger.WithField("user_id", userID).Info("Closed connections of disconnected user")
+}
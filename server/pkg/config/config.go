@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +16,11 @@ type Config struct {
 	Security SecurityConfig
 	Logging  LoggingConfig
 	Upload   UploadConfig
+	Chat     ChatConfig
+	Lockout  LockoutConfig
+	Mail     MailConfig
+	Auth     AuthConfig
+	OIDC     OIDCConfig
 }
 
 type DatabaseConfig struct {
@@ -33,20 +39,73 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret      string
-	ExpireHours int
+	Secret               string
+	SigningKeyFile       string
+	VerificationKeyFiles string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
 }
 
 type ServerConfig struct {
-	Port string
-	Host string
-	Mode string
+	Port   string
+	Host   string
+	Mode   string
+	AppURL string
 }
 
 type SecurityConfig struct {
-	CORSOrigin        string
-	RateLimitRequests int
-	RateLimitWindow   time.Duration
+	CORSOrigin            string
+	RateLimitRequests     int
+	RateLimitWindow       time.Duration
+	RateLimitStore        string
+	AuthRateLimitRequests int
+	AuthRateLimitWindow   time.Duration
+	// Refresh requests are limited per session, separately from the
+	// credential endpoints.
+	RefreshRateLimitRequests int
+	RefreshRateLimitWindow   time.Duration
+	// TrustedProxies may set X-Forwarded-For. Without any, the client IP
+	// is the address of the connection.
+	TrustedProxies []string
+}
+
+type LockoutConfig struct {
+	AccountThreshold int
+	IPThreshold      int
+	FailureWindow    time.Duration
+	BaseDuration     time.Duration
+	MaxDuration      time.Duration
+}
+
+type OIDCConfig struct {
+	ProviderName string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       string
+	StateTTL     time.Duration
+}
+
+type MailConfig struct {
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	OutputDir    string
+}
+
+type AuthConfig struct {
+	PasswordResetTTL      time.Duration
+	MailThrottle          time.Duration
+	EmailVerificationTTL  time.Duration
+	UnverifiedPolicy      string
+	TwoFactorIssuer       string
+	TwoFactorChallengeTTL time.Duration
+
+	AccountDeletionGrace time.Duration
 }
 
 type LoggingConfig struct {
@@ -54,9 +113,23 @@ type LoggingConfig struct {
 	Format string
 }
 
+type ChatConfig struct {
+	MessageRateLimit  int
+	MessageRateWindow time.Duration
+	PresenceAwayAfter time.Duration
+	ExportSyncLimit   int
+	MaxPins           int
+}
+
 type UploadConfig struct {
-	MaxFileSize int64
-	UploadPath  string
+	MaxFileSize   int64
+	UploadPath    string
+	AvatarSize    int
+	AvatarBaseURL string
+
+	ExportTTL        time.Duration
+	ExportLinkTTL    time.Duration
+	ExportLinkSecret string
 }
 
 func Load() (*Config, error) {
@@ -75,18 +148,64 @@ func Load() (*Config, error) {
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
 		JWT: JWTConfig{
-			Secret:      getEnv("JWT_SECRET", "default-secret-change-this"),
-			ExpireHours: getEnvAsInt("JWT_EXPIRE_HOURS", 24),
+			Secret:               getEnv("JWT_SECRET", "default-secret-change-this"),
+			SigningKeyFile:       getEnv("JWT_SIGNING_KEY_FILE", ""),
+			VerificationKeyFiles: getEnv("JWT_VERIFICATION_KEY_FILES", ""),
+			AccessTokenTTL:       getEnvAsDuration("JWT_ACCESS_TTL", "15m"),
+			RefreshTokenTTL:      getEnvAsDuration("JWT_REFRESH_TTL", "720h"),
 		},
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", ":8080"),
-			Host: getEnv("SERVER_HOST", "localhost"),
-			Mode: getEnv("GIN_MODE", "debug"),
+			Port:   getEnv("SERVER_PORT", ":8080"),
+			Host:   getEnv("SERVER_HOST", "localhost"),
+			Mode:   getEnv("GIN_MODE", "debug"),
+			AppURL: getEnv("APP_URL", "http://localhost:3000"),
 		},
 		Security: SecurityConfig{
-			CORSOrigin:        getEnv("CORS_ORIGIN", "*"),
-			RateLimitRequests: getEnvAsInt("RATE_LIMIT_REQUESTS", 100),
-			RateLimitWindow:   getEnvAsDuration("RATE_LIMIT_WINDOW", "1m"),
+			CORSOrigin:               getEnv("CORS_ORIGIN", "*"),
+			RateLimitRequests:        getEnvAsInt("RATE_LIMIT_REQUESTS", 100),
+			RateLimitWindow:          getEnvAsDuration("RATE_LIMIT_WINDOW", "1m"),
+			RateLimitStore:           getEnv("RATE_LIMIT_STORE", "redis"),
+			AuthRateLimitRequests:    getEnvAsInt("AUTH_RATE_LIMIT_REQUESTS", 10),
+			AuthRateLimitWindow:      getEnvAsDuration("AUTH_RATE_LIMIT_WINDOW", "15m"),
+			RefreshRateLimitRequests: getEnvAsInt("REFRESH_RATE_LIMIT_REQUESTS", 60),
+			RefreshRateLimitWindow:   getEnvAsDuration("REFRESH_RATE_LIMIT_WINDOW", "1m"),
+			TrustedProxies:           getEnvAsList("TRUSTED_PROXIES"),
+		},
+		Lockout: LockoutConfig{
+			AccountThreshold: getEnvAsInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+			IPThreshold:      getEnvAsInt("LOGIN_LOCKOUT_IP_THRESHOLD", 20),
+			FailureWindow:    getEnvAsDuration("LOGIN_FAILURE_WINDOW", "15m"),
+			BaseDuration:     getEnvAsDuration("LOGIN_LOCKOUT_BASE", "1m"),
+			MaxDuration:      getEnvAsDuration("LOGIN_LOCKOUT_MAX", "1h"),
+		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "OnlineChat <no-reply@onlinechat.local>"),
+			SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:     getEnvAsInt("SMTP_PORT", 1025),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			OutputDir:    getEnv("MAIL_OUTPUT_DIR", ""),
+		},
+		Auth: AuthConfig{
+			PasswordResetTTL:     getEnvAsDuration("PASSWORD_RESET_TTL", "1h"),
+			MailThrottle:         getEnvAsDuration("AUTH_MAIL_THROTTLE", "5m"),
+			EmailVerificationTTL: getEnvAsDuration("EMAIL_VERIFICATION_TTL", "48h"),
+			UnverifiedPolicy:     getEnv("AUTH_UNVERIFIED_POLICY", "restricted"),
+
+			TwoFactorIssuer:       getEnv("TWO_FACTOR_ISSUER", "OnlineChat"),
+			TwoFactorChallengeTTL: getEnvAsDuration("TWO_FACTOR_CHALLENGE_TTL", "5m"),
+
+			AccountDeletionGrace: getEnvAsDuration("ACCOUNT_DELETION_GRACE", "336h"),
+		},
+		OIDC: OIDCConfig{
+			ProviderName: getEnv("OIDC_PROVIDER_NAME", "corporate"),
+			Issuer:       getEnv("OIDC_ISSUER", ""),
+			ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+			ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:3000/auth/oidc/callback"),
+			Scopes:       getEnv("OIDC_SCOPES", "openid email profile"),
+			StateTTL:     getEnvAsDuration("OIDC_STATE_TTL", "10m"),
 		},
 		Logging: LoggingConfig{
 			Level:  getEnv("LOG_LEVEL", "info"),
@@ -95,11 +214,25 @@ func Load() (*Config, error) {
 		Upload: UploadConfig{
 			MaxFileSize: getEnvAsInt64("MAX_FILE_SIZE", 10485760), // 10MB
 			UploadPath:  getEnv("UPLOAD_PATH", "./uploads"),
+
+			AvatarSize:    getEnvAsInt("AVATAR_SIZE", 256),
+			AvatarBaseURL: getEnv("AVATAR_BASE_URL", "/avatars"),
+
+			ExportTTL:        getEnvAsDuration("EXPORT_TTL", "72h"),
+			ExportLinkTTL:    getEnvAsDuration("EXPORT_LINK_TTL", "15m"),
+			ExportLinkSecret: getEnv("EXPORT_LINK_SECRET", ""),
+		},
+		Chat: ChatConfig{
+			MessageRateLimit:  getEnvAsInt("CHAT_MESSAGE_RATE_LIMIT", 20),
+			MessageRateWindow: getEnvAsDuration("CHAT_MESSAGE_RATE_WINDOW", "10s"),
+			PresenceAwayAfter: getEnvAsDuration("PRESENCE_AWAY_AFTER", "5m"),
+			ExportSyncLimit:   getEnvAsInt("CHAT_EXPORT_SYNC_LIMIT", 5000),
+			MaxPins:           getEnvAsInt("CHAT_MAX_PINS", 50),
 		},
 	}
 
-	if config.JWT.Secret == "default-secret-change-this" {
-		return nil, fmt.Errorf("JWT_SECRET must be set to a secure value")
+	if config.JWT.SigningKeyFile == "" && config.JWT.Secret == "default-secret-change-this" {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE or a secure JWT_SECRET must be set")
 	}
 
 	return config, nil
@@ -130,6 +263,18 @@ func getEnvAsInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// getEnvAsList splits a comma separated variable, or returns nil when it is
+// unset.
+func getEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getEnvAsDuration(key string, defaultValue string) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE chats ADD COLUMN slow_mode_seconds INT NOT NULL DEFAULT 0;
+
+ALTER TABLE chats ADD CONSTRAINT check_slow_mode_seconds CHECK (slow_mode_seconds >= 0 AND slow_mode_seconds <= 21600);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE chats DROP CONSTRAINT IF EXISTS check_slow_mode_seconds;
+ALTER TABLE chats DROP COLUMN IF EXISTS slow_mode_seconds;
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE friend_requests (
+    id SERIAL PRIMARY KEY,
+    sender_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMP WITH TIME ZONE,
+    CHECK (sender_id <> recipient_id)
+);
+
+-- At most one pending request between two users, in either direction.
+CREATE UNIQUE INDEX idx_friend_requests_pending_pair
+    ON friend_requests (LEAST(sender_id, recipient_id), GREATEST(sender_id, recipient_id))
+    WHERE status = 'pending';
+CREATE INDEX idx_friend_requests_recipient_id ON friend_requests(recipient_id) WHERE status = 'pending';
+CREATE INDEX idx_friend_requests_sender_id ON friend_requests(sender_id) WHERE status = 'pending';
+
+-- Each friendship is stored once per side so nicknames are private.
+CREATE TABLE contacts (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    contact_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    nickname VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, contact_id),
+    CHECK (user_id <> contact_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS contacts;
+DROP INDEX IF EXISTS idx_friend_requests_sender_id;
+DROP INDEX IF EXISTS idx_friend_requests_recipient_id;
+DROP INDEX IF EXISTS idx_friend_requests_pending_pair;
+DROP TABLE IF EXISTS friend_requests;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Users without a row use the defaults below.
+CREATE TABLE user_settings (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    direct_messages VARCHAR(20) NOT NULL DEFAULT 'everyone' CHECK (direct_messages IN ('everyone', 'contacts', 'nobody')),
+    presence_visibility VARCHAR(20) NOT NULL DEFAULT 'everyone' CHECK (presence_visibility IN ('everyone', 'contacts', 'nobody')),
+    searchable BOOLEAN NOT NULL DEFAULT true,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_settings;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE account_deletions (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    messages VARCHAR(20) NOT NULL DEFAULT 'anonymize' CHECK (messages IN ('anonymize', 'delete')),
+    chats VARCHAR(20) NOT NULL DEFAULT 'transfer' CHECK (chats IN ('transfer', 'close')),
+    requested_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    scheduled_for TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_account_deletions_scheduled_for ON account_deletions(scheduled_for);
+
+-- Anonymised messages outlive their sender.
+ALTER TABLE messages ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE messages DROP CONSTRAINT messages_user_id_fkey;
+ALTER TABLE messages ADD CONSTRAINT messages_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM messages WHERE user_id IS NULL;
+ALTER TABLE messages DROP CONSTRAINT messages_user_id_fkey;
+ALTER TABLE messages ADD CONSTRAINT messages_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE messages ALTER COLUMN user_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_account_deletions_scheduled_for;
+DROP TABLE IF EXISTS account_deletions;
+-- +goose StatementEnd
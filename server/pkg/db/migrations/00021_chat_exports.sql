@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE chat_exports (
+    id SERIAL PRIMARY KEY,
+    chat_id INT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('json', 'csv', 'html', 'txt')),
+    from_time TIMESTAMP WITH TIME ZONE,
+    to_time TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'expired')),
+    file_name TEXT,
+    size_bytes BIGINT,
+    message_count INT,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_chat_exports_chat_id ON chat_exports(chat_id, created_at DESC);
+CREATE INDEX idx_chat_exports_status ON chat_exports(status);
+
+-- One background export per user and chat at a time.
+CREATE UNIQUE INDEX idx_chat_exports_unfinished ON chat_exports(chat_id, user_id) WHERE status IN ('pending', 'processing');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_chat_exports_unfinished;
+DROP INDEX IF EXISTS idx_chat_exports_status;
+DROP INDEX IF EXISTS idx_chat_exports_chat_id;
+DROP TABLE IF EXISTS chat_exports;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Links records of an imported archive to the rows created for them, so an
+-- import can be run again to resume or pick up new history.
+CREATE TABLE import_mappings (
+    source VARCHAR(50) NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('user', 'chat', 'message')),
+    scope TEXT NOT NULL DEFAULT '',
+    external_id TEXT NOT NULL,
+    local_id INT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (source, kind, scope, external_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS import_mappings;
+-- +goose StatementEnd
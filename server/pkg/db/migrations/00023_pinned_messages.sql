@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE pinned_messages (
+    message_id INT PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+    chat_id INT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    pinned_by INT REFERENCES users(id) ON DELETE SET NULL,
+    pinned_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_pinned_messages_chat_id ON pinned_messages(chat_id, pinned_at DESC);
+
+-- Messages are soft deleted, so the foreign key alone only covers hard
+-- deletes. Drop the pin as soon as its message is marked deleted.
+CREATE FUNCTION unpin_deleted_message() RETURNS TRIGGER AS $$
+BEGIN
+    DELETE FROM pinned_messages WHERE message_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_messages_unpin_deleted
+    AFTER UPDATE OF is_deleted ON messages
+    FOR EACH ROW
+    WHEN (NEW.is_deleted AND NOT COALESCE(OLD.is_deleted, false))
+    EXECUTE FUNCTION unpin_deleted_message();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS trg_messages_unpin_deleted ON messages;
+DROP FUNCTION IF EXISTS unpin_deleted_message();
+DROP INDEX IF EXISTS idx_pinned_messages_chat_id;
+DROP TABLE IF EXISTS pinned_messages;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE messages ADD COLUMN mentions JSONB;
+
+CREATE TABLE message_mentions (
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chat_id INT NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('user', 'here', 'all')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_mentions_user_id ON message_mentions(user_id, created_at DESC);
+CREATE INDEX idx_message_mentions_unread ON message_mentions(user_id, chat_id) WHERE read_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_message_mentions_unread;
+DROP INDEX IF EXISTS idx_message_mentions_user_id;
+DROP TABLE IF EXISTS message_mentions;
+ALTER TABLE messages DROP COLUMN IF EXISTS mentions;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Email addresses are matched case-insensitively, so two accounts may not
+-- differ only in the case of their address.
+CREATE UNIQUE INDEX idx_users_email_lower ON users(LOWER(email));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_email_lower;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+package jwtauth
+
+import "github.com/golang-jwt/jwt/v5"
+
+// Claims are the claims of the access tokens issued by the users service.
+// Both the service that signs them and every verifier parse them into this
+// type.
+type Claims struct {
+	UserID        int    `json:"user_id"`
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+
+	// Set only for personal access tokens, which are never encoded as JWTs.
+	IsBot  bool     `json:"-"`
+	Scopes []string `json:"-"`
+
+	jwt.RegisteredClaims
+}
+
+// SessionID returns the session the token was issued for, which is carried in
+// the jti claim.
+func (c *Claims) SessionID() string {
+	return c.ID
+}
@@ -0,0 +1,312 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"onlineChat/pkg/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet signs access tokens with one private key and verifies them against
+// every configured public key, identified by the kid header. Keeping the
+// previous public key configured after switching to a new signing key lets
+// tokens signed with the old key run out instead of being rejected.
+//
+// Without a signing key the set falls back to HS256 with the shared secret.
+type KeySet struct {
+	signing      *verificationKey
+	signer       crypto.Signer
+	verification map[string]*verificationKey
+	secret       []byte
+}
+
+type verificationKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	publicKey crypto.PublicKey
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeySet(cfg config.JWTConfig) (*KeySet, error) {
+	ks := &KeySet{
+		verification: make(map[string]*verificationKey),
+	}
+
+	if cfg.SigningKeyFile == "" {
+		ks.secret = []byte(cfg.Secret)
+		return ks, nil
+	}
+
+	signer, err := loadPrivateKey(cfg.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signing, err := newVerificationKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", cfg.SigningKeyFile, err)
+	}
+
+	ks.signer = signer
+	ks.signing = signing
+	ks.verification[signing.kid] = signing
+
+	for _, path := range strings.Split(cfg.VerificationKeyFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		publicKey, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := newVerificationKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key %s: %w", path, err)
+		}
+		ks.verification[key.kid] = key
+	}
+
+	return ks, nil
+}
+
+// Symmetric reports whether tokens are signed with the shared HS256 secret.
+func (ks *KeySet) Symmetric() bool {
+	return ks.signing == nil
+}
+
+func (ks *KeySet) Sign(claims *Claims) (string, error) {
+	if ks.Symmetric() {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString(ks.secret)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign token: %w", err)
+		}
+		return tokenString, nil
+	}
+
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.kid
+
+	tokenString, err := token.SignedString(ks.signer)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+func (ks *KeySet) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	methods := []string{jwt.SigningMethodHS256.Alg()}
+	if !ks.Symmetric() {
+		methods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, ks.keyFunc,
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	return claims, nil
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if ks.Symmetric() {
+		return ks.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, exists := ks.verification[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("signing method %s does not match key %q", token.Method.Alg(), kid)
+	}
+
+	return key.publicKey, nil
+}
+
+// JWKS returns the public verification keys. It is empty when tokens are
+// signed with the shared secret, which must never be published.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.verification))}
+
+	if ks.signing != nil {
+		jwks.Keys = append(jwks.Keys, ks.signing.jwk())
+	}
+	for kid, key := range ks.verification {
+		if ks.signing != nil && kid == ks.signing.kid {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, key.jwk())
+	}
+
+	return jwks
+}
+
+func newVerificationKey(publicKey crypto.PublicKey) (*verificationKey, error) {
+	key := &verificationKey{publicKey: publicKey}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", publicKey)
+	}
+
+	key.kid = key.thumbprint()
+
+	return key, nil
+}
+
+func (k *verificationKey) jwk() JWK {
+	jwk := JWK{
+		Use: "sig",
+		Alg: k.method.Alg(),
+		Kid: k.kid,
+	}
+
+	switch publicKey := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, which is used as the kid
+// so that it stays the same wherever the key is loaded.
+func (k *verificationKey) thumbprint() string {
+	jwk := k.jwk()
+
+	var members map[string]string
+	if jwk.Kty == "RSA" {
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	} else {
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+
+	// encoding/json sorts map keys, which gives the required member order.
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T in %s", key, path)
+	}
+
+	return signer, nil
+}
+
+// loadPublicKey reads a public key, or the public half of a private key.
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+		}
+		return key, nil
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+		}
+		return key, nil
+	default:
+		signer, err := loadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return signer.Public(), nil
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	return block, nil
+}
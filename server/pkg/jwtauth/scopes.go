@@ -0,0 +1,49 @@
+package jwtauth
+
+import "strings"
+
+// Scopes a personal access token can be granted. Access tokens from a login
+// carry no scopes and may do anything the user can.
+const (
+	ScopeChatsRead     = "chats:read"
+	ScopeChatsWrite    = "chats:write"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeProfileRead   = "profile:read"
+)
+
+// PersonalTokenPrefix starts every personal access token, which tells them
+// apart from JWTs and makes leaked tokens easy to scan for.
+const PersonalTokenPrefix = "ocpat_"
+
+var validScopes = map[string]bool{
+	ScopeChatsRead:     true,
+	ScopeChatsWrite:    true,
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+	ScopeProfileRead:   true,
+}
+
+func ValidScope(scope string) bool {
+	return validScopes[scope]
+}
+
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// HasScope reports whether the claims allow scope. Claims without a scope
+// list come from an interactive login and allow everything.
+func (c *Claims) HasScope(scope string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+
+	for _, granted := range c.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,30 +5,36 @@ import (
 	"net/http"
 	"strings"
 
+	"onlineChat/pkg/jwtauth"
+	"onlineChat/pkg/redis"
+	"onlineChat/pkg/utils"
+
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
 )
 
+// PersonalTokenAuthenticator resolves personal access tokens, which are
+// opaque and have to be looked up rather than verified like JWTs.
+type PersonalTokenAuthenticator interface {
+	AuthenticatePersonalToken(token string) (*jwtauth.Claims, error)
+}
+
 type AuthMiddleware struct {
-	jwtSecret string
-	logger    *logrus.Logger
+	keys   *jwtauth.KeySet
+	tokens PersonalTokenAuthenticator
+	redis  *redis.RedisClient
+	logger *logrus.Logger
 }
 
-func NewAuthMiddleware(jwtSecret string, logger *logrus.Logger) *AuthMiddleware {
+func NewAuthMiddleware(keys *jwtauth.KeySet, tokens PersonalTokenAuthenticator, redisClient *redis.RedisClient, logger *logrus.Logger) *AuthMiddleware {
 	return &AuthMiddleware{
-		jwtSecret: jwtSecret,
-		logger:    logger,
+		keys:   keys,
+		tokens: tokens,
+		redis:  redisClient,
+		logger: logger,
 	}
 }
 
-type JWTClaims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	jwt.RegisteredClaims
-}
-
 func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString, err := am.extractToken(c)
@@ -40,7 +46,23 @@ func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := am.validateToken(tokenString)
+		if jwtauth.IsPersonalToken(tokenString) {
+			claims, err := am.tokens.AuthenticatePersonalToken(tokenString)
+			if err != nil {
+				am.logger.WithError(err).Warn("Invalid personal access token")
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid or expired token",
+				})
+				c.Abort()
+				return
+			}
+
+			setClaims(c, claims)
+			c.Next()
+			return
+		}
+
+		claims, err := am.keys.Parse(tokenString)
 		if err != nil {
 			am.logger.WithError(err).Warn("Invalid JWT token")
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -50,9 +72,87 @@ func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("email", claims.Email)
+		if claims.SessionID() != "" {
+			// Without the denylist a revoked session would look valid, so
+			// refuse the request rather than let it through.
+			revoked, err := am.redis.IsSessionRevoked(claims.SessionID())
+			if err != nil {
+				am.logger.WithError(err).WithField("session_id", claims.SessionID()).Error("Failed to check session revocation")
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": "Authentication temporarily unavailable",
+				})
+				c.Abort()
+				return
+			}
+			if revoked {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Session has been revoked",
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		setClaims(c, claims)
+		c.Next()
+	}
+}
+
+func setClaims(c *gin.Context, claims *jwtauth.Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("email", claims.Email)
+	c.Set("session_id", claims.SessionID())
+	c.Set("email_verified", claims.EmailVerified)
+	c.Set("is_bot", claims.IsBot)
+	c.Set("scopes", claims.Scopes)
+}
+
+// RequireScope rejects personal access tokens that were not granted scope.
+// Logged-in users pass regardless. It must run after RequireAuth.
+func (am *AuthMiddleware) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !utils.HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":          "Token is missing a required scope",
+				"required_scope": scope,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireUserSession rejects personal access tokens, for endpoints that manage
+// the account itself. It must run after RequireAuth.
+func (am *AuthMiddleware) RequireUserSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if utils.IsPersonalToken(c) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Personal access tokens cannot be used for this endpoint",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail rejects users whose email address has not been
+// verified. It must run after RequireAuth.
+func (am *AuthMiddleware) RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("email_verified") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                 "Email address has not been verified",
+				"verification_required": true,
+			})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
@@ -93,22 +193,3 @@ func (am *AuthMiddleware) extractToken(c *gin.Context) (string, error) {
 
 	return parts[1], nil
 }
-
-func (am *AuthMiddleware) validateToken(tokenString string) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(am.jwtSecret), nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
-	}
-
-	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-		return claims, nil
-	}
-
-	return nil, fmt.Errorf("invalid token claims")
-}
@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"onlineChat/pkg/redis"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	// Subject, when set, names the caller the policy counts requests of in
+	// place of the user or client IP.
+	Subject func(c *gin.Context) string
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	ResetIn   time.Duration
+}
+
+type RateLimitStore interface {
+	Allow(key string, limit int, window time.Duration) (*RateLimitResult, error)
+}
+
+type RateLimiter struct {
+	store  RateLimitStore
+	logger *logrus.Logger
+}
+
+func NewRateLimiter(store RateLimitStore, logger *logrus.Logger) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Limit enforces policy per caller: by user ID when the request has already
+// been authenticated and by client IP otherwise.
+func (rl *RateLimiter) Limit(policy RateLimitPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := rateLimitSubject
+		if policy.Subject != nil {
+			subject = policy.Subject
+		}
+		key := fmt.Sprintf("%s:%s", policy.Name, subject(c))
+
+		result, err := rl.store.Allow(key, policy.Limit, policy.Window)
+		if err != nil {
+			rl.logger.WithError(err).WithField("policy", policy.Name).Warn("Rate limit store unavailable")
+			c.Next()
+			return
+		}
+
+		resetIn := int(math.Ceil(result.ResetIn.Seconds()))
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(result.ResetIn).Unix(), 10))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(resetIn))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Rate limit exceeded",
+				"retry_after": resetIn,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func rateLimitSubject(c *gin.Context) string {
+	if userID, exists := c.Get("user_id"); exists {
+		if id, ok := userID.(int); ok {
+			return fmt.Sprintf("user:%d", id)
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+type windowCounter struct {
+	start    time.Time
+	window   time.Duration
+	current  int
+	previous int
+}
+
+// MemoryRateLimitStore approximates a sliding window by weighting the count
+// of the previous fixed window. It only limits requests seen by this process.
+type MemoryRateLimitStore struct {
+	counters map[string]*windowCounter
+	mu       sync.Mutex
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{
+		counters: make(map[string]*windowCounter),
+	}
+
+	go store.cleanup(time.Minute)
+
+	return store
+}
+
+func (s *MemoryRateLimitStore) Allow(key string, limit int, window time.Duration) (*RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	start := now.Truncate(window)
+
+	counter, exists := s.counters[key]
+	if !exists {
+		counter = &windowCounter{start: start, window: window}
+		s.counters[key] = counter
+	}
+
+	if !counter.start.Equal(start) {
+		if start.Sub(counter.start) == window {
+			counter.previous = counter.current
+		} else {
+			counter.previous = 0
+		}
+		counter.current = 0
+		counter.start = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := float64(counter.previous)*weight + float64(counter.current)
+
+	result := &RateLimitResult{
+		Limit:   limit,
+		ResetIn: start.Add(window).Sub(now),
+	}
+
+	if estimated+1 > float64(limit) {
+		if counter.current < limit && counter.previous > 0 {
+			// Time at which the weighted previous window drops enough to
+			// leave room for one more request.
+			free := float64(limit-counter.current-1) / float64(counter.previous)
+			result.ResetIn = time.Duration((1-free)*float64(window)) - elapsed
+		}
+		if result.ResetIn < 0 {
+			result.ResetIn = 0
+		}
+		return result, nil
+	}
+
+	counter.current++
+	result.Allowed = true
+	result.Remaining = limit - int(math.Ceil(estimated)) - 1
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	return result, nil
+}
+
+func (s *MemoryRateLimitStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		for key, counter := range s.counters {
+			// Both windows have passed, so the counter would reset anyway.
+			if time.Since(counter.start) >= 2*counter.window {
+				delete(s.counters, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RedisRateLimitStore keeps an exact sliding window log in Redis so that
+// limits are shared between server instances.
+type RedisRateLimitStore struct {
+	redis *redis.RedisClient
+}
+
+func NewRedisRateLimitStore(redisClient *redis.RedisClient) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		redis: redisClient,
+	}
+}
+
+func (s *RedisRateLimitStore) Allow(key string, limit int, window time.Duration) (*RateLimitResult, error) {
+	allowed, count, resetIn, err := s.redis.SlidingWindowAllow(key, limit, window)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: remaining,
+		ResetIn:   resetIn,
+	}, nil
+}
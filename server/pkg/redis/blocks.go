@@ -0,0 +1,23 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const BlocksChangedChannel = "user_blocks_changed"
+
+// PublishBlocksChanged tells every instance that userID blocked or unblocked
+// someone, so open connections can reload the user's block list.
+func (r *RedisClient) PublishBlocksChanged(userID int) {
+	ctx := context.Background()
+
+	if err := r.Client.Publish(ctx, BlocksChangedChannel, userID).Err(); err != nil {
+		r.logger.WithError(err).WithField("user_id", userID).Warn("Failed to publish block change")
+	}
+}
+
+func (r *RedisClient) SubscribeBlockChanges() *redis.PubSub {
+	return r.Client.Subscribe(context.Background(), BlocksChangedChannel)
+}
@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const OIDCStateKeyPrefix = "oidc_state:"
+
+// SetOIDCState stores the data needed to finish an OIDC login under its state
+// parameter.
+func (r *RedisClient) SetOIDCState(state, data string, ttl time.Duration) error {
+	ctx := context.Background()
+
+	if err := r.Client.Set(ctx, OIDCStateKeyPrefix+state, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store oidc state: %w", err)
+	}
+
+	return nil
+}
+
+// TakeOIDCState returns and deletes the data stored for state, so that every
+// authorization response can be redeemed only once. It returns an empty
+// string if the state is unknown or has expired.
+func (r *RedisClient) TakeOIDCState(state string) (string, error) {
+	ctx := context.Background()
+
+	data, err := r.Client.GetDel(ctx, OIDCStateKeyPrefix+state).Result()
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get oidc state: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,209 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// PresenceConnsKeyPrefix holds a sorted set of a user's open connections,
+	// scored by the time their heartbeat expires.
+	PresenceConnsKeyPrefix = "presence_conns:"
+	// PresenceStateKeyPrefix maps each connection to its reported state.
+	PresenceStateKeyPrefix = "presence_state:"
+	// PresenceStatusKeyPrefix remembers the last aggregate status so changes
+	// are only announced once.
+	PresenceStatusKeyPrefix = "presence_status:"
+	// PresenceUsersKey is a sorted set of users who are not offline, scored
+	// by the time their latest heartbeat expires. It finds users whose
+	// connections were never closed, such as those of an instance that died.
+	PresenceUsersKey = "presence_users"
+
+	PresenceChannel = "presence"
+
+	PresenceStatusTTL = 24 * time.Hour
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// SetConnectionPresence records a heartbeat for one connection of userID in
+// the given state, valid for ttl. It returns the user's aggregate status and
+// whether it differs from the last one returned.
+func (r *RedisClient) SetConnectionPresence(userID int, connID, state string, ttl time.Duration) (string, bool, error) {
+	ctx := context.Background()
+
+	connsKey := fmt.Sprintf("%s%d", PresenceConnsKeyPrefix, userID)
+	stateKey := fmt.Sprintf("%s%d", PresenceStateKeyPrefix, userID)
+	expiresAt := time.Now().Add(ttl).UnixMilli()
+
+	pipe := r.Client.TxPipeline()
+	pipe.ZAdd(ctx, connsKey, &redis.Z{Score: float64(expiresAt), Member: connID})
+	pipe.HSet(ctx, stateKey, connID, state)
+	pipe.Expire(ctx, connsKey, ttl)
+	pipe.Expire(ctx, stateKey, ttl)
+	pipe.ZAdd(ctx, PresenceUsersKey, &redis.Z{Score: float64(expiresAt), Member: userID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", false, fmt.Errorf("failed to record presence: %w", err)
+	}
+
+	return r.refreshPresence(ctx, userID)
+}
+
+// RemoveConnectionPresence forgets a closed connection of userID and returns
+// the aggregate status like SetConnectionPresence.
+func (r *RedisClient) RemoveConnectionPresence(userID int, connID string) (string, bool, error) {
+	ctx := context.Background()
+
+	pipe := r.Client.TxPipeline()
+	pipe.ZRem(ctx, fmt.Sprintf("%s%d", PresenceConnsKeyPrefix, userID), connID)
+	pipe.HDel(ctx, fmt.Sprintf("%s%d", PresenceStateKeyPrefix, userID), connID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", false, fmt.Errorf("failed to remove presence: %w", err)
+	}
+
+	return r.refreshPresence(ctx, userID)
+}
+
+// GetPresence returns the aggregate status of each user. Users without a live
+// connection are offline.
+func (r *RedisClient) GetPresence(userIDs []int) (map[int]string, error) {
+	ctx := context.Background()
+
+	statuses := make(map[int]string, len(userIDs))
+	for _, userID := range userIDs {
+		status, err := r.aggregatePresence(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		statuses[userID] = status
+	}
+
+	return statuses, nil
+}
+
+// SweepPresence refreshes the users whose latest heartbeat has expired and
+// returns those whose aggregate status changed, with their new status.
+func (r *RedisClient) SweepPresence() (map[int]string, error) {
+	ctx := context.Background()
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	members, err := r.Client.ZRangeByScore(ctx, PresenceUsersKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stale presence: %w", err)
+	}
+
+	changed := make(map[int]string)
+	for _, member := range members {
+		userID, err := strconv.Atoi(member)
+		if err != nil {
+			r.Client.ZRem(ctx, PresenceUsersKey, member)
+			continue
+		}
+
+		status, ok, err := r.refreshPresence(ctx, userID)
+		if err != nil {
+			return changed, err
+		}
+		if ok {
+			changed[userID] = status
+		}
+	}
+
+	return changed, nil
+}
+
+func (r *RedisClient) PublishPresence(payload []byte) error {
+	ctx := context.Background()
+
+	if err := r.Client.Publish(ctx, PresenceChannel, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish presence: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisClient) SubscribePresence() *redis.PubSub {
+	return r.Client.Subscribe(context.Background(), PresenceChannel)
+}
+
+func (r *RedisClient) refreshPresence(ctx context.Context, userID int) (string, bool, error) {
+	status, err := r.aggregatePresence(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+
+	statusKey := fmt.Sprintf("%s%d", PresenceStatusKeyPrefix, userID)
+	previous, err := r.Client.GetSet(ctx, statusKey, status).Result()
+	if err != nil && err.Error() != "redis: nil" {
+		return "", false, fmt.Errorf("failed to update presence status: %w", err)
+	}
+	r.Client.Expire(ctx, statusKey, PresenceStatusTTL)
+
+	if status == PresenceOffline {
+		r.Client.ZRem(ctx, PresenceUsersKey, userID)
+	}
+
+	if previous == "" {
+		previous = PresenceOffline
+	}
+
+	return status, previous != status, nil
+}
+
+// aggregatePresence drops connections whose heartbeat has expired and
+// combines the rest: online if any connection is online, away if all live
+// connections are away.
+func (r *RedisClient) aggregatePresence(ctx context.Context, userID int) (string, error) {
+	connsKey := fmt.Sprintf("%s%d", PresenceConnsKeyPrefix, userID)
+	stateKey := fmt.Sprintf("%s%d", PresenceStateKeyPrefix, userID)
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	expired, err := r.Client.ZRangeByScore(ctx, connsKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get presence: %w", err)
+	}
+	if len(expired) > 0 {
+		members := make([]interface{}, len(expired))
+		for i, connID := range expired {
+			members[i] = connID
+		}
+
+		pipe := r.Client.TxPipeline()
+		pipe.ZRem(ctx, connsKey, members...)
+		pipe.HDel(ctx, stateKey, expired...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return "", fmt.Errorf("failed to prune presence: %w", err)
+		}
+	}
+
+	conns, err := r.Client.ZRange(ctx, connsKey, 0, -1).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get presence: %w", err)
+	}
+	if len(conns) == 0 {
+		return PresenceOffline, nil
+	}
+
+	states, err := r.Client.HMGet(ctx, stateKey, conns...).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to get presence: %w", err)
+	}
+
+	status := PresenceAway
+	for _, state := range states {
+		if state == PresenceOnline {
+			status = PresenceOnline
+			break
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	MessageRateKeyPrefix = "msg_rate:"
+	SlowModeKeyPrefix    = "slow_mode:"
+	ChatSlowModePrefix   = "chat_slow_mode:"
+	RateLimitKeyPrefix   = "ratelimit:"
+)
+
+// slidingWindowScript keeps a log of request timestamps in a sorted set and
+// admits a request only if fewer than limit requests fall inside the window.
+// It returns {allowed, count, reset_ms} where reset_ms is the time until the
+// oldest logged request leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+
+local allowed = 0
+local count = redis.call('ZCARD', key)
+if count < limit then
+	redis.call('ZADD', key, now, member)
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+return {allowed, count, reset}
+`)
+
+// AllowMessage counts a message from userID in chatID against a fixed window
+// of the given length. When the limit is exceeded it reports how long the
+// user has to wait before the window resets.
+func (r *RedisClient) AllowMessage(chatID, userID, limit int, window time.Duration) (bool, time.Duration, error) {
+	ctx := context.Background()
+
+	rateKey := fmt.Sprintf("%s%d:%d", MessageRateKeyPrefix, chatID, userID)
+
+	result, err := incrWindowScript.Run(ctx, r.Client, []string{rateKey}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return true, 0, fmt.Errorf("failed to increment message rate: %w", err)
+	}
+
+	if result[0] > int64(limit) {
+		return false, time.Duration(result[1]) * time.Millisecond, nil
+	}
+
+	return true, 0, nil
+}
+
+// CheckSlowMode reserves the next send slot for userID in chatID. If the user
+// already sent a message within the interval, the time left is returned.
+func (r *RedisClient) CheckSlowMode(chatID, userID int, interval time.Duration) (bool, time.Duration, error) {
+	ctx := context.Background()
+
+	slowModeKey := fmt.Sprintf("%s%d:%d", SlowModeKeyPrefix, chatID, userID)
+
+	set, err := r.Client.SetNX(ctx, slowModeKey, time.Now().Unix(), interval).Result()
+	if err != nil {
+		return true, 0, fmt.Errorf("failed to check slow mode: %w", err)
+	}
+
+	if set {
+		return true, 0, nil
+	}
+
+	remaining, err := r.Client.PTTL(ctx, slowModeKey).Result()
+	if err != nil {
+		return false, interval, fmt.Errorf("failed to get slow mode ttl: %w", err)
+	}
+
+	if remaining < 0 {
+		remaining = interval
+	}
+
+	return false, remaining, nil
+}
+
+func (r *RedisClient) SetChatSlowMode(chatID, seconds int) error {
+	ctx := context.Background()
+
+	chatKey := fmt.Sprintf("%s%d", ChatSlowModePrefix, chatID)
+	if err := r.Client.Set(ctx, chatKey, seconds, ChatMetadataTTL).Err(); err != nil {
+		return fmt.Errorf("failed to cache chat slow mode: %w", err)
+	}
+
+	return nil
+}
+
+// GetChatSlowMode returns the cached slow mode interval of a chat. The second
+// return value is false when the chat is not cached.
+func (r *RedisClient) GetChatSlowMode(chatID int) (int, bool, error) {
+	ctx := context.Background()
+
+	chatKey := fmt.Sprintf("%s%d", ChatSlowModePrefix, chatID)
+	value, err := r.Client.Get(ctx, chatKey).Result()
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to get chat slow mode: %w", err)
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid cached slow mode value: %w", err)
+	}
+
+	return seconds, true, nil
+}
+
+// SlidingWindowAllow records a request under key and reports whether it fits
+// into limit requests per window, how many requests the window now holds and
+// how long until the window frees up a slot.
+func (r *RedisClient) SlidingWindowAllow(key string, limit int, window time.Duration) (bool, int, time.Duration, error) {
+	ctx := context.Background()
+
+	now := time.Now()
+	rateKey := RateLimitKeyPrefix + key
+	member := fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())
+
+	result, err := slidingWindowScript.Run(ctx, r.Client, []string{rateKey},
+		now.UnixMilli(), window.Milliseconds(), limit, member,
+	).Slice()
+	if err != nil {
+		return true, 0, 0, fmt.Errorf("failed to run sliding window: %w", err)
+	}
+
+	if len(result) != 3 {
+		return true, 0, 0, fmt.Errorf("unexpected sliding window result: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	count, _ := result[1].(int64)
+	reset, _ := result[2].(int64)
+
+	return allowed == 1, int(count), time.Duration(reset) * time.Millisecond, nil
+}
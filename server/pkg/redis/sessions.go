@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	RevokedSessionKeyPrefix = "revoked_session:"
+	SessionRevokedChannel   = "session_revoked"
+)
+
+// RevokeSession puts a session on the denylist for ttl, which should be at
+// least the lifetime of the access tokens issued for it, and tells every
+// instance to drop the session's connections.
+func (r *RedisClient) RevokeSession(sessionID string, ttl time.Duration) error {
+	ctx := context.Background()
+
+	sessionKey := RevokedSessionKeyPrefix + sessionID
+	if err := r.Client.Set(ctx, sessionKey, time.Now().Unix(), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if err := r.Client.Publish(ctx, SessionRevokedChannel, sessionID).Err(); err != nil {
+		r.logger.WithError(err).WithField("session_id", sessionID).Warn("Failed to publish session revocation")
+	}
+
+	return nil
+}
+
+func (r *RedisClient) IsSessionRevoked(sessionID string) (bool, error) {
+	ctx := context.Background()
+
+	exists, err := r.Client.Exists(ctx, RevokedSessionKeyPrefix+sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check session revocation: %w", err)
+	}
+
+	return exists > 0, nil
+}
+
+func (r *RedisClient) SubscribeSessionRevocations() *redis.PubSub {
+	return r.Client.Subscribe(context.Background(), SessionRevokedChannel)
+}
@@ -0,0 +1,22 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const ThrottleKeyPrefix = "throttle:"
+
+// Throttle reports whether an action identified by key may run now. It
+// allows the action at most once per interval.
+func (r *RedisClient) Throttle(key string, interval time.Duration) (bool, error) {
+	ctx := context.Background()
+
+	allowed, err := r.Client.SetNX(ctx, ThrottleKeyPrefix+key, time.Now().Unix(), interval).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check throttle: %w", err)
+	}
+
+	return allowed, nil
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	LoginChallengeKeyPrefix         = "login_challenge:"
+	LoginChallengeFailuresKeyPrefix = "login_challenge_failures:"
+)
+
+// SetLoginChallenge stores the user a pending two-factor login challenge
+// belongs to.
+func (r *RedisClient) SetLoginChallenge(challengeHash string, userID int, ttl time.Duration) error {
+	ctx := context.Background()
+
+	if err := r.Client.Set(ctx, LoginChallengeKeyPrefix+challengeHash, userID, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store login challenge: %w", err)
+	}
+
+	return nil
+}
+
+// GetLoginChallenge returns the user of a pending login challenge, or 0 if the
+// challenge does not exist or has expired.
+func (r *RedisClient) GetLoginChallenge(challengeHash string) (int, error) {
+	ctx := context.Background()
+
+	value, err := r.Client.Get(ctx, LoginChallengeKeyPrefix+challengeHash).Result()
+	if err != nil {
+		if err.Error() == "redis: nil" {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get login challenge: %w", err)
+	}
+
+	userID, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid login challenge value: %w", err)
+	}
+
+	return userID, nil
+}
+
+// IncrLoginChallengeFailures counts a wrong code entered for a challenge and
+// returns the number of wrong codes so far.
+func (r *RedisClient) IncrLoginChallengeFailures(challengeHash string, ttl time.Duration) (int, error) {
+	ctx := context.Background()
+
+	failuresKey := LoginChallengeFailuresKeyPrefix + challengeHash
+
+	pipe := r.Client.TxPipeline()
+	incr := pipe.Incr(ctx, failuresKey)
+	pipe.Expire(ctx, failuresKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to count login challenge failure: %w", err)
+	}
+
+	return int(incr.Val()), nil
+}
+
+// DeleteLoginChallenge removes a challenge so it cannot be used again. It
+// reports whether the challenge still existed, which makes it safe to use as
+// the single point where a challenge is redeemed.
+func (r *RedisClient) DeleteLoginChallenge(challengeHash string) (bool, error) {
+	ctx := context.Background()
+
+	pipe := r.Client.TxPipeline()
+	del := pipe.Del(ctx, LoginChallengeKeyPrefix+challengeHash)
+	pipe.Del(ctx, LoginChallengeFailuresKeyPrefix+challengeHash)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete login challenge: %w", err)
+	}
+
+	return del.Val() > 0, nil
+}
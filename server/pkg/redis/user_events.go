@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	UserEventsChannel     = "user_events"
+	UserDisconnectChannel = "user_disconnect"
+)
+
+// UserEvent is a notification for every open connection of one user,
+// whichever instance holds it.
+type UserEvent struct {
+	UserID  int             `json:"user_id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func (r *RedisClient) PublishUserEvent(userID int, payload interface{}) error {
+	ctx := context.Background()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user event: %w", err)
+	}
+
+	message, err := json.Marshal(UserEvent{UserID: userID, Payload: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal user event: %w", err)
+	}
+
+	if err := r.Client.Publish(ctx, UserEventsChannel, message).Err(); err != nil {
+		return fmt.Errorf("failed to publish user event: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisClient) SubscribeUserEvents() *redis.PubSub {
+	return r.Client.Subscribe(context.Background(), UserEventsChannel)
+}
+
+// DisconnectUser tells every instance to close all connections of userID,
+// whichever session or token opened them.
+func (r *RedisClient) DisconnectUser(userID int) error {
+	ctx := context.Background()
+
+	if err := r.Client.Publish(ctx, UserDisconnectChannel, strconv.Itoa(userID)).Err(); err != nil {
+		return fmt.Errorf("failed to publish user disconnect: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisClient) SubscribeUserDisconnects() *redis.PubSub {
+	return r.Client.Subscribe(context.Background(), UserDisconnectChannel)
+}
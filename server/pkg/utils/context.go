@@ -34,8 +34,46 @@ func GetUsername(c *gin.Context) (string, error) {
 	return name, nil
 }
 
+func GetSessionID(c *gin.Context) (string, error) {
+	sessionID, exists := c.Get("session_id")
+	if !exists {
+		return "", fmt.Errorf("session ID not found in context")
+	}
 
+	id, ok := sessionID.(string)
+	if !ok {
+		return "", fmt.Errorf("session ID is not of type string")
+	}
 
+	return id, nil
+}
 
+func IsBot(c *gin.Context) bool {
+	return c.GetBool("is_bot")
+}
 
+// IsPersonalToken reports whether the request authenticated with a personal
+// access token rather than a login.
+func IsPersonalToken(c *gin.Context) bool {
+	scopes, _ := c.Get("scopes")
+	list, _ := scopes.([]string)
+	return list != nil
+}
+
+// HasScope reports whether the request may act within scope. Requests from a
+// login session have every scope.
+func HasScope(c *gin.Context, scope string) bool {
+	scopes, _ := c.Get("scopes")
+	list, _ := scopes.([]string)
+	if list == nil {
+		return true
+	}
 
+	for _, granted := range list {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}